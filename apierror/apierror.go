@@ -0,0 +1,200 @@
+package apierror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator"
+	"github.com/yashaswini7291/Inventory/logging"
+)
+
+// Code is a stable, machine readable identifier for a class of error.
+// Clients may switch on it; it never changes once released.
+type Code string
+
+const (
+	CodeInvalidRequest   Code = "invalid_request"
+	CodeValidationFailed Code = "validation_failed"
+	CodeUnauthorized     Code = "unauthorized"
+	CodeForbidden        Code = "forbidden"
+	CodeNotFound         Code = "not_found"
+	CodeConflict         Code = "conflict"
+	CodeTooManyRequests  Code = "too_many_requests"
+	CodeInternal         Code = "internal_error"
+)
+
+// ContentType is the RFC 7807 media type used for every error response.
+const ContentType = "application/problem+json"
+
+// typeBase prefixes the code to build the problem "type" URI.
+const typeBase = "https://inventory.local/problems/"
+
+// FieldError describes a problem with one field of the request body.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is returned by handlers and helpers to describe a failure that
+// should reach the client. Err holds the underlying cause; it is logged
+// but never serialised.
+type Error struct {
+	Status int
+	Code   Code
+	Detail string
+	Fields []FieldError
+	Err    error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Detail, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Detail)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Problem is the RFC 7807 body written for an Error.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      Code         `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+func New(status int, code Code, detail string) *Error {
+	return &Error{Status: status, Code: code, Detail: detail}
+}
+
+func BadRequest(detail string) *Error {
+	return New(http.StatusBadRequest, CodeInvalidRequest, detail)
+}
+
+func Unauthorized(detail string) *Error {
+	return New(http.StatusUnauthorized, CodeUnauthorized, detail)
+}
+
+func Forbidden(detail string) *Error {
+	return New(http.StatusForbidden, CodeForbidden, detail)
+}
+
+func NotFound(detail string) *Error {
+	return New(http.StatusNotFound, CodeNotFound, detail)
+}
+
+func Conflict(detail string) *Error {
+	return New(http.StatusConflict, CodeConflict, detail)
+}
+
+func TooManyRequests(detail string) *Error {
+	return New(http.StatusTooManyRequests, CodeTooManyRequests, detail)
+}
+
+// Internal wraps an unexpected failure. The cause is logged and the client
+// only sees a generic message.
+func Internal(err error) *Error {
+	return &Error{
+		Status: http.StatusInternalServerError,
+		Code:   CodeInternal,
+		Detail: "something went wrong please try after sometime",
+		Err:    err,
+	}
+}
+
+// Validation returns a 422 listing the offending fields.
+func Validation(fields ...FieldError) *Error {
+	return &Error{
+		Status: http.StatusUnprocessableEntity,
+		Code:   CodeValidationFailed,
+		Detail: "one or more fields are invalid",
+		Fields: fields,
+	}
+}
+
+// FromValidator converts the error returned by validator.Struct into a
+// Validation error with one entry per failed field. Field names are those
+// reported by the validator, so register a tag name func to get JSON names.
+func FromValidator(err error) *Error {
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return BadRequest(err.Error())
+	}
+	fields := make([]FieldError, 0, len(verrs))
+	for _, fe := range verrs {
+		fields = append(fields, FieldError{
+			Field:   fe.Field(),
+			Message: validationMessage(fe),
+		})
+	}
+	return Validation(fields...)
+}
+
+// unit names what a min, max or len bound counts for the field's kind.
+func unit(fe validator.FieldError) string {
+	switch fe.Kind() {
+	case reflect.String:
+		return " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return " items"
+	default:
+		return ""
+	}
+}
+
+func validationMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "min":
+		return "must be at least " + fe.Param() + unit(fe)
+	case "max":
+		return "must be at most " + fe.Param() + unit(fe)
+	case "len":
+		return "must be exactly " + fe.Param() + unit(fe)
+	case "email":
+		return "must be a valid email address"
+	case "oneof":
+		return "must be one of " + fe.Param()
+	default:
+		return "failed the " + fe.Tag() + " check"
+	}
+}
+
+// Respond writes err as an application/problem+json response and aborts
+// the handler chain. Errors that are not an *Error are treated as
+// internal errors. 5xx causes are logged with the request logger.
+func Respond(c *gin.Context, err error) {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		apiErr = Internal(err)
+	}
+
+	if apiErr.Status >= http.StatusInternalServerError {
+		logging.FromContext(c.Request.Context()).Error("request failed",
+			"code", apiErr.Code, "error", apiErr.Err)
+	}
+	_ = c.Error(err)
+
+	problem := Problem{
+		Type:      typeBase + string(apiErr.Code),
+		Title:     http.StatusText(apiErr.Status),
+		Status:    apiErr.Status,
+		Detail:    apiErr.Detail,
+		Instance:  c.Request.URL.Path,
+		Code:      apiErr.Code,
+		RequestID: c.GetString("requestId"),
+		Errors:    apiErr.Fields,
+	}
+	c.Header("Content-Type", ContentType)
+	c.AbortWithStatusJSON(apiErr.Status, problem)
+}
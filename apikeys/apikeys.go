@@ -0,0 +1,139 @@
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/yashaswini7291/Inventory/database"
+	"github.com/yashaswini7291/Inventory/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Scopes that can be granted to an API key. Interactive (JWT) sessions are
+// not limited by scopes.
+const (
+	ScopeRead          = "read"
+	ScopeStockAdjust   = "stock:adjust"
+	ScopeProductsWrite = "products:write"
+)
+
+var ValidScopes = map[string]bool{
+	ScopeRead:          true,
+	ScopeStockAdjust:   true,
+	ScopeProductsWrite: true,
+}
+
+// ManagerScopes can only be granted by managers and admins, since they
+// change stock and the catalogue.
+var ManagerScopes = map[string]bool{
+	ScopeStockAdjust:   true,
+	ScopeProductsWrite: true,
+}
+
+// KeyPrefix starts every key so that keys are easy to recognise in
+// configuration files and secret scanners.
+const KeyPrefix = "inv_"
+
+// touchInterval limits how often last_used_at is written for a busy key.
+const touchInterval = time.Minute
+
+var (
+	ErrInvalidKey = errors.New("invalid API key")
+	ErrExpiredKey = errors.New("API key has expired")
+	ErrRevokedKey = errors.New("API key has been revoked")
+)
+
+var APIKeyData *mongo.Collection = database.OpenCollection(database.Client, "APIKeys")
+
+// Generate returns a new plaintext key, the public prefix used to look it
+// up and the hash that is stored. The plaintext is shown to the caller
+// once and never persisted.
+func Generate() (key string, prefix string, hash string, err error) {
+	prefixBytes := make([]byte, 4)
+	secretBytes := make([]byte, 24)
+	if _, err = rand.Read(prefixBytes); err != nil {
+		return "", "", "", err
+	}
+	if _, err = rand.Read(secretBytes); err != nil {
+		return "", "", "", err
+	}
+	prefix = hex.EncodeToString(prefixBytes)
+	key = KeyPrefix + prefix + "_" + hex.EncodeToString(secretBytes)
+	return key, prefix, Hash(key), nil
+}
+
+// Hash returns the hex encoded SHA-256 of key. Keys carry enough entropy
+// that a fast hash is sufficient.
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// LooksLikeKey reports whether s has the shape of an API key rather than
+// a JWT.
+func LooksLikeKey(s string) bool {
+	return strings.HasPrefix(s, KeyPrefix)
+}
+
+func parsePrefix(key string) (string, bool) {
+	parts := strings.Split(strings.TrimPrefix(key, KeyPrefix), "_")
+	if !LooksLikeKey(key) || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", false
+	}
+	return parts[0], true
+}
+
+// Authenticate resolves a plaintext key to its stored record and records
+// when it was last used.
+func Authenticate(ctx context.Context, key string) (*models.APIKey, error) {
+	prefix, ok := parsePrefix(key)
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+
+	var apiKey models.APIKey
+	err := APIKeyData.FindOne(ctx, bson.M{"prefix": prefix}).Decode(&apiKey)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(apiKey.Hash), []byte(Hash(key))) != 1 {
+		return nil, ErrInvalidKey
+	}
+
+	now := time.Now().UTC()
+	if apiKey.RevokedAt != nil {
+		return nil, ErrRevokedKey
+	}
+	if apiKey.ExpiresAt != nil && now.After(*apiKey.ExpiresAt) {
+		return nil, ErrExpiredKey
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > touchInterval {
+		_, err = APIKeyData.UpdateOne(ctx, bson.M{"_id": apiKey.ID}, bson.M{"$set": bson.M{"last_used_at": now}})
+		if err != nil {
+			return nil, err
+		}
+		apiKey.LastUsedAt = &now
+	}
+	return &apiKey, nil
+}
+
+// HasScope reports whether scopes grants scope.
+func HasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
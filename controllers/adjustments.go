@@ -0,0 +1,437 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yashaswini7291/Inventory/apierror"
+	"github.com/yashaswini7291/Inventory/database"
+	"github.com/yashaswini7291/Inventory/logging"
+	"github.com/yashaswini7291/Inventory/models"
+	"github.com/yashaswini7291/Inventory/repository"
+	"github.com/yashaswini7291/Inventory/stock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	AdjustmentCollection *mongo.Collection = database.OpenCollection(database.Client, "StockAdjustments")
+	Adjustments                            = repository.Scoped(AdjustmentCollection)
+)
+
+// AdjustmentReasons are the reason codes a manual stock adjustment can
+// give. ADJUSTMENT_REASONS replaces them with a comma separated list.
+var AdjustmentReasons = adjustmentReasons()
+
+// A manual adjustment of more than AdjustmentApprovalQuantity units, or
+// worth more than AdjustmentApprovalValue at the product's average cost,
+// waits for a manager's approval. They are read from
+// ADJUSTMENT_APPROVAL_QUANTITY and ADJUSTMENT_APPROVAL_VALUE and default to
+// 100 and 1000; 0 turns a threshold off.
+var (
+	AdjustmentApprovalQuantity = int(adjustmentThreshold("ADJUSTMENT_APPROVAL_QUANTITY", 100))
+	AdjustmentApprovalValue    = adjustmentThreshold("ADJUSTMENT_APPROVAL_VALUE", 1000)
+)
+
+// AdjustmentApprovalWindow is how far back the manual adjustments of a
+// user count towards the approval thresholds, so that a large adjustment
+// split into small ones still needs approval.
+const AdjustmentApprovalWindow = 24 * time.Hour
+
+func adjustmentReasons() []string {
+	reasons := make([]string, 0)
+	for _, reason := range strings.Split(os.Getenv("ADJUSTMENT_REASONS"), ",") {
+		if reason = strings.ToLower(strings.TrimSpace(reason)); reason != "" {
+			reasons = append(reasons, reason)
+		}
+	}
+	if len(reasons) == 0 {
+		return []string{"damage", "theft", "found", "correction", "sample"}
+	}
+	return reasons
+}
+
+func adjustmentThreshold(key string, fallback float64) float64 {
+	if v, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil && v >= 0 {
+		return v
+	}
+	return fallback
+}
+
+type adjustmentRequest struct {
+	Quantity int    `json:"quantity" validate:"min=0"`
+	Reason   string `json:"reason" validate:"required,max=50"`
+	Note     string `json:"note" validate:"max=2000"`
+}
+
+type rejectAdjustmentRequest struct {
+	Reason string `json:"reason" validate:"max=2000"`
+}
+
+// AdjustmentPage is one page of stock adjustments.
+type AdjustmentPage struct {
+	Items []models.StockAdjustment `json:"items"`
+	Page  int                      `json:"page"`
+	Limit int                      `json:"limit"`
+	Total int64                    `json:"total"`
+}
+
+// validReason tells whether reason is one of AdjustmentReasons.
+func validReason(reason string) bool {
+	return slices.Contains(AdjustmentReasons, reason)
+}
+
+// adjustmentValue is what delta units of product are worth at its average
+// cost, or its standard cost while it has none.
+func adjustmentValue(product *models.Product, delta int) float64 {
+	unitCost := product.AverageCost
+	if unitCost == 0 {
+		unitCost = product.StandardCost
+	}
+	return roundMoney(float64(max(delta, -delta)) * unitCost)
+}
+
+// needsApproval tells whether an adjustment of delta units worth value is
+// above a threshold. Callers add the user's recent adjustments to both.
+func needsApproval(delta int, value float64) bool {
+	return (AdjustmentApprovalQuantity > 0 && max(delta, -delta) > AdjustmentApprovalQuantity) ||
+		(AdjustmentApprovalValue > 0 && value > AdjustmentApprovalValue)
+}
+
+// adjustmentVolume is the units and value of a set of adjustments.
+type adjustmentVolume struct {
+	Units int     `bson:"units"`
+	Value float64 `bson:"value"`
+}
+
+// sumVolume runs pipeline, which groups into one adjustmentVolume.
+func sumVolume(ctx context.Context, coll *repository.Collection, pipeline mongo.Pipeline) (adjustmentVolume, error) {
+	var volume adjustmentVolume
+	cursor, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return volume, err
+	}
+	defer cursor.Close(ctx)
+	if cursor.Next(ctx) {
+		err = cursor.Decode(&volume)
+	}
+	if err == nil {
+		err = cursor.Err()
+	}
+	return volume, err
+}
+
+// recentAdjustments sums what uid adjusted of a product by hand since
+// AdjustmentApprovalWindow before now, along with uid's adjustments of it
+// waiting for approval. Approved adjustments were reviewed already and do
+// not count.
+func recentAdjustments(ctx context.Context, productID primitive.ObjectID, uid string, now time.Time) (adjustmentVolume, error) {
+	posted, err := sumVolume(ctx, stock.Movements, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"product_id":  productID,
+			"type":        models.MovementAdjustment,
+			"created_by":  uid,
+			"reason":      bson.M{"$exists": true},
+			"ref_type":    bson.M{"$exists": false},
+			"createdTime": bson.M{"$gte": now.Add(-AdjustmentApprovalWindow)},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   nil,
+			"units": bson.M{"$sum": bson.M{"$abs": "$quantity"}},
+			"value": bson.M{"$sum": bson.M{"$abs": "$value"}},
+		}}},
+	})
+	if err != nil {
+		return posted, err
+	}
+	waiting, err := sumVolume(ctx, Adjustments, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"product_id": productID, "requested_by": uid, "status": models.AdjustmentPending}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   nil,
+			"units": bson.M{"$sum": bson.M{"$abs": "$delta"}},
+			"value": bson.M{"$sum": "$value"},
+		}}},
+	})
+	return adjustmentVolume{Units: posted.Units + waiting.Units, Value: posted.Value + waiting.Value}, err
+}
+
+// checkAdjustable refuses changes of delta units that adjustStock cannot
+// post for product, before they are posted or wait for approval: serial
+// numbers and new lots have to come in through receipts.
+func checkAdjustable(product *models.Product, delta int) error {
+	switch {
+	case product.TrackSerials:
+		return apierror.Validation(apierror.FieldError{Field: "quantity", Message: "products that track serial numbers cannot be adjusted this way"})
+	case product.TrackLots && delta > 0:
+		return apierror.Validation(apierror.FieldError{Field: "quantity", Message: "products that track lots can only be decreased this way"})
+	}
+	return nil
+}
+
+// adjustStock posts a manual adjustment of delta units like m. Increases go
+// to MAIN, decreases are taken from MAIN first and then from the other
+// locations. It must run inside a transaction.
+func adjustStock(ctx context.Context, m models.StockMovement, delta int) error {
+	var err error
+	if delta > 0 {
+		m.Quantity = delta
+		err = stock.Post(ctx, &m)
+	} else {
+		err = stock.Issue(ctx, m, -delta)
+	}
+	return stockError(err)
+}
+
+// findAdjustment loads the stock adjustment named by :id.
+func findAdjustment(c *gin.Context, ctx context.Context) (*models.StockAdjustment, error) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return nil, apierror.BadRequest("Invalid adjustment ID")
+	}
+	var adjustment models.StockAdjustment
+	err = Adjustments.FindOne(ctx, bson.M{"_id": objID}).Decode(&adjustment)
+	if err == mongo.ErrNoDocuments {
+		return nil, apierror.NotFound("Adjustment not found")
+	}
+	if err != nil {
+		return nil, apierror.Internal(err)
+	}
+	return &adjustment, nil
+}
+
+// ListAdjustments godoc
+// @Summary List manual stock adjustments that needed approval
+// @Tags Adjustments
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Produce json
+// @Param status query string false "pending (default), approved or rejected"
+// @Param product_id query string false "Only adjustments of this product"
+// @Param page query int false "Page number, from 1"
+// @Param limit query int false "Page size, at most 100"
+// @Success 200 {object} AdjustmentPage
+// @Failure 400 {object} apierror.Problem
+// @Router /adjustments [get]
+func ListAdjustments() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+
+		page, limit := pagination(c)
+		status := c.DefaultQuery("status", models.AdjustmentPending)
+		switch status {
+		case models.AdjustmentPending, models.AdjustmentApproved, models.AdjustmentRejected:
+		default:
+			apierror.Respond(c, apierror.BadRequest("Invalid status, use pending, approved or rejected"))
+			return
+		}
+		filter := bson.M{"status": status}
+		if productID := c.Query("product_id"); productID != "" {
+			objID, err := primitive.ObjectIDFromHex(productID)
+			if err != nil {
+				apierror.Respond(c, apierror.BadRequest("Invalid product ID"))
+				return
+			}
+			filter["product_id"] = objID
+		}
+		total, err := Adjustments.CountDocuments(ctx, filter)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		opts := options.Find().
+			SetSort(bson.D{{Key: "createdTime", Value: -1}, {Key: "_id", Value: -1}}).
+			SetSkip(int64((page - 1) * limit)).
+			SetLimit(int64(limit))
+		cursor, err := Adjustments.Find(ctx, filter, opts)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		defer cursor.Close(ctx)
+
+		adjustments := make([]models.StockAdjustment, 0)
+		if err := cursor.All(ctx, &adjustments); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		c.JSON(http.StatusOK, AdjustmentPage{Items: adjustments, Page: page, Limit: limit, Total: total})
+	}
+}
+
+// GetAdjustment godoc
+// @Summary Get a manual stock adjustment
+// @Tags Adjustments
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Produce json
+// @Param id path string true "Adjustment ID"
+// @Success 200 {object} models.StockAdjustment
+// @Failure 400,404 {object} apierror.Problem
+// @Router /adjustments/{id} [get]
+func GetAdjustment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		adjustment, err := findAdjustment(c, ctx)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, adjustment)
+	}
+}
+
+// ApproveAdjustment godoc
+// @Summary Approve a pending stock adjustment
+// @Description Posts the adjustment's delta to the stock ledger. It is refused while the on hand stock differs from what it was at the request, and on hand stock still cannot drop below the reserved stock. The user who asked for the adjustment cannot approve it.
+// @Tags Adjustments
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Adjustment ID"
+// @Success 200 {object} models.StockAdjustment
+// @Failure 400,403,404,409 {object} apierror.Problem
+// @Router /adjustments/{id}/approve [post]
+func ApproveAdjustment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+
+		found, err := findAdjustment(c, ctx)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		uid := c.GetString("uid")
+		if found.RequestedBy == uid {
+			apierror.Respond(c, apierror.Forbidden("an adjustment must be approved by another user"))
+			return
+		}
+
+		var adjustment models.StockAdjustment
+		err = database.Transaction(ctx, func(sc mongo.SessionContext) error {
+			if err := Adjustments.FindOne(sc, bson.M{"_id": found.ID}).Decode(&adjustment); err != nil {
+				return err
+			}
+			if adjustment.Status != models.AdjustmentPending {
+				return apierror.Conflict("adjustment is " + adjustment.Status)
+			}
+			product, err := findProduct(sc, adjustment.ProductId)
+			if err != nil {
+				return err
+			}
+			// Delta was worked out from the stock at the time; once that
+			// moved, it no longer gives the quantity that was asked for.
+			if product.OnHand != adjustment.OnHand {
+				return apierror.Conflict(fmt.Sprintf("on hand stock changed from %d to %d since the adjustment was requested", adjustment.OnHand, product.OnHand))
+			}
+			// On hand stock cannot drop below what is already reserved.
+			if product.OnHand+adjustment.Delta < product.Reserved {
+				return apierror.Conflict(fmt.Sprintf("%d units are reserved", product.Reserved))
+			}
+			err = adjustStock(sc, models.StockMovement{
+				ProductId: adjustment.ProductId,
+				Type:      models.MovementAdjustment,
+				RefType:   "stock_adjustment",
+				RefId:     adjustment.ID.Hex(),
+				Reason:    adjustment.Reason,
+				Note:      adjustment.Note,
+				CreatedBy: adjustment.RequestedBy,
+			}, adjustment.Delta)
+			if err != nil {
+				return err
+			}
+
+			now := time.Now().UTC()
+			adjustment.Status = models.AdjustmentApproved
+			adjustment.ReviewedBy = uid
+			adjustment.ReviewedAt = &now
+			adjustment.UpdatedTime = now
+			update := bson.M{"$set": bson.M{
+				"status":      adjustment.Status,
+				"reviewed_by": uid,
+				"reviewed_at": now,
+				"updatedTime": now,
+			}}
+			_, err = Adjustments.UpdateOne(sc, bson.M{"_id": adjustment.ID}, update)
+			return err
+		})
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		logging.FromContext(ctx).Info("stock adjustment approved", "adjustmentId", adjustment.ID.Hex(), "productId", adjustment.ProductId.Hex(), "delta", adjustment.Delta)
+		c.JSON(http.StatusOK, adjustment)
+	}
+}
+
+// RejectAdjustment godoc
+// @Summary Reject a pending stock adjustment
+// @Description The stock is left as it is.
+// @Tags Adjustments
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Adjustment ID"
+// @Param body body rejectAdjustmentRequest false "Why it was rejected"
+// @Success 200 {object} models.StockAdjustment
+// @Failure 400,403,404,409,422 {object} apierror.Problem
+// @Router /adjustments/{id}/reject [post]
+func RejectAdjustment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		var req rejectAdjustmentRequest
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				apierror.Respond(c, apierror.BadRequest("Invalid request payload"))
+				return
+			}
+		}
+		if err := Validate.Struct(req); err != nil {
+			apierror.Respond(c, apierror.FromValidator(err))
+			return
+		}
+		found, err := findAdjustment(c, ctx)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		now := time.Now().UTC()
+		set := bson.M{
+			"status":      models.AdjustmentRejected,
+			"reviewed_by": c.GetString("uid"),
+			"reviewed_at": now,
+			"updatedTime": now,
+		}
+		if req.Reason != "" {
+			set["reject_reason"] = req.Reason
+		}
+		filter := bson.M{"_id": found.ID, "status": models.AdjustmentPending}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		var adjustment models.StockAdjustment
+		err = Adjustments.FindOneAndUpdate(ctx, filter, bson.M{"$set": set}, opts).Decode(&adjustment)
+		if err == mongo.ErrNoDocuments {
+			apierror.Respond(c, apierror.Conflict("adjustment is "+found.Status))
+			return
+		}
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		logging.FromContext(ctx).Info("stock adjustment rejected", "adjustmentId", adjustment.ID.Hex())
+		c.JSON(http.StatusOK, adjustment)
+	}
+}
@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yashaswini7291/Inventory/apierror"
+	"github.com/yashaswini7291/Inventory/apikeys"
+	"github.com/yashaswini7291/Inventory/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type createAPIKeyRequest struct {
+	Name          string   `json:"name" validate:"required,max=64"`
+	Scopes        []string `json:"scopes" validate:"required,min=1"`
+	ExpiresInDays int      `json:"expires_in_days" validate:"min=0,max=730"`
+}
+
+// CreateAPIKey godoc
+// @Summary Create an API key for the current user
+// @Description The plaintext key is only returned in this response. Only managers and admins may grant stock:adjust and products:write. A key acts with its owner's current role.
+// @Tags API Keys
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param key body createAPIKeyRequest true "Key name, scopes and optional expiry"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400,403,422 {object} apierror.Problem
+// @Router /api-keys [post]
+func CreateAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		var req createAPIKeyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid request payload"))
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			apierror.Respond(c, apierror.FromValidator(err))
+			return
+		}
+		for _, scope := range req.Scopes {
+			if !apikeys.ValidScopes[scope] {
+				apierror.Respond(c, apierror.Validation(apierror.FieldError{Field: "scopes", Message: "unknown scope " + scope}))
+				return
+			}
+		}
+		if role := c.GetString("role"); role != models.RoleManager && role != models.RoleAdmin {
+			for _, scope := range req.Scopes {
+				if apikeys.ManagerScopes[scope] {
+					apierror.Respond(c, apierror.Forbidden("only managers and admins may grant the "+scope+" scope"))
+					return
+				}
+			}
+		}
+
+		key, prefix, hash, err := apikeys.Generate()
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		now := time.Now().UTC()
+		apiKey := models.APIKey{
+			ID:          primitive.NewObjectID(),
+			Name:        req.Name,
+			Prefix:      prefix,
+			Hash:        hash,
+			UserId:      c.GetString("uid"),
+			UserName:    c.GetString("userName"),
+			Scopes:      req.Scopes,
+			CreatedTime: now,
+		}
+		if req.ExpiresInDays > 0 {
+			expiresAt := now.AddDate(0, 0, req.ExpiresInDays)
+			apiKey.ExpiresAt = &expiresAt
+		}
+		if _, err := apikeys.APIKeyData.InsertOne(ctx, apiKey); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"key":     key,
+			"api_key": apiKey,
+		})
+	}
+}
+
+// ListAPIKeys godoc
+// @Summary List the current user's API keys
+// @Tags API Keys
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {array} models.APIKey
+// @Failure 500 {object} apierror.Problem
+// @Router /api-keys [get]
+func ListAPIKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		opts := options.Find().SetSort(bson.M{"createdTime": -1})
+		cursor, err := apikeys.APIKeyData.Find(ctx, bson.M{"userId": c.GetString("uid")}, opts)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		defer cursor.Close(ctx)
+
+		keys := make([]models.APIKey, 0)
+		if err := cursor.All(ctx, &keys); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		c.JSON(http.StatusOK, keys)
+	}
+}
+
+// RevokeAPIKey godoc
+// @Summary Revoke one of the current user's API keys
+// @Tags API Keys
+// @Security BearerAuth
+// @Param id path string true "API key ID"
+// @Success 204
+// @Failure 400,404 {object} apierror.Problem
+// @Router /api-keys/{id} [delete]
+func RevokeAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid API key ID"))
+			return
+		}
+
+		filter := bson.M{"_id": objID, "userId": c.GetString("uid"), "revoked_at": bson.M{"$exists": false}}
+		update := bson.M{"$set": bson.M{"revoked_at": time.Now().UTC()}}
+		result, err := apikeys.APIKeyData.UpdateOne(ctx, filter, update)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		if result.MatchedCount == 0 {
+			apierror.Respond(c, apierror.NotFound("API key not found"))
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
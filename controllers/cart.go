@@ -0,0 +1,361 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yashaswini7291/Inventory/apierror"
+	"github.com/yashaswini7291/Inventory/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Cart is the current user's cart with its totals.
+type Cart struct {
+	Items         []models.ProductUser `json:"items"`
+	TotalQuantity int                  `json:"total_quantity"`
+	Total         float64              `json:"total"`
+}
+
+type addToCartRequest struct {
+	ProductId string `json:"product_id" validate:"required"`
+	Quantity  int    `json:"quantity" validate:"required,min=1"`
+}
+
+type cartQuantityRequest struct {
+	Quantity *int `json:"quantity" validate:"required,min=0"`
+}
+
+func newCart(items []models.ProductUser) Cart {
+	cart := Cart{Items: items}
+	if cart.Items == nil {
+		cart.Items = make([]models.ProductUser, 0)
+	}
+	for _, item := range cart.Items {
+		cart.TotalQuantity += item.Quantity
+		cart.Total += float64(item.Quantity) * item.Price
+	}
+	cart.Total = roundMoney(cart.Total)
+	return cart
+}
+
+// roundMoney rounds to whole cents.
+func roundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+func loadCart(ctx context.Context, uid string) ([]models.ProductUser, error) {
+	user, err := findUserByID(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	return user.UserCart, nil
+}
+
+func saveCart(ctx context.Context, uid string, items []models.ProductUser) error {
+	if items == nil {
+		items = make([]models.ProductUser, 0)
+	}
+	update := bson.M{"$set": bson.M{"usercart": items, "updatedTime": time.Now().UTC()}}
+	if _, err := UserCollection.UpdateOne(ctx, bson.M{"userId": uid}, update); err != nil {
+		return apierror.Internal(err)
+	}
+	return nil
+}
+
+// updateCart applies update to the user's cart when their document also
+// matches filter, and returns the cart after the update. Each change is a
+// single atomic update of the affected line, so concurrent requests of the
+// same user cannot undo each other. ok is false when filter did not match.
+func updateCart(ctx context.Context, uid string, filter bson.M, update bson.M) (items []models.ProductUser, ok bool, err error) {
+	filter["userId"] = uid
+	set, _ := update["$set"].(bson.M)
+	if set == nil {
+		set = bson.M{}
+	}
+	set["updatedTime"] = time.Now().UTC()
+	update["$set"] = set
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"usercart": 1})
+	var user models.User
+	err = UserCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, apierror.Internal(err)
+	}
+	return user.UserCart, true, nil
+}
+
+// checkStock fails when quantity units of product cannot be supplied from
+// the stock that is not already reserved.
+func checkStock(product *models.Product, quantity int) error {
+	if available := product.Available(); quantity > available {
+		return apierror.Conflict(fmt.Sprintf("only %d of %s available", max(available, 0), product.Name))
+	}
+	return nil
+}
+
+// cartLine snapshots the product, at its current price, as a cart line.
+func cartLine(product *models.Product, quantity int) models.ProductUser {
+	return models.ProductUser{
+		ProductId:   product.ProductId,
+		Name:        product.Name,
+		Type:        product.Type,
+		SKU:         product.SKU,
+		ImageURL:    product.ImageURL,
+		Description: product.Description,
+		Quantity:    quantity,
+		Price:       product.Price,
+	}
+}
+
+func cartProductID(c *gin.Context) (primitive.ObjectID, error) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return objID, apierror.BadRequest("Invalid product ID")
+	}
+	return objID, nil
+}
+
+// GetCart godoc
+// @Summary Get the current user's cart
+// @Tags Cart
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} Cart
+// @Failure 401,403 {object} apierror.Problem
+// @Router /cart [get]
+func GetCart() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		items, err := loadCart(ctx, c.GetString("uid"))
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, newCart(items))
+	}
+}
+
+// AddToCart godoc
+// @Summary Add a product to the cart
+// @Description Adding a product that is already in the cart increases its quantity. The line keeps the price the product had when it was first added.
+// @Tags Cart
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param body body addToCartRequest true "Product and quantity"
+// @Success 200 {object} Cart
+// @Failure 400,404,409,422 {object} apierror.Problem
+// @Router /cart [post]
+func AddToCart() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		var req addToCartRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid request payload"))
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			apierror.Respond(c, apierror.FromValidator(err))
+			return
+		}
+		objID, err := primitive.ObjectIDFromHex(req.ProductId)
+		if err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid product ID"))
+			return
+		}
+		product, err := findProduct(ctx, objID)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		uid := c.GetString("uid")
+
+		// The quantity already in the cart is part of the stock check, so
+		// the increase only applies while the line still holds at most
+		// what the check allowed for. A new line is only pushed while the
+		// product is not in the cart. Between the two another request may
+		// add or remove the line, in which case both are tried again.
+		for attempt := 0; attempt < 3; attempt++ {
+			line := bson.M{"_id": objID, "quantity": bson.M{"$lte": product.Available() - req.Quantity}}
+			items, ok, err := updateCart(ctx, uid,
+				bson.M{"usercart": bson.M{"$elemMatch": line}},
+				bson.M{"$inc": bson.M{"usercart.$.quantity": req.Quantity}})
+			if err != nil {
+				apierror.Respond(c, err)
+				return
+			}
+			if ok {
+				c.JSON(http.StatusOK, newCart(items))
+				return
+			}
+
+			items, err = loadCart(ctx, uid)
+			if err != nil {
+				apierror.Respond(c, err)
+				return
+			}
+			inCart := 0
+			for _, item := range items {
+				if item.ProductId == objID {
+					inCart = item.Quantity
+				}
+			}
+			if err := checkStock(product, inCart+req.Quantity); err != nil {
+				apierror.Respond(c, err)
+				return
+			}
+			if inCart > 0 {
+				continue
+			}
+			items, ok, err = updateCart(ctx, uid,
+				bson.M{"usercart._id": bson.M{"$ne": objID}},
+				bson.M{"$push": bson.M{"usercart": cartLine(product, req.Quantity)}})
+			if err != nil {
+				apierror.Respond(c, err)
+				return
+			}
+			if ok {
+				c.JSON(http.StatusOK, newCart(items))
+				return
+			}
+		}
+		apierror.Respond(c, apierror.Conflict("The cart changed while adding the product, try again"))
+	}
+}
+
+// UpdateCartItem godoc
+// @Summary Change the quantity of a cart line
+// @Description A quantity of 0 removes the line.
+// @Tags Cart
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param body body cartQuantityRequest true "New quantity"
+// @Success 200 {object} Cart
+// @Failure 400,404,409,422 {object} apierror.Problem
+// @Router /cart/{id} [patch]
+func UpdateCartItem() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		objID, err := cartProductID(c)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		var req cartQuantityRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid request payload"))
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			apierror.Respond(c, apierror.FromValidator(err))
+			return
+		}
+		uid := c.GetString("uid")
+
+		var items []models.ProductUser
+		var ok bool
+		if *req.Quantity == 0 {
+			items, ok, err = removeCartLine(ctx, uid, objID)
+		} else {
+			product, findErr := findProduct(ctx, objID)
+			if findErr != nil {
+				apierror.Respond(c, findErr)
+				return
+			}
+			if err := checkStock(product, *req.Quantity); err != nil {
+				apierror.Respond(c, err)
+				return
+			}
+			items, ok, err = updateCart(ctx, uid,
+				bson.M{"usercart._id": objID},
+				bson.M{"$set": bson.M{"usercart.$.quantity": *req.Quantity}})
+		}
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		if !ok {
+			apierror.Respond(c, apierror.NotFound("Product is not in the cart"))
+			return
+		}
+		c.JSON(http.StatusOK, newCart(items))
+	}
+}
+
+// removeCartLine takes the product out of the user's cart. ok is false when
+// it was not in the cart.
+func removeCartLine(ctx context.Context, uid string, productID primitive.ObjectID) (items []models.ProductUser, ok bool, err error) {
+	return updateCart(ctx, uid,
+		bson.M{"usercart._id": productID},
+		bson.M{"$pull": bson.M{"usercart": bson.M{"_id": productID}}})
+}
+
+// RemoveCartItem godoc
+// @Summary Remove a product from the cart
+// @Tags Cart
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Product ID"
+// @Success 200 {object} Cart
+// @Failure 400,404 {object} apierror.Problem
+// @Router /cart/{id} [delete]
+func RemoveCartItem() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		objID, err := cartProductID(c)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		items, ok, err := removeCartLine(ctx, c.GetString("uid"), objID)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		if !ok {
+			apierror.Respond(c, apierror.NotFound("Product is not in the cart"))
+			return
+		}
+		c.JSON(http.StatusOK, newCart(items))
+	}
+}
+
+// ClearCart godoc
+// @Summary Empty the cart
+// @Tags Cart
+// @Security BearerAuth
+// @Success 204
+// @Router /cart [delete]
+func ClearCart() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		if err := saveCart(ctx, c.GetString("uid"), nil); err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -2,35 +2,61 @@ package controllers
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
-	"log"
 	"net/http"
+	"os"
+	"reflect"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator"
+	"github.com/yashaswini7291/Inventory/apierror"
 	"github.com/yashaswini7291/Inventory/database"
+	"github.com/yashaswini7291/Inventory/lockout"
+	"github.com/yashaswini7291/Inventory/logging"
 	"github.com/yashaswini7291/Inventory/models"
+	"github.com/yashaswini7291/Inventory/repository"
+	"github.com/yashaswini7291/Inventory/stock"
 	"github.com/yashaswini7291/Inventory/tokens"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/crypto/bcrypt"
 )
 
 var (
 	UserCollection    *mongo.Collection = database.UserData(database.Client, "Users")
 	ProductCollection *mongo.Collection = database.ProductData(database.Client, "Products")
-	Validate                            = validator.New()
+	// Products is ProductCollection restricted to the caller's organization.
+	// Handlers must use it rather than ProductCollection.
+	Products = repository.Scoped(ProductCollection)
+	Validate = newValidator()
 )
 
-func HashPassword(password string) string {
+// newValidator reports field errors under their JSON names so they match
+// the request body the client sent.
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return v
+}
+
+func HashPassword(password string) (string, error) {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 10)
 	if err != nil {
-		log.Panic(err)
+		return "", err
 	}
-	return string(bytes)
+	return string(bytes), nil
 }
 
 func VerifyPassword(userPassword string, givenPassword string) (bool, string) {
@@ -44,58 +70,118 @@ func VerifyPassword(userPassword string, givenPassword string) (bool, string) {
 	return valid, msg
 }
 
+// BootstrapSecretHeader carries BOOTSTRAP_SECRET when a bootstrap admin
+// registers.
+const BootstrapSecretHeader = "X-Bootstrap-Secret"
+
+// signUpRole gives the usernames listed in BOOTSTRAP_ADMINS the admin role
+// so a fresh deployment has someone who can manage users. The list is only
+// honored while there is no admin, and only for requests that carry
+// BOOTSTRAP_SECRET, so nobody can claim a listed username first.
+func signUpRole(c *gin.Context, ctx context.Context, userName string) (string, error) {
+	secret := os.Getenv("BOOTSTRAP_SECRET")
+	given := c.GetHeader(BootstrapSecretHeader)
+	if secret == "" || subtle.ConstantTimeCompare([]byte(given), []byte(secret)) != 1 {
+		return models.RoleUser, nil
+	}
+	listed := false
+	for _, admin := range strings.Split(os.Getenv("BOOTSTRAP_ADMINS"), ",") {
+		if admin = strings.TrimSpace(admin); admin != "" && admin == userName {
+			listed = true
+		}
+	}
+	if !listed {
+		return models.RoleUser, nil
+	}
+	admins, err := UserCollection.CountDocuments(ctx, bson.M{"role": models.RoleAdmin})
+	if err != nil {
+		return "", err
+	}
+	if admins > 0 {
+		return models.RoleUser, nil
+	}
+	return models.RoleAdmin, nil
+}
+
+// signUpRequest is everything a user chooses when registering. Role,
+// status and organization are the server's to set: an organization is
+// only joined through CreateOrganization or AcceptInvitation.
+type signUpRequest struct {
+	UserName *string `json:"username" validate:"required,min=2,max=30"`
+	Email    *string `json:"email" validate:"omitempty,email"`
+	Password *string `json:"password" validate:"required"`
+}
+
 // SignUp godoc
 // @Summary Register a new user
 // @Tags Auth
 // @Accept  json
 // @Produce  json
-// @Param user body models.User true "User Info"
+// @Param user body signUpRequest true "User Info"
 // @Success 201 {string} string "account created successfully"
-// @Failure 400 {object} map[string]string
-// @Failure 409 {object} map[string]string
+// @Failure 400,422 {object} apierror.Problem
+// @Failure 409 {object} apierror.Problem
 // @Router /register [post]
 func SignUp() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 100*time.Second)
 		defer cancel()
-		var user models.User
-		if err := c.BindJSON(&user); err != nil {
-			c.JSON(400, gin.H{"error": "Invalid request payload"})
+		var req signUpRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid request payload"))
 			return
 		}
 		// Validate the user input
-		if validationErr := Validate.Struct(user); validationErr != nil {
-			c.JSON(400, gin.H{"error": validationErr.Error()})
+		if validationErr := Validate.Struct(req); validationErr != nil {
+			apierror.Respond(c, apierror.FromValidator(validationErr))
+			return
+		}
+		user := models.User{UserName: req.UserName, Email: req.Email, Password: req.Password}
+		if err := checkPassword("password", *user.Password, *user.UserName); err != nil {
+			apierror.Respond(c, err)
 			return
 		}
 		count, err := UserCollection.CountDocuments(ctx, bson.M{"username": user.UserName})
 		if err != nil {
-			log.Panic(err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+			apierror.Respond(c, apierror.Internal(err))
 			return
 		}
-		log.Println("Count of user with same username", count)
+		logging.FromContext(ctx).Debug("users with same username", "username", *user.UserName, "count", count)
 		if count > 0 {
-			c.JSON(http.StatusConflict, gin.H{"error": "user already exist"})
+			apierror.Respond(c, apierror.Conflict("user already exist"))
+			return
+		}
+		password, err := HashPassword(*user.Password)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
 			return
 		}
-		password := HashPassword(*user.Password)
 		user.Password = &password
 		user.CreatedTime, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		user.UpdatedTime, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		user.ID = primitive.NewObjectID()
 		user.UserId = user.ID.Hex()
-		token, refreshtoken, _ := tokens.TokenGenerator(*user.UserName)
+		token, refreshtoken, err := tokens.TokenGenerator(*user.UserName, user.UserId, user.OrgId)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
 		user.Token = &token
 		user.RefreshToken = &refreshtoken
 		user.UserCart = make([]models.ProductUser, 0)
+		user.MFAEnabled = false
+		user.Role, err = signUpRole(c, ctx, *user.UserName)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		user.Status = models.StatusActive
 		_, err = UserCollection.InsertOne(ctx, user)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "user creation failed"})
+			apierror.Respond(c, apierror.Internal(err))
+			return
 		}
 
-		defer cancel()
-
 		c.JSON(http.StatusCreated, "account created successfully")
 	}
 }
@@ -105,57 +191,107 @@ func SignUp() gin.HandlerFunc {
 // @Tags Auth
 // @Accept  json
 // @Produce  json
+// @Description When two-factor authentication is enabled the response is {"status": "mfa_required", "mfa_token": "..."}; complete the login with /login/mfa.
+// @Description Repeated failures slow down and then temporarily lock the username and client IP; the 429 response carries Retry-After.
 // @Param user body models.User true "Credentials"
 // @Success 200 {object} map[string]string
-// @Failure 400,500 {object} map[string]string
+// @Failure 400,401,403,429 {object} apierror.Problem
 // @Router /login [post]
 func Login() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 100*time.Second)
 		defer cancel()
 		var user models.User
 		var founduser models.User
 
-		if err := c.BindJSON(&user); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err})
+		if err := c.ShouldBindJSON(&user); err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid request payload"))
 			return
 		}
-		err := UserCollection.FindOne(ctx, bson.M{"username": user.UserName}).Decode(&founduser)
-		defer cancel()
-
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "No User Found"})
+		if user.UserName == nil || user.Password == nil {
+			apierror.Respond(c, apierror.BadRequest("username and password are required"))
+			return
+		}
+		keys := loginKeys(c, *user.UserName)
+		if !loginAllowed(c, ctx, keys) {
 			return
 		}
-		passwordIsValid, msg := VerifyPassword(*user.Password, *founduser.Password)
 
-		defer cancel()
+		err := UserCollection.FindOne(ctx, bson.M{"username": user.UserName}).Decode(&founduser)
+		if err != nil && err != mongo.ErrNoDocuments {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		// Unknown users are checked against a dummy hash so they take as
+		// long as a wrong password and get the same answer.
+		hash := dummyPasswordHash
+		if err == nil {
+			hash = *founduser.Password
+		}
+		passwordIsValid, _ := VerifyPassword(*user.Password, hash)
 
-		if !passwordIsValid {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
-			fmt.Println(msg)
+		if err == mongo.ErrNoDocuments || !passwordIsValid {
+			loginFailed(c, ctx, keys, *user.UserName)
+			return
+		}
+		if founduser.Status == models.StatusDisabled {
+			apierror.Respond(c, apierror.Forbidden("account is disabled"))
 			return
 		}
 
-		token, refreshToken, _ := tokens.TokenGenerator(*founduser.UserName)
-		defer cancel()
+		// With two factors the failures are only cleared once the second
+		// one succeeds, so the password alone cannot reset the count of
+		// wrong codes.
+		if founduser.MFAEnabled {
+			challenge, err := tokens.ChallengeGenerator(*founduser.UserName, founduser.UserId)
+			if err != nil {
+				apierror.Respond(c, apierror.Internal(err))
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"status": tokens.PurposeMFARequired, "mfa_token": challenge})
+			return
+		}
+		if err := lockout.Reset(ctx, lockout.UserKey(*founduser.UserName)); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
 
-		tokens.UpdateAllTokens(token, refreshToken, founduser.UserId)
+		token, err := issueTokens(ctx, &founduser)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
 
 		c.JSON(http.StatusOK, gin.H{"access_token": token})
 	}
 }
 
+// issueTokens signs a new token pair for user, stores it and returns the
+// access token.
+func issueTokens(ctx context.Context, user *models.User) (string, error) {
+	token, refreshToken, err := tokens.TokenGenerator(*user.UserName, user.UserId, user.OrgId)
+	if err != nil {
+		return "", apierror.Internal(err)
+	}
+	if err := tokens.UpdateAllTokens(ctx, token, refreshToken, user.UserId); err != nil {
+		return "", apierror.Internal(err)
+	}
+	return token, nil
+}
+
 // UpdateProductQuantity godoc
 // @Summary Update the quantity of a product
+// @Description Sets the on hand stock, giving a reason code. It cannot be set below the reserved stock. The difference is posted to the stock ledger as an adjustment: increases go to MAIN, decreases are taken from MAIN first and then from the other locations. Products that track lots can only be decreased this way, first-expiry-first-out. Products that track serial numbers cannot be set this way. Changes above the approval thresholds, counting the caller's adjustments of the product over the last day, are not posted but wait for a manager's approval, with 202 Accepted and the pending adjustment; while one is waiting, another is refused with 409.
 // @Tags Products
 // @Security BearerAuth
+// @Security APIKeyAuth
 // @Accept json
 // @Produce json
 // @Param id path string true "Product ID"
-// @Param quantity body map[string]int true "Quantity to update"
+// @Param body body adjustmentRequest true "Quantity, reason code and note"
 // @Success 200 {object} models.Product
-// @Failure 400 {object} map[string]string
+// @Success 202 {object} models.StockAdjustment
+// @Failure 400,404,409,422 {object} apierror.Problem
 // @Router /products/{id}/quantity [put]
 func UpdateProductQuantity() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -163,29 +299,111 @@ func UpdateProductQuantity() gin.HandlerFunc {
 
 		objID, err := primitive.ObjectIDFromHex(productID)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			apierror.Respond(c, apierror.BadRequest("Invalid product ID"))
 			return
 		}
 
-		var req struct {
-			Quantity int `json:"quantity"`
-		}
+		var req adjustmentRequest
 		if err := c.ShouldBindJSON(&req); err != nil || req.Quantity < 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid quantity"})
+			apierror.Respond(c, apierror.BadRequest("Invalid quantity"))
+			return
+		}
+		req.Reason = strings.ToLower(strings.TrimSpace(req.Reason))
+		if err := Validate.Struct(req); err != nil {
+			apierror.Respond(c, apierror.FromValidator(err))
+			return
+		}
+		if !validReason(req.Reason) {
+			apierror.Respond(c, apierror.Validation(apierror.FieldError{Field: "reason", Message: "must be one of " + strings.Join(AdjustmentReasons, ", ")}))
 			return
 		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
 		defer cancel()
+		trace.SpanFromContext(ctx).SetAttributes(
+			attribute.String("product.id", productID),
+			attribute.Int("product.quantity", req.Quantity),
+		)
 
-		filter := bson.M{"_id": objID}
-		update := bson.M{"$set": bson.M{"quantity": req.Quantity}}
-
-		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
-		var updatedProduct models.Product
-		err = ProductCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updatedProduct)
+		var updatedProduct *models.Product
+		var pending *models.StockAdjustment
+		err = database.Transaction(ctx, func(sc mongo.SessionContext) error {
+			product, err := findProduct(sc, objID)
+			if err != nil {
+				return err
+			}
+			// On hand stock cannot drop below what is already reserved.
+			if product.Reserved > req.Quantity {
+				return apierror.Conflict(fmt.Sprintf("%d units are reserved", product.Reserved))
+			}
+			delta := req.Quantity - product.OnHand
+			if delta == 0 {
+				updatedProduct = product
+				return nil
+			}
+			// Checked here rather than before the transaction, since the
+			// direction of the change depends on the stock read above.
+			if err := checkAdjustable(product, delta); err != nil {
+				return err
+			}
+			now := time.Now().UTC()
+			touch := bson.M{"$set": bson.M{"adjustedTime": now}}
+			if _, err := Products.UpdateOne(sc, bson.M{"_id": objID}, touch); err != nil {
+				return err
+			}
+			recent, err := recentAdjustments(sc, objID, c.GetString("uid"), now)
+			if err != nil {
+				return err
+			}
+			if value := adjustmentValue(product, delta); needsApproval(max(delta, -delta)+recent.Units, value+recent.Value) {
+				// One pending adjustment per product: approving a second
+				// would apply a delta worked out before the first.
+				waiting, err := Adjustments.CountDocuments(sc, bson.M{"product_id": objID, "status": models.AdjustmentPending})
+				if err != nil {
+					return err
+				}
+				if waiting > 0 {
+					return apierror.Conflict("the product already has an adjustment waiting for approval")
+				}
+				pending = &models.StockAdjustment{
+					ID:          primitive.NewObjectID(),
+					ProductId:   objID,
+					SKU:         product.SKU,
+					Name:        product.Name,
+					Quantity:    req.Quantity,
+					OnHand:      product.OnHand,
+					Delta:       delta,
+					Value:       value,
+					Reason:      req.Reason,
+					Note:        req.Note,
+					Status:      models.AdjustmentPending,
+					RequestedBy: c.GetString("uid"),
+					CreatedTime: now,
+					UpdatedTime: now,
+				}
+				_, err = Adjustments.InsertOne(sc, pending)
+				return err
+			}
+			err = adjustStock(sc, models.StockMovement{
+				ProductId: objID,
+				Type:      models.MovementAdjustment,
+				Reason:    req.Reason,
+				Note:      req.Note,
+				CreatedBy: c.GetString("uid"),
+			}, delta)
+			if err != nil {
+				return err
+			}
+			updatedProduct, err = findProduct(sc, objID)
+			return err
+		})
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found or update failed"})
+			apierror.Respond(c, err)
+			return
+		}
+		if pending != nil {
+			logging.FromContext(ctx).Info("stock adjustment awaits approval", "adjustmentId", pending.ID.Hex(), "productId", productID, "delta", pending.Delta)
+			c.JSON(http.StatusAccepted, pending)
 			return
 		}
 
@@ -193,41 +411,108 @@ func UpdateProductQuantity() gin.HandlerFunc {
 	}
 }
 
+// findProduct loads a product of the caller's organization.
+func findProduct(ctx context.Context, id primitive.ObjectID) (*models.Product, error) {
+	var product models.Product
+	err := Products.FindOne(ctx, bson.M{"_id": id}).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		return nil, apierror.NotFound("Product not found")
+	}
+	if err != nil {
+		return nil, apierror.Internal(err)
+	}
+	return &product, nil
+}
+
+// GetProduct godoc
+// @Summary Get a product
+// @Description Products of other organizations are reported as not found.
+// @Tags Products
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Produce json
+// @Param id path string true "Product ID"
+// @Success 200 {object} models.Product
+// @Failure 400,404 {object} apierror.Problem
+// @Router /products/{id} [get]
+func GetProduct() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid product ID"))
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		product, err := findProduct(ctx, objID)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, product)
+	}
+}
+
 // GetAllProducts godoc
 // @Summary Get a list of all products
+// @Description With as_of, on_hand is the stock at that time, rebuilt from the stock ledger, and products created later are left out. Reservations are not kept historically, so reserved is 0.
 // @Tags Products
 // @Security BearerAuth
+// @Security APIKeyAuth
 // @Produce json
+// @Param as_of query string false "Date (end of day, UTC) or RFC 3339 time"
 // @Success 200 {array} models.Product
-// @Failure 500 {object} map[string]string
+// @Failure 400,500 {object} apierror.Problem
 // @Router /products [get]
 func GetAllProducts() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var productList []models.Product
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 100*time.Second)
 		defer cancel()
 
-		cursor, err := ProductCollection.Find(ctx, bson.D{{}})
-
-		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, "something went wrong please try after sometime")
-			return
+		var asOf time.Time
+		if s := c.Query("as_of"); s != "" {
+			var err error
+			if asOf, err = parseAsOf(s); err != nil {
+				apierror.Respond(c, apierror.BadRequest("Invalid as_of: "+err.Error()))
+				return
+			}
 		}
 
-		err = cursor.All(ctx, &productList)
-
+		filter := bson.M{}
+		if !asOf.IsZero() {
+			// Product IDs start with their creation time, in seconds.
+			filter["_id"] = bson.M{"$lt": primitive.NewObjectIDFromTimestamp(asOf.Truncate(time.Second).Add(time.Second))}
+		}
+		cursor, err := Products.Find(ctx, filter)
 		if err != nil {
-			log.Println(err)
-			c.AbortWithStatus(http.StatusInternalServerError)
+			apierror.Respond(c, apierror.Internal(err))
+			return
 		}
 		defer cursor.Close(ctx)
-		if err := cursor.Err(); err != nil {
-			log.Println(err)
-			c.IndentedJSON(400, "invalid")
+
+		if err := cursor.All(ctx, &productList); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
 			return
 		}
 
-		defer cancel()
+		if !asOf.IsZero() {
+			balances, err := stock.Balances(ctx, asOf)
+			if err != nil {
+				apierror.Respond(c, apierror.Internal(err))
+				return
+			}
+			onHand := make(map[primitive.ObjectID]int)
+			for _, b := range balances {
+				onHand[b.ProductId] += b.OnHand
+			}
+			for i := range productList {
+				productList[i].OnHand = onHand[productList[i].ProductId]
+				productList[i].Reserved = 0
+			}
+		}
+
 		c.IndentedJSON(200, productList)
 	}
 }
@@ -236,28 +521,65 @@ func GetAllProducts() gin.HandlerFunc {
 // @Summary Add a new product
 // @Tags Products
 // @Security BearerAuth
+// @Security APIKeyAuth
 // @Accept  json
 // @Produce  json
 // @Param product body models.Product true "Product to Add"
 // @Success 201 {object} map[string]interface{}
-// @Failure 400,500 {object} map[string]string
+// @Failure 400,500 {object} apierror.Problem
 // @Router /products [post]
 func AddProduct() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 100*time.Second)
 		var products models.Product
 		defer cancel()
-		if err := c.BindJSON(&products); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		if err := c.ShouldBindJSON(&products); err != nil {
+			apierror.Respond(c, apierror.BadRequest(err.Error()))
+			return
+		}
+		if products.OnHand < 0 {
+			apierror.Respond(c, apierror.BadRequest("on_hand cannot be negative"))
+			return
+		}
+		switch products.CostMethod {
+		case "", models.CostFIFO, models.CostAverage, models.CostStandard:
+		default:
+			apierror.Respond(c, apierror.BadRequest("cost_method must be fifo, average or standard"))
+			return
+		}
+		if products.StandardCost < 0 {
+			apierror.Respond(c, apierror.BadRequest("standard_cost cannot be negative"))
+			return
+		}
+		if (products.TrackLots || products.TrackSerials) && products.OnHand > 0 {
+			apierror.Respond(c, apierror.BadRequest("products that track lots or serial numbers start without stock, receive it instead"))
 			return
 		}
 		products.ProductId = primitive.NewObjectID()
-		_, err := ProductCollection.InsertOne(ctx, products)
+		products.Reserved = 0
+		products.AverageCost = 0
+		// The initial stock enters through the ledger as an opening balance,
+		// at the standard cost.
+		opening := products.OnHand
+		products.OnHand = 0
+		err := database.Transaction(ctx, func(sc mongo.SessionContext) error {
+			if _, err := Products.InsertOne(sc, &products); err != nil {
+				return err
+			}
+			if opening == 0 {
+				return nil
+			}
+			return stock.Post(sc, &models.StockMovement{
+				ProductId: products.ProductId,
+				Quantity:  opening,
+				Type:      models.MovementOpening,
+				CreatedBy: c.GetString("uid"),
+			})
+		})
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Not Created"})
+			apierror.Respond(c, apierror.Internal(err))
 			return
 		}
-		defer cancel()
 		c.JSON(http.StatusCreated, gin.H{
 			"message":    "Product added successfully",
 			"product_id": products.ProductId.Hex(),
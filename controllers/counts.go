@@ -0,0 +1,630 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yashaswini7291/Inventory/apierror"
+	"github.com/yashaswini7291/Inventory/database"
+	"github.com/yashaswini7291/Inventory/logging"
+	"github.com/yashaswini7291/Inventory/models"
+	"github.com/yashaswini7291/Inventory/repository"
+	"github.com/yashaswini7291/Inventory/stock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	CountCollection *mongo.Collection = database.OpenCollection(database.Client, "CountSessions")
+	Counts                            = repository.Scoped(CountCollection)
+)
+
+type createCountRequest struct {
+	Name string `json:"name" validate:"required,max=100"`
+	// Location, Type, ABCClass and ProductIds pick the products to count.
+	// Products that track serial numbers are never counted this way.
+	Location   string   `json:"location" validate:"max=50"`
+	Type       string   `json:"type" validate:"max=100"`
+	ABCClass   string   `json:"abc_class" validate:"omitempty,oneof=A B C"`
+	ProductIds []string `json:"product_ids" validate:"max=5000,dive,required"`
+	Notes      string   `json:"notes" validate:"max=2000"`
+}
+
+type countLineRequest struct {
+	// ProductId or SKU names the product.
+	ProductId string `json:"product_id"`
+	SKU       string `json:"sku" validate:"max=100"`
+	// Location defaults to the session's location, or MAIN.
+	Location string `json:"location" validate:"max=50"`
+	Lot      string `json:"lot" validate:"max=100"`
+	Counted  *int   `json:"counted" validate:"required,min=0"`
+}
+
+type submitCountRequest struct {
+	Lines []countLineRequest `json:"lines" validate:"required,min=1,max=1000,dive"`
+}
+
+// CountPage is one page of count sessions.
+type CountPage struct {
+	Items []models.CountSession `json:"items"`
+	Page  int                   `json:"page"`
+	Limit int                   `json:"limit"`
+	Total int64                 `json:"total"`
+}
+
+// findCount loads the count session named by :id.
+func findCount(c *gin.Context, ctx context.Context) (*models.CountSession, error) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return nil, apierror.BadRequest("Invalid count session ID")
+	}
+	var session models.CountSession
+	err = Counts.FindOne(ctx, bson.M{"_id": objID}).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return nil, apierror.NotFound("Count session not found")
+	}
+	if err != nil {
+		return nil, apierror.Internal(err)
+	}
+	return &session, nil
+}
+
+// countLines freezes the stock of products that are to be counted: one
+// line per location holding stock, and per lot for products that track
+// lots. Without a location, products without any stock get a line at
+// DefaultLocation.
+func countLines(ctx context.Context, products []models.Product, location string) ([]models.CountLine, error) {
+	var plainIDs, lotIDs []primitive.ObjectID
+	for _, p := range products {
+		if p.TrackLots {
+			lotIDs = append(lotIDs, p.ProductId)
+		} else {
+			plainIDs = append(plainIDs, p.ProductId)
+		}
+	}
+
+	found := make(map[primitive.ObjectID][]models.CountLine)
+	filter := bson.M{"on_hand": bson.M{"$gt": 0}}
+	if location != "" {
+		filter["location"] = location
+	}
+	if len(plainIDs) > 0 {
+		filter["product_id"] = bson.M{"$in": plainIDs}
+		cursor, err := stock.Levels.Find(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		var levels []models.StockLevel
+		if err := cursor.All(ctx, &levels); err != nil {
+			return nil, err
+		}
+		for _, level := range levels {
+			found[level.ProductId] = append(found[level.ProductId], models.CountLine{Location: level.Location, Expected: level.OnHand})
+		}
+	}
+	if len(lotIDs) > 0 {
+		filter["product_id"] = bson.M{"$in": lotIDs}
+		cursor, err := stock.LotLevels.Find(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		var levels []models.LotLevel
+		if err := cursor.All(ctx, &levels); err != nil {
+			return nil, err
+		}
+		for _, level := range levels {
+			found[level.ProductId] = append(found[level.ProductId], models.CountLine{Location: level.Location, Lot: level.LotNumber, Expected: level.OnHand})
+		}
+	}
+
+	lines := make([]models.CountLine, 0)
+	for _, p := range products {
+		productLines := found[p.ProductId]
+		if len(productLines) == 0 && location == "" && !p.TrackLots {
+			productLines = []models.CountLine{{Location: stock.DefaultLocation}}
+		}
+		sort.Slice(productLines, func(i, j int) bool {
+			if productLines[i].Location != productLines[j].Location {
+				return productLines[i].Location < productLines[j].Location
+			}
+			return productLines[i].Lot < productLines[j].Lot
+		})
+		for _, line := range productLines {
+			line.ProductId = p.ProductId
+			line.SKU = p.SKU
+			line.Name = p.Name
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// currentStock is what a location holds of a product, or of one of its lots.
+func currentStock(ctx context.Context, productID primitive.ObjectID, location string, lot string) (int, error) {
+	var level struct {
+		OnHand int `bson:"on_hand"`
+	}
+	filter := bson.M{"product_id": productID, "location": location}
+	var err error
+	if lot != "" {
+		filter["lot_number"] = lot
+		err = stock.LotLevels.FindOne(ctx, filter).Decode(&level)
+	} else {
+		err = stock.Levels.FindOne(ctx, filter).Decode(&level)
+	}
+	if err != nil && err != mongo.ErrNoDocuments {
+		return 0, err
+	}
+	return level.OnHand, nil
+}
+
+// recordCount sets what was counted on line. onHand is the stock the
+// ledger holds for the line at that moment; the variance is measured
+// against it rather than the frozen Expected, so stock that moved since
+// the session started is not adjusted twice.
+func recordCount(line *models.CountLine, counted int, onHand int, uid string, at time.Time) {
+	line.Counted = &counted
+	line.OnHand = onHand
+	line.Variance = counted - onHand
+	line.CountedBy = uid
+	line.CountedAt = &at
+}
+
+// CreateCount godoc
+// @Summary Start a cycle count
+// @Description Picks the products of a type, of an ABC class or listed by ID, and freezes their stock by location (and lot) as the expected quantities. With a location only the stock there is counted. ABC classes rank products by the cost of goods sold over the last 90 days. Products that track serial numbers are left out.
+// @Tags Counts
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param body body createCountRequest true "Session"
+// @Success 201 {object} models.CountSession
+// @Failure 400,403,422 {object} apierror.Problem
+// @Router /counts [post]
+func CreateCount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 60*time.Second)
+		defer cancel()
+
+		var req createCountRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid request payload"))
+			return
+		}
+		req.Location = strings.ToUpper(strings.TrimSpace(req.Location))
+		if err := Validate.Struct(req); err != nil {
+			apierror.Respond(c, apierror.FromValidator(err))
+			return
+		}
+
+		session := models.CountSession{
+			ID:       primitive.NewObjectID(),
+			Name:     req.Name,
+			Status:   models.CountOpen,
+			Location: req.Location,
+			Type:     req.Type,
+			ABCClass: req.ABCClass,
+			Notes:    req.Notes,
+		}
+		filter := bson.M{"track_serials": bson.M{"$ne": true}}
+		if req.Type != "" {
+			filter["type"] = req.Type
+		}
+		for i, id := range req.ProductIds {
+			objID, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				apierror.Respond(c, apierror.Validation(apierror.FieldError{Field: fmt.Sprintf("product_ids[%d]", i), Message: "is not a valid ID"}))
+				return
+			}
+			session.ProductIds = append(session.ProductIds, objID)
+		}
+		if len(session.ProductIds) > 0 {
+			filter["_id"] = bson.M{"$in": session.ProductIds}
+		}
+		cursor, err := Products.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "sku", Value: 1}, {Key: "_id", Value: 1}}))
+		var products []models.Product
+		if err == nil {
+			err = cursor.All(ctx, &products)
+		}
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		if req.ABCClass != "" {
+			classes, err := stock.ABCClasses(ctx)
+			if err != nil {
+				apierror.Respond(c, apierror.Internal(err))
+				return
+			}
+			picked := products[:0]
+			for _, p := range products {
+				class, ok := classes[p.ProductId]
+				if !ok {
+					class = models.ClassC
+				}
+				if class == req.ABCClass {
+					picked = append(picked, p)
+				}
+			}
+			products = picked
+		}
+
+		session.Lines, err = countLines(ctx, products, req.Location)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		if len(session.Lines) == 0 {
+			apierror.Respond(c, apierror.BadRequest("No stock to count matches the selection"))
+			return
+		}
+		now := time.Now().UTC()
+		session.CreatedBy = c.GetString("uid")
+		session.CreatedTime = now
+		session.UpdatedTime = now
+		if _, err := Counts.InsertOne(ctx, &session); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		logging.FromContext(ctx).Info("count session started", "countId", session.ID.Hex(), "lines", len(session.Lines))
+		c.JSON(http.StatusCreated, session)
+	}
+}
+
+// ListCounts godoc
+// @Summary List count sessions
+// @Tags Counts
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Produce json
+// @Param status query string false "open, approved or cancelled"
+// @Param page query int false "Page number, from 1"
+// @Param limit query int false "Page size, at most 100"
+// @Success 200 {object} CountPage
+// @Router /counts [get]
+func ListCounts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+
+		page, limit := pagination(c)
+		filter := bson.M{}
+		if status := c.Query("status"); status != "" {
+			filter["status"] = status
+		}
+		total, err := Counts.CountDocuments(ctx, filter)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		opts := options.Find().
+			SetSort(bson.D{{Key: "createdTime", Value: -1}, {Key: "_id", Value: -1}}).
+			SetSkip(int64((page - 1) * limit)).
+			SetLimit(int64(limit))
+		cursor, err := Counts.Find(ctx, filter, opts)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		defer cursor.Close(ctx)
+
+		sessions := make([]models.CountSession, 0)
+		if err := cursor.All(ctx, &sessions); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		c.JSON(http.StatusOK, CountPage{Items: sessions, Page: page, Limit: limit, Total: total})
+	}
+}
+
+// GetCount godoc
+// @Summary Get a count session and its variances
+// @Tags Counts
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Produce json
+// @Param id path string true "Count session ID"
+// @Param variances query bool false "Only counted lines with a variance"
+// @Success 200 {object} models.CountSession
+// @Failure 400,404 {object} apierror.Problem
+// @Router /counts/{id} [get]
+func GetCount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		session, err := findCount(c, ctx)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		if c.Query("variances") == "true" {
+			lines := make([]models.CountLine, 0)
+			for _, line := range session.Lines {
+				if line.Counted != nil && line.Variance != 0 {
+					lines = append(lines, line)
+				}
+			}
+			session.Lines = lines
+		}
+		c.JSON(http.StatusOK, session)
+	}
+}
+
+// SubmitCounts godoc
+// @Summary Enter counted quantities
+// @Description Sets the counted quantity of lines, found by product (ID or SKU), location and lot. The variance is the count less the stock on hand at that moment. Counting a line again replaces the earlier count. A session for one location only takes counts there. Stock found where none was expected adds a line, which expects what the location holds at that moment.
+// @Tags Counts
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Count session ID"
+// @Param body body submitCountRequest true "Counted quantities"
+// @Success 200 {object} models.CountSession
+// @Failure 400,404,409,422 {object} apierror.Problem
+// @Router /counts/{id}/counts [post]
+func SubmitCounts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+
+		var req submitCountRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid request payload"))
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			apierror.Respond(c, apierror.FromValidator(err))
+			return
+		}
+		found, err := findCount(c, ctx)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		var session models.CountSession
+		err = database.Transaction(ctx, func(sc mongo.SessionContext) error {
+			if err := Counts.FindOne(sc, bson.M{"_id": found.ID}).Decode(&session); err != nil {
+				return err
+			}
+			if session.Status != models.CountOpen {
+				return apierror.Conflict("count session is " + session.Status)
+			}
+			now := time.Now().UTC()
+			var problems []apierror.FieldError
+			for i, lineReq := range req.Lines {
+				field := fmt.Sprintf("lines[%d]", i)
+				location := strings.ToUpper(strings.TrimSpace(lineReq.Location))
+				if location == "" {
+					location = session.Location
+				}
+				if location == "" {
+					location = stock.DefaultLocation
+				}
+				if session.Location != "" && location != session.Location {
+					problems = append(problems, apierror.FieldError{Field: field + ".location", Message: "is outside the session's location " + session.Location})
+					continue
+				}
+				lot := strings.TrimSpace(lineReq.Lot)
+
+				var product models.Product
+				switch {
+				case lineReq.ProductId != "":
+					objID, err := primitive.ObjectIDFromHex(lineReq.ProductId)
+					if err != nil {
+						problems = append(problems, apierror.FieldError{Field: field + ".product_id", Message: "is not a valid ID"})
+						continue
+					}
+					err = Products.FindOne(sc, bson.M{"_id": objID}).Decode(&product)
+					if err == mongo.ErrNoDocuments {
+						problems = append(problems, apierror.FieldError{Field: field + ".product_id", Message: "product not found"})
+						continue
+					}
+					if err != nil {
+						return err
+					}
+				case lineReq.SKU != "":
+					err := Products.FindOne(sc, bson.M{"sku": lineReq.SKU}).Decode(&product)
+					if err == mongo.ErrNoDocuments {
+						problems = append(problems, apierror.FieldError{Field: field + ".sku", Message: "product not found"})
+						continue
+					}
+					if err != nil {
+						return err
+					}
+				default:
+					problems = append(problems, apierror.FieldError{Field: field + ".product_id", Message: "product_id or sku is required"})
+					continue
+				}
+				switch {
+				case product.TrackSerials:
+					problems = append(problems, apierror.FieldError{Field: field, Message: "products that track serial numbers are not counted in sessions"})
+					continue
+				case product.TrackLots && lot == "":
+					problems = append(problems, apierror.FieldError{Field: field + ".lot", Message: stock.ErrLotRequired.Error()})
+					continue
+				case !product.TrackLots && lot != "":
+					problems = append(problems, apierror.FieldError{Field: field + ".lot", Message: stock.ErrLotNotTracked.Error()})
+					continue
+				}
+
+				var line *models.CountLine
+				for j := range session.Lines {
+					l := &session.Lines[j]
+					if l.ProductId == product.ProductId && l.Location == location && l.Lot == lot {
+						line = l
+						break
+					}
+				}
+				onHand, err := currentStock(sc, product.ProductId, location, lot)
+				if err != nil {
+					return err
+				}
+				if line == nil {
+					session.Lines = append(session.Lines, models.CountLine{
+						ProductId: product.ProductId,
+						SKU:       product.SKU,
+						Name:      product.Name,
+						Location:  location,
+						Lot:       lot,
+						Expected:  onHand,
+					})
+					line = &session.Lines[len(session.Lines)-1]
+				}
+				recordCount(line, *lineReq.Counted, onHand, c.GetString("uid"), now)
+			}
+			if len(problems) > 0 {
+				return apierror.Validation(problems...)
+			}
+
+			session.UpdatedTime = now
+			update := bson.M{"$set": bson.M{"lines": session.Lines, "updatedTime": now}}
+			_, err := Counts.UpdateOne(sc, bson.M{"_id": session.ID}, update)
+			return err
+		})
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, session)
+	}
+}
+
+// ApproveCount godoc
+// @Summary Approve a count session
+// @Description Every line must have been counted. Each variance is posted to the stock ledger as an adjustment at the line's location and lot, and the session is closed.
+// @Tags Counts
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Count session ID"
+// @Success 200 {object} models.CountSession
+// @Failure 400,403,404,409,422 {object} apierror.Problem
+// @Router /counts/{id}/approve [post]
+func ApproveCount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 60*time.Second)
+		defer cancel()
+
+		found, err := findCount(c, ctx)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		var session models.CountSession
+		err = database.Transaction(ctx, func(sc mongo.SessionContext) error {
+			if err := Counts.FindOne(sc, bson.M{"_id": found.ID}).Decode(&session); err != nil {
+				return err
+			}
+			if session.Status != models.CountOpen {
+				return apierror.Conflict("count session is " + session.Status)
+			}
+			var problems []apierror.FieldError
+			for i, line := range session.Lines {
+				if line.Counted == nil {
+					problems = append(problems, apierror.FieldError{Field: fmt.Sprintf("lines[%d]", i), Message: "has not been counted"})
+				}
+			}
+			if len(problems) > 0 {
+				return apierror.Validation(problems...)
+			}
+
+			for _, line := range session.Lines {
+				if line.Variance == 0 {
+					continue
+				}
+				if line.Variance < 0 {
+					product, err := findProduct(sc, line.ProductId)
+					if err != nil {
+						return err
+					}
+					// On hand stock cannot drop below what is already reserved.
+					if product.OnHand+line.Variance < product.Reserved {
+						return apierror.Conflict(fmt.Sprintf("%s: %d units are reserved", line.SKU, product.Reserved))
+					}
+				}
+				err := stock.Post(sc, &models.StockMovement{
+					ProductId: line.ProductId,
+					Location:  line.Location,
+					Lot:       line.Lot,
+					Quantity:  line.Variance,
+					Type:      models.MovementAdjustment,
+					RefType:   "count_session",
+					RefId:     session.ID.Hex(),
+					Note:      "count " + session.Name,
+					CreatedBy: c.GetString("uid"),
+				})
+				if err != nil {
+					return stockError(err)
+				}
+			}
+
+			now := time.Now().UTC()
+			session.Status = models.CountApproved
+			session.ApprovedBy = c.GetString("uid")
+			session.ApprovedAt = &now
+			session.UpdatedTime = now
+			update := bson.M{"$set": bson.M{
+				"status":      session.Status,
+				"approved_by": session.ApprovedBy,
+				"approved_at": now,
+				"updatedTime": now,
+			}}
+			_, err := Counts.UpdateOne(sc, bson.M{"_id": session.ID}, update)
+			return err
+		})
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		logging.FromContext(ctx).Info("count session approved", "countId", session.ID.Hex())
+		c.JSON(http.StatusOK, session)
+	}
+}
+
+// CancelCount godoc
+// @Summary Cancel a count session
+// @Description Only open sessions can be cancelled. Nothing is posted to the stock ledger.
+// @Tags Counts
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Count session ID"
+// @Success 200 {object} models.CountSession
+// @Failure 400,403,404,409 {object} apierror.Problem
+// @Router /counts/{id}/cancel [post]
+func CancelCount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		session, err := findCount(c, ctx)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		filter := bson.M{"_id": session.ID, "status": models.CountOpen}
+		update := bson.M{"$set": bson.M{"status": models.CountCancelled, "updatedTime": time.Now().UTC()}}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		var cancelled models.CountSession
+		err = Counts.FindOneAndUpdate(ctx, filter, update, opts).Decode(&cancelled)
+		if err == mongo.ErrNoDocuments {
+			apierror.Respond(c, apierror.Conflict("count session is "+session.Status))
+			return
+		}
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		logging.FromContext(ctx).Info("count session cancelled", "countId", cancelled.ID.Hex())
+		c.JSON(http.StatusOK, cancelled)
+	}
+}
@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yashaswini7291/Inventory/models"
+)
+
+func TestRecordCount(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected int
+		onHand   int
+		counted  int
+		variance int
+	}{
+		{"nothing moved", 100, 100, 100, 0},
+		{"shrinkage", 100, 100, 97, -3},
+		{"found stock", 100, 100, 104, 4},
+		// Frozen at 100, 5 sold before the count: counting 95 is right.
+		{"sold before the count", 100, 95, 95, 0},
+		{"sold before the count and short", 100, 95, 93, -2},
+		{"received before the count", 100, 120, 120, 0},
+		{"new line", 0, 0, 6, 6},
+	}
+	at := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line := models.CountLine{Expected: tt.expected}
+			recordCount(&line, tt.counted, tt.onHand, "u1", at)
+			if line.Variance != tt.variance {
+				t.Errorf("variance = %d, want %d", line.Variance, tt.variance)
+			}
+			if line.Counted == nil || *line.Counted != tt.counted || line.OnHand != tt.onHand {
+				t.Errorf("counted = %v, on hand = %d, want %d and %d", line.Counted, line.OnHand, tt.counted, tt.onHand)
+			}
+			if line.Expected != tt.expected {
+				t.Errorf("expected = %d, want it left at %d", line.Expected, tt.expected)
+			}
+			if line.CountedBy != "u1" || line.CountedAt == nil || !line.CountedAt.Equal(at) {
+				t.Errorf("counted by %q at %v", line.CountedBy, line.CountedAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yashaswini7291/Inventory/tokens"
+)
+
+// JWKS godoc
+// @Summary Public keys for verifying access tokens
+// @Description Lists the RS256 and ES256 keys that may still verify tokens, by kid. HS256 keys are secret and never listed.
+// @Tags Auth
+// @Produce json
+// @Success 200 {object} map[string][]tokens.JWK
+// @Router /.well-known/jwks.json [get]
+func JWKS() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, gin.H{"keys": tokens.Keys.JWKS()})
+	}
+}
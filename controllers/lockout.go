@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yashaswini7291/Inventory/apierror"
+	"github.com/yashaswini7291/Inventory/lockout"
+	"github.com/yashaswini7291/Inventory/logging"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// LoginPolicy throttles failed logins and second-factor attempts.
+var LoginPolicy = lockout.DefaultPolicy
+
+// dummyPasswordHash is compared against when the username does not exist.
+var dummyPasswordHash = func() string {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not a real password"), 10)
+	return string(hash)
+}()
+
+func loginKeys(c *gin.Context, userName string) []string {
+	return []string{lockout.UserKey(userName), lockout.IPKey(c.ClientIP())}
+}
+
+// loginAllowed answers 429 with Retry-After and returns false while any of
+// keys is being throttled or is locked.
+func loginAllowed(c *gin.Context, ctx context.Context, keys []string) bool {
+	wait, err := lockout.Check(ctx, LoginPolicy, keys...)
+	if err != nil {
+		apierror.Respond(c, apierror.Internal(err))
+		return false
+	}
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		apierror.Respond(c, apierror.TooManyRequests("too many failed attempts, try again later"))
+		return false
+	}
+	return true
+}
+
+// loginFailed records a failed attempt and answers with the same response
+// whether the username exists or the password was wrong.
+func loginFailed(c *gin.Context, ctx context.Context, keys []string, userName string) {
+	locked, err := lockout.RecordFailure(ctx, LoginPolicy, keys...)
+	if err != nil {
+		apierror.Respond(c, apierror.Internal(err))
+		return
+	}
+	logger := logging.FromContext(ctx)
+	logger.Info("login failed", "username", userName, "client_ip", c.ClientIP())
+	if locked {
+		logger.Warn("login locked", "username", userName, "client_ip", c.ClientIP())
+	}
+	apierror.Respond(c, apierror.Unauthorized("invalid credentials"))
+}
+
+// UnlockUser godoc
+// @Summary Clear failed login attempts for a user
+// @Tags Users
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 204
+// @Failure 403,404 {object} apierror.Problem
+// @Router /users/{id}/unlock [post]
+func UnlockUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		user, err := findMember(c, ctx)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		if err := lockout.Reset(ctx, lockout.UserKey(*user.UserName)); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		logging.FromContext(ctx).Info("user unlocked", "userId", user.UserId)
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,241 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yashaswini7291/Inventory/apierror"
+	"github.com/yashaswini7291/Inventory/models"
+	"github.com/yashaswini7291/Inventory/stock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type trackingRequest struct {
+	TrackLots    *bool `json:"track_lots"`
+	TrackSerials *bool `json:"track_serials"`
+}
+
+// LotTrace is where a lot came from and where it went.
+type LotTrace struct {
+	Lot       models.Lot             `json:"lot"`
+	Locations []models.LotLevel      `json:"locations"`
+	Movements []models.StockMovement `json:"movements"`
+	// Orders are the sales orders that received units of the lot.
+	Orders []LotOrder `json:"orders"`
+}
+
+// LotOrder is a sales order that received units of a lot.
+type LotOrder struct {
+	OrderId  string `json:"order_id"`
+	UserId   string `json:"userId"`
+	UserName string `json:"username"`
+	Quantity int    `json:"quantity"`
+}
+
+// parseWithin reads a duration such as "30d", "12h" or "90m".
+func parseWithin(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, errors.New("invalid number of days")
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, errors.New("invalid duration")
+	}
+	return d, nil
+}
+
+// SetProductTracking godoc
+// @Summary Turn lot or serial number tracking of a product on or off
+// @Description Tracking can only change while the product has no stock. Fields left out keep their value.
+// @Tags Products
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param body body trackingRequest true "Tracking"
+// @Success 200 {object} models.Product
+// @Failure 400,404,409,422 {object} apierror.Problem
+// @Router /products/{id}/tracking [put]
+func SetProductTracking() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid product ID"))
+			return
+		}
+		var req trackingRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid request payload"))
+			return
+		}
+		set := bson.M{}
+		if req.TrackLots != nil {
+			set["track_lots"] = *req.TrackLots
+		}
+		if req.TrackSerials != nil {
+			set["track_serials"] = *req.TrackSerials
+		}
+		if len(set) == 0 {
+			apierror.Respond(c, apierror.BadRequest("track_lots or track_serials is required"))
+			return
+		}
+
+		filter := bson.M{"_id": objID, "quantity": 0}
+		update := bson.M{"$set": set}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		var product models.Product
+		err = Products.FindOneAndUpdate(ctx, filter, update, opts).Decode(&product)
+		if err == mongo.ErrNoDocuments {
+			if _, err := findProduct(ctx, objID); err != nil {
+				apierror.Respond(c, err)
+				return
+			}
+			apierror.Respond(c, apierror.Conflict("tracking can only change while the product has no stock"))
+			return
+		}
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		c.JSON(http.StatusOK, product)
+	}
+}
+
+// ListExpiringLots godoc
+// @Summary List lots that are about to expire
+// @Description Lot stock by location that expires within the given time, expired stock included. Soonest first.
+// @Tags Lots
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Produce json
+// @Param within query string false "Time window such as 30d or 12h, default 30d"
+// @Success 200 {array} models.LotLevel
+// @Failure 400 {object} apierror.Problem
+// @Router /lots/expiring [get]
+func ListExpiringLots() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+
+		within, err := parseWithin(c.DefaultQuery("within", "30d"))
+		if err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid within: "+err.Error()))
+			return
+		}
+		levels, err := stock.ExpiringLots(ctx, time.Now().UTC().Add(within))
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		c.JSON(http.StatusOK, levels)
+	}
+}
+
+// TraceLot godoc
+// @Summary Trace a lot for a recall
+// @Description Returns the lot, its remaining stock by location, every movement of it and the sales orders it was shipped on.
+// @Tags Lots
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Produce json
+// @Param product_id query string true "Product ID"
+// @Param lot query string true "Lot number"
+// @Success 200 {object} LotTrace
+// @Failure 400,404 {object} apierror.Problem
+// @Router /lots/recall [get]
+func TraceLot() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+
+		productID, err := primitive.ObjectIDFromHex(c.Query("product_id"))
+		if err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid product ID"))
+			return
+		}
+		lotNumber := strings.TrimSpace(c.Query("lot"))
+		if lotNumber == "" {
+			apierror.Respond(c, apierror.BadRequest("lot is required"))
+			return
+		}
+		lot, err := stock.FindLot(ctx, productID, lotNumber)
+		if errors.Is(err, stock.ErrUnknownLot) {
+			apierror.Respond(c, apierror.NotFound("Lot not found"))
+			return
+		}
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		trace := LotTrace{Lot: *lot, Locations: make([]models.LotLevel, 0), Movements: make([]models.StockMovement, 0), Orders: make([]LotOrder, 0)}
+
+		filter := bson.M{"product_id": productID, "lot_number": lotNumber, "on_hand": bson.M{"$gt": 0}}
+		cursor, err := stock.LotLevels.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "location", Value: 1}}))
+		if err == nil {
+			err = cursor.All(ctx, &trace.Locations)
+		}
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		opts := options.Find().SetSort(bson.D{{Key: "createdTime", Value: 1}, {Key: "_id", Value: 1}})
+		cursor, err = stock.Movements.Find(ctx, bson.M{"product_id": productID, "lot": lotNumber}, opts)
+		if err == nil {
+			err = cursor.All(ctx, &trace.Movements)
+		}
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+
+		shipped := make(map[string]int)
+		orderIDs := make([]primitive.ObjectID, 0)
+		for _, m := range trace.Movements {
+			if m.Type != models.MovementSale || m.RefType != "sales_order" {
+				continue
+			}
+			if _, seen := shipped[m.RefId]; !seen {
+				if objID, err := primitive.ObjectIDFromHex(m.RefId); err == nil {
+					orderIDs = append(orderIDs, objID)
+				}
+			}
+			shipped[m.RefId] -= m.Quantity
+		}
+		if len(orderIDs) > 0 {
+			cursor, err := Orders.Find(ctx, bson.M{"_id": bson.M{"$in": orderIDs}})
+			var orders []models.SalesOrder
+			if err == nil {
+				err = cursor.All(ctx, &orders)
+			}
+			if err != nil {
+				apierror.Respond(c, apierror.Internal(err))
+				return
+			}
+			for _, order := range orders {
+				trace.Orders = append(trace.Orders, LotOrder{
+					OrderId:  order.ID.Hex(),
+					UserId:   order.UserId,
+					UserName: order.UserName,
+					Quantity: shipped[order.ID.Hex()],
+				})
+			}
+		}
+		c.JSON(http.StatusOK, trace)
+	}
+}
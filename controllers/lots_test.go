@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWithin(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"30d", 30 * 24 * time.Hour, false},
+		{"0d", 0, false},
+		{"1d", 24 * time.Hour, false},
+		{"12h", 12 * time.Hour, false},
+		{"90m", 90 * time.Minute, false},
+		{"1h30m", 90 * time.Minute, false},
+		{"-1d", 0, true},
+		{"-5h", 0, true},
+		{"d", 0, true},
+		{"1.5d", 0, true},
+		{"30", 0, true},
+		{"", 0, true},
+		{"soon", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseWithin(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseWithin(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseWithin(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
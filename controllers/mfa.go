@@ -0,0 +1,321 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yashaswini7291/Inventory/apierror"
+	"github.com/yashaswini7291/Inventory/lockout"
+	"github.com/yashaswini7291/Inventory/models"
+	"github.com/yashaswini7291/Inventory/tokens"
+	"github.com/yashaswini7291/Inventory/totp"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const recoveryCodeCount = 10
+
+type mfaCodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type mfaLoginRequest struct {
+	MFAToken     string `json:"mfa_token" validate:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+func mfaIssuer() string {
+	if issuer := os.Getenv("MFA_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "Inventory"
+}
+
+// generateRecoveryCodes returns plaintext codes for the user and the
+// hashes that are stored in their place.
+func generateRecoveryCodes() (codes []string, hashes []string, err error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(encoding.EncodeToString(b))
+		code := raw[:5] + "-" + raw[5:10]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	normalised := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalised))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkSecondFactor accepts either a current TOTP code or an unused
+// recovery code. Both are consumed atomically: a TOTP step is recorded so
+// the same code cannot be replayed, and a recovery code is removed.
+func checkSecondFactor(ctx context.Context, user *models.User, code string, recoveryCode string) error {
+	switch {
+	case code != "":
+		step, ok := totp.Validate(user.MFASecret, code, totp.Now(), user.MFALastStep)
+		if !ok {
+			return apierror.Unauthorized("invalid authentication code")
+		}
+		filter := bson.M{"userId": user.UserId, "mfa_last_step": bson.M{"$not": bson.M{"$gte": step}}}
+		result, err := UserCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"mfa_last_step": step}})
+		if err != nil {
+			return apierror.Internal(err)
+		}
+		if result.ModifiedCount == 0 {
+			return apierror.Unauthorized("invalid authentication code")
+		}
+		return nil
+	case recoveryCode != "":
+		hash := hashRecoveryCode(recoveryCode)
+		filter := bson.M{"userId": user.UserId, "recovery_codes": hash}
+		result, err := UserCollection.UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"recovery_codes": hash}})
+		if err != nil {
+			return apierror.Internal(err)
+		}
+		if result.ModifiedCount == 0 {
+			return apierror.Unauthorized("invalid recovery code")
+		}
+		return nil
+	default:
+		return apierror.BadRequest("code or recovery_code is required")
+	}
+}
+
+// EnrollMFA godoc
+// @Summary Start two-factor enrollment
+// @Description Returns a new TOTP secret and otpauth URI. Two-factor authentication is enabled once a code is confirmed through /mfa/verify.
+// @Tags MFA
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Failure 409 {object} apierror.Problem
+// @Router /mfa/enroll [post]
+func EnrollMFA() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		user, err := findUserByID(ctx, c.GetString("uid"))
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		if user.MFAEnabled {
+			apierror.Respond(c, apierror.Conflict("two-factor authentication is already enabled"))
+			return
+		}
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		_, err = UserCollection.UpdateOne(ctx, bson.M{"userId": user.UserId}, bson.M{"$set": bson.M{"mfa_pending_secret": secret}})
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"secret":      secret,
+			"otpauth_uri": totp.URI(secret, mfaIssuer(), *user.UserName),
+		})
+	}
+}
+
+// VerifyMFA godoc
+// @Summary Confirm two-factor enrollment
+// @Description Enables two-factor authentication and returns single-use recovery codes. The codes are only shown once.
+// @Tags MFA
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param code body mfaCodeRequest true "Code from the authenticator app"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400,401,409 {object} apierror.Problem
+// @Router /mfa/verify [post]
+func VerifyMFA() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		var req mfaCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+			apierror.Respond(c, apierror.BadRequest("code is required"))
+			return
+		}
+		user, err := findUserByID(ctx, c.GetString("uid"))
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		if user.MFAEnabled {
+			apierror.Respond(c, apierror.Conflict("two-factor authentication is already enabled"))
+			return
+		}
+		if user.MFAPendingSecret == "" {
+			apierror.Respond(c, apierror.BadRequest("start enrollment with /mfa/enroll first"))
+			return
+		}
+		step, ok := totp.Validate(user.MFAPendingSecret, req.Code, totp.Now(), 0)
+		if !ok {
+			apierror.Respond(c, apierror.Unauthorized("invalid authentication code"))
+			return
+		}
+
+		codes, hashes, err := generateRecoveryCodes()
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		update := bson.M{
+			"$set": bson.M{
+				"mfa_enabled":    true,
+				"mfa_secret":     user.MFAPendingSecret,
+				"mfa_last_step":  step,
+				"recovery_codes": hashes,
+			},
+			"$unset": bson.M{"mfa_pending_secret": ""},
+		}
+		if _, err := UserCollection.UpdateOne(ctx, bson.M{"userId": user.UserId}, update); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_enabled":    true,
+			"recovery_codes": codes,
+		})
+	}
+}
+
+// DisableMFA godoc
+// @Summary Turn off two-factor authentication
+// @Tags MFA
+// @Security BearerAuth
+// @Accept json
+// @Param code body mfaCodeRequest true "Current code or a recovery code"
+// @Success 204
+// @Failure 400,401 {object} apierror.Problem
+// @Router /mfa/disable [post]
+func DisableMFA() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		var req mfaCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid request payload"))
+			return
+		}
+		user, err := findUserByID(ctx, c.GetString("uid"))
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		if !user.MFAEnabled {
+			apierror.Respond(c, apierror.BadRequest("two-factor authentication is not enabled"))
+			return
+		}
+		if err := checkSecondFactor(ctx, user, req.Code, req.RecoveryCode); err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		update := bson.M{
+			"$set":   bson.M{"mfa_enabled": false},
+			"$unset": bson.M{"mfa_secret": "", "mfa_pending_secret": "", "mfa_last_step": "", "recovery_codes": ""},
+		}
+		if _, err := UserCollection.UpdateOne(ctx, bson.M{"userId": user.UserId}, update); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// LoginMFA godoc
+// @Summary Complete a login that requires two-factor authentication
+// @Description Exchanges the mfa_token returned by /login and a TOTP or recovery code for an access token.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param body body mfaLoginRequest true "Challenge token and code"
+// @Success 200 {object} map[string]string
+// @Failure 400,401,429 {object} apierror.Problem
+// @Router /login/mfa [post]
+func LoginMFA() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		var req mfaLoginRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid request payload"))
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			apierror.Respond(c, apierror.FromValidator(err))
+			return
+		}
+		claims, msg := tokens.ValidateChallenge(req.MFAToken)
+		if msg != "" {
+			apierror.Respond(c, apierror.Unauthorized(msg))
+			return
+		}
+		user, err := findUserByID(ctx, claims.Id)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		if !user.MFAEnabled {
+			apierror.Respond(c, apierror.Unauthorized("the token is invalid"))
+			return
+		}
+		// Wrong codes count towards the same lockout as wrong passwords.
+		keys := loginKeys(c, *user.UserName)
+		if !loginAllowed(c, ctx, keys) {
+			return
+		}
+		if err := checkSecondFactor(ctx, user, req.Code, req.RecoveryCode); err != nil {
+			var apiErr *apierror.Error
+			if errors.As(err, &apiErr) && apiErr.Status == http.StatusUnauthorized {
+				if _, recordErr := lockout.RecordFailure(ctx, LoginPolicy, keys...); recordErr != nil {
+					apierror.Respond(c, apierror.Internal(recordErr))
+					return
+				}
+			}
+			apierror.Respond(c, err)
+			return
+		}
+		if err := lockout.Reset(ctx, lockout.UserKey(*user.UserName)); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+
+		token, err := issueTokens(ctx, user)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"access_token": token})
+	}
+}
@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("got %d codes and %d hashes, want %d", len(codes), len(hashes), recoveryCodeCount)
+	}
+	format := regexp.MustCompile(`^[a-z2-7]{5}-[a-z2-7]{5}$`)
+	seen := make(map[string]bool)
+	for i, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("code %q does not look like xxxxx-xxxxx", code)
+		}
+		if seen[code] {
+			t.Errorf("code %q generated twice", code)
+		}
+		seen[code] = true
+		if hashes[i] != hashRecoveryCode(code) {
+			t.Errorf("hash %d does not match code %q", i, code)
+		}
+		if hashes[i] == code {
+			t.Errorf("code %q stored in plain text", code)
+		}
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	want := hashRecoveryCode("abcde-fghij")
+	tests := []struct {
+		code  string
+		match bool
+	}{
+		{"abcde-fghij", true},
+		{"ABCDE-FGHIJ", true},
+		{"abcdefghij", true},
+		{"  abcde-fghij\n", true},
+		{"abcde-fghik", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := hashRecoveryCode(tt.code) == want; got != tt.match {
+			t.Errorf("hashRecoveryCode(%q) matches = %v, want %v", tt.code, got, tt.match)
+		}
+	}
+}
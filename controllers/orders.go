@@ -0,0 +1,350 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yashaswini7291/Inventory/apierror"
+	"github.com/yashaswini7291/Inventory/database"
+	"github.com/yashaswini7291/Inventory/logging"
+	"github.com/yashaswini7291/Inventory/models"
+	"github.com/yashaswini7291/Inventory/repository"
+	"github.com/yashaswini7291/Inventory/stock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	OrderCollection *mongo.Collection = database.OpenCollection(database.Client, "SalesOrders")
+	Orders                            = repository.Scoped(OrderCollection)
+)
+
+// OrderPage is one page of the order history.
+type OrderPage struct {
+	Items []models.SalesOrder `json:"items"`
+	Page  int                 `json:"page"`
+	Limit int                 `json:"limit"`
+	Total int64               `json:"total"`
+}
+
+// visibleTo limits plain users to documents they own, as recorded in
+// ownerField. Managers and admins see every document of the organization.
+func visibleTo(c *gin.Context, ownerField string) bson.M {
+	switch c.GetString("role") {
+	case models.RoleManager, models.RoleAdmin:
+		return bson.M{}
+	default:
+		return bson.M{ownerField: c.GetString("uid")}
+	}
+}
+
+func newSalesOrder(c *gin.Context, items []models.ProductUser) models.SalesOrder {
+	now := time.Now().UTC()
+	order := models.SalesOrder{
+		ID:          primitive.NewObjectID(),
+		UserId:      c.GetString("uid"),
+		UserName:    c.GetString("userName"),
+		Lines:       make([]models.SalesOrderLine, 0, len(items)),
+		Status:      models.OrderStatusPending,
+		CreatedTime: now,
+		UpdatedTime: now,
+	}
+	for _, item := range items {
+		line := models.SalesOrderLine{
+			ProductId: item.ProductId,
+			SKU:       item.SKU,
+			Name:      item.Name,
+			Quantity:  item.Quantity,
+			UnitPrice: item.Price,
+			LineTotal: roundMoney(float64(item.Quantity) * item.Price),
+		}
+		order.Lines = append(order.Lines, line)
+		order.TotalQuantity += line.Quantity
+		order.Total += line.LineTotal
+	}
+	order.Total = roundMoney(order.Total)
+	return order
+}
+
+// Checkout godoc
+// @Summary Turn the cart into a pending sales order
+// @Description Reserves the stock of every line in one transaction and empties the cart. If any line is short nothing is reserved, no order is created and the cart is kept. The order must be confirmed with /orders/{id}/confirm before expires_at, otherwise the stock is released and the order expires.
+// @Tags Orders
+// @Security BearerAuth
+// @Produce json
+// @Success 201 {object} models.SalesOrder
+// @Failure 400,409 {object} apierror.Problem
+// @Router /cart/checkout [post]
+func Checkout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+
+		uid := c.GetString("uid")
+		var order models.SalesOrder
+		err := database.Transaction(ctx, func(sc mongo.SessionContext) error {
+			// The cart is read in the transaction that empties it, so a
+			// line added meanwhile conflicts with emptying the cart and
+			// the transaction is run again instead of the line being lost.
+			items, err := loadCart(sc, uid)
+			if err != nil {
+				return err
+			}
+			if len(items) == 0 {
+				return apierror.BadRequest("cart is empty")
+			}
+			order = newSalesOrder(c, items)
+			expiresAt := order.CreatedTime.Add(stock.DefaultReservationTTL)
+			order.ExpiresAt = &expiresAt
+
+			// Every line is attempted so the client learns about all
+			// shortages at once; the transaction is aborted afterwards.
+			var short []apierror.FieldError
+			for i := range order.Lines {
+				line := &order.Lines[i]
+				reservation := models.Reservation{
+					ID:        primitive.NewObjectID(),
+					ProductId: line.ProductId,
+					Quantity:  line.Quantity,
+					Holder:    "order:" + order.ID.Hex(),
+					OrderId:   &order.ID,
+					ExpiresAt: expiresAt,
+					CreatedBy: uid,
+				}
+				err := stock.Reserve(sc, &reservation)
+				if errors.Is(err, stock.ErrInsufficientStock) {
+					short = append(short, apierror.FieldError{
+						Field:   fmt.Sprintf("items[%d].quantity", i),
+						Message: fmt.Sprintf("not enough %s in stock", line.Name),
+					})
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				line.ReservationId = reservation.ID
+			}
+			if len(short) > 0 {
+				conflict := apierror.Conflict("some items are out of stock")
+				conflict.Fields = short
+				return conflict
+			}
+			if _, err := Orders.InsertOne(sc, &order); err != nil {
+				return err
+			}
+			return saveCart(sc, uid, nil)
+		})
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		logging.FromContext(ctx).Info("order created", "orderId", order.ID.Hex(), "lines", len(order.Lines), "total", order.Total)
+		c.JSON(http.StatusCreated, order)
+	}
+}
+
+// findOrder loads the order named by :id if the caller may see it.
+func findOrder(c *gin.Context, ctx context.Context) (*models.SalesOrder, error) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return nil, apierror.BadRequest("Invalid order ID")
+	}
+	filter := visibleTo(c, "userId")
+	filter["_id"] = objID
+	var order models.SalesOrder
+	err = Orders.FindOne(ctx, filter).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		return nil, apierror.NotFound("Order not found")
+	}
+	if err != nil {
+		return nil, apierror.Internal(err)
+	}
+	return &order, nil
+}
+
+type confirmLineRequest struct {
+	Line    int      `json:"line" validate:"required,min=1"`
+	Serials []string `json:"serials" validate:"required,min=1"`
+}
+
+type confirmOrderRequest struct {
+	// Lines lists the shipped serial numbers of lines whose product tracks
+	// them. Lines are numbered from 1.
+	Lines []confirmLineRequest `json:"lines" validate:"max=500,dive"`
+}
+
+// finishOrder settles every reservation of a pending order with settle and
+// moves the order to status, all in one transaction. settle is given the
+// 1-based line number along with the reservation.
+func finishOrder(c *gin.Context, status string, settle func(ctx context.Context, line int, reservationID primitive.ObjectID) (*models.Reservation, error)) {
+	var ctx, cancel = context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+
+	order, err := findOrder(c, ctx)
+	if err != nil {
+		apierror.Respond(c, err)
+		return
+	}
+	if order.Status != models.OrderStatusPending {
+		apierror.Respond(c, apierror.Conflict("order is "+order.Status))
+		return
+	}
+	err = database.Transaction(ctx, func(sc mongo.SessionContext) error {
+		for i, line := range order.Lines {
+			_, err := settle(sc, i+1, line.ReservationId)
+			if errors.Is(err, stock.ErrReservationClosed) {
+				return apierror.Conflict("the stock held for this order has been released")
+			}
+			if err != nil {
+				return stockError(err)
+			}
+		}
+		now := time.Now().UTC()
+		update := bson.M{
+			"$set":   bson.M{"status": status, "updatedTime": now},
+			"$unset": bson.M{"expires_at": ""},
+		}
+		result, err := Orders.UpdateOne(sc, bson.M{"_id": order.ID, "status": models.OrderStatusPending}, update)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return apierror.Conflict("order is no longer pending")
+		}
+		order.Status = status
+		order.UpdatedTime = now
+		order.ExpiresAt = nil
+		return nil
+	})
+	if err != nil {
+		apierror.Respond(c, err)
+		return
+	}
+	logging.FromContext(ctx).Info("order "+status, "orderId", order.ID.Hex())
+	c.JSON(http.StatusOK, order)
+}
+
+// ConfirmOrder godoc
+// @Summary Confirm a pending order after payment
+// @Description Deducts the reserved stock and moves the order to placed. Lines of products that track serial numbers must list the serials shipped.
+// @Tags Orders
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Order ID"
+// @Param body body confirmOrderRequest false "Serial numbers shipped"
+// @Success 200 {object} models.SalesOrder
+// @Failure 400,404,409,422 {object} apierror.Problem
+// @Router /orders/{id}/confirm [post]
+func ConfirmOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req confirmOrderRequest
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				apierror.Respond(c, apierror.BadRequest("Invalid request payload"))
+				return
+			}
+			if err := Validate.Struct(req); err != nil {
+				apierror.Respond(c, apierror.FromValidator(err))
+				return
+			}
+		}
+		serials := make(map[int][]string, len(req.Lines))
+		for _, line := range req.Lines {
+			serials[line.Line] = line.Serials
+		}
+		finishOrder(c, models.OrderStatusPlaced, func(ctx context.Context, line int, id primitive.ObjectID) (*models.Reservation, error) {
+			return stock.CommitSerials(ctx, id, serials[line])
+		})
+	}
+}
+
+// CancelOrder godoc
+// @Summary Cancel a pending order
+// @Description Releases the reserved stock.
+// @Tags Orders
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Order ID"
+// @Success 200 {object} models.SalesOrder
+// @Failure 400,404,409 {object} apierror.Problem
+// @Router /orders/{id}/cancel [post]
+func CancelOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		finishOrder(c, models.OrderStatusCancelled, func(ctx context.Context, _ int, id primitive.ObjectID) (*models.Reservation, error) {
+			return stock.Release(ctx, id)
+		})
+	}
+}
+
+// ListOrders godoc
+// @Summary List sales orders
+// @Description Users see their own orders; managers and admins see every order of the organization. Newest first.
+// @Tags Orders
+// @Security BearerAuth
+// @Produce json
+// @Param page query int false "Page number, from 1"
+// @Param limit query int false "Page size, at most 100"
+// @Success 200 {object} OrderPage
+// @Router /orders [get]
+func ListOrders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+
+		page, limit := pagination(c)
+		filter := visibleTo(c, "userId")
+		total, err := Orders.CountDocuments(ctx, filter)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		opts := options.Find().
+			SetSort(bson.D{{Key: "createdTime", Value: -1}, {Key: "_id", Value: -1}}).
+			SetSkip(int64((page - 1) * limit)).
+			SetLimit(int64(limit))
+		cursor, err := Orders.Find(ctx, filter, opts)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		defer cursor.Close(ctx)
+
+		orders := make([]models.SalesOrder, 0)
+		if err := cursor.All(ctx, &orders); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		c.JSON(http.StatusOK, OrderPage{Items: orders, Page: page, Limit: limit, Total: total})
+	}
+}
+
+// GetOrder godoc
+// @Summary Get a sales order
+// @Tags Orders
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Order ID"
+// @Success 200 {object} models.SalesOrder
+// @Failure 400,404 {object} apierror.Problem
+// @Router /orders/{id} [get]
+func GetOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		order, err := findOrder(c, ctx)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, order)
+	}
+}
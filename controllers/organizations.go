@@ -0,0 +1,341 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yashaswini7291/Inventory/apierror"
+	"github.com/yashaswini7291/Inventory/database"
+	"github.com/yashaswini7291/Inventory/logging"
+	"github.com/yashaswini7291/Inventory/mailer"
+	"github.com/yashaswini7291/Inventory/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// InvitationTTL is how long an invitation can be accepted.
+const InvitationTTL = 7 * 24 * time.Hour
+
+var (
+	OrganizationCollection *mongo.Collection = database.OpenCollection(database.Client, "Organizations")
+	InvitationCollection   *mongo.Collection = database.OpenCollection(database.Client, "Invitations")
+)
+
+type createOrganizationRequest struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+type inviteRequest struct {
+	Email string `json:"email" validate:"required,email"`
+	Role  string `json:"role" validate:"omitempty,oneof=user manager admin"`
+}
+
+type organizationCostingRequest struct {
+	CostMethod string `json:"cost_method" validate:"required,oneof=fifo average standard"`
+}
+
+type acceptInvitationRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// orgMembers returns a filter for the users of orgID. Users that joined no
+// organization are grouped together, so an administrator outside any
+// organization only manages them.
+func orgMembers(orgID string) bson.M {
+	if orgID == "" {
+		return bson.M{"org_id": bson.M{"$in": bson.A{nil, ""}}}
+	}
+	return bson.M{"org_id": orgID}
+}
+
+func invitationMessage(to string, orgName string, token string) mailer.Message {
+	body := "Use this token to join " + orgName + ": " + token
+	if base := os.Getenv("INVITATION_URL"); base != "" {
+		body = "Open this link to join " + orgName + ": " + base + "?token=" + url.QueryEscape(token)
+	}
+	body += fmt.Sprintf("\n\nThe invitation expires in %d days and can only be used once.\n", int(InvitationTTL.Hours()/24))
+	return mailer.Message{To: to, Subject: "You have been invited to " + orgName, Body: body}
+}
+
+// CreateOrganization godoc
+// @Summary Create an organization
+// @Description An administrator who is not yet a member of an organization joins the new one; the response then carries a new access_token.
+// @Tags Organizations
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param body body createOrganizationRequest true "Organization name"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400,403,422 {object} apierror.Problem
+// @Router /orgs [post]
+func CreateOrganization() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		var req createOrganizationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid request payload"))
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			apierror.Respond(c, apierror.FromValidator(err))
+			return
+		}
+
+		org := models.Organization{
+			ID:          primitive.NewObjectID(),
+			Name:        strings.TrimSpace(req.Name),
+			CreatedBy:   c.GetString("uid"),
+			CreatedTime: time.Now().UTC(),
+		}
+		org.OrgId = org.ID.Hex()
+		if _, err := OrganizationCollection.InsertOne(ctx, org); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		logging.FromContext(ctx).Info("organization created", "orgId", org.OrgId)
+
+		response := gin.H{"organization": org}
+		if c.GetString("orgId") == "" {
+			user, err := setUserFields(ctx, c.GetString("uid"), bson.M{"org_id": org.OrgId})
+			if err != nil {
+				apierror.Respond(c, err)
+				return
+			}
+			token, err := issueTokens(ctx, user)
+			if err != nil {
+				apierror.Respond(c, err)
+				return
+			}
+			response["access_token"] = token
+		}
+		c.JSON(http.StatusCreated, response)
+	}
+}
+
+// GetOrganization godoc
+// @Summary Get the current user's organization
+// @Tags Organizations
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Produce json
+// @Success 200 {object} models.Organization
+// @Failure 403,404 {object} apierror.Problem
+// @Router /orgs/current [get]
+func GetOrganization() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		var org models.Organization
+		err := OrganizationCollection.FindOne(ctx, bson.M{"org_id": c.GetString("orgId")}).Decode(&org)
+		if err == mongo.ErrNoDocuments {
+			apierror.Respond(c, apierror.NotFound("Organization not found"))
+			return
+		}
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		c.JSON(http.StatusOK, org)
+	}
+}
+
+// SetOrganizationCosting godoc
+// @Summary Set the cost method of the current organization
+// @Description fifo, average or standard. Products that set their own cost method keep it. Changes apply to stock moved afterwards.
+// @Tags Organizations
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param body body organizationCostingRequest true "Cost method"
+// @Success 200 {object} models.Organization
+// @Failure 400,403,404,422 {object} apierror.Problem
+// @Router /orgs/current/costing [put]
+func SetOrganizationCosting() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		var req organizationCostingRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid request payload"))
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			apierror.Respond(c, apierror.FromValidator(err))
+			return
+		}
+
+		var org models.Organization
+		filter := bson.M{"org_id": c.GetString("orgId")}
+		update := bson.M{"$set": bson.M{"cost_method": req.CostMethod}}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err := OrganizationCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&org)
+		if err == mongo.ErrNoDocuments {
+			apierror.Respond(c, apierror.NotFound("Organization not found"))
+			return
+		}
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		logging.FromContext(ctx).Info("cost method changed", "orgId", org.OrgId, "costMethod", org.CostMethod)
+		c.JSON(http.StatusOK, org)
+	}
+}
+
+// InviteUser godoc
+// @Summary Invite someone to the current organization
+// @Description Emails a single-use token. The invitee accepts it with /invitations/accept while logged in with an account that has the same email address.
+// @Tags Organizations
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param body body inviteRequest true "Email address and role (default user)"
+// @Success 201 {object} models.Invitation
+// @Failure 400,403,422 {object} apierror.Problem
+// @Router /orgs/current/invitations [post]
+func InviteUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		var req inviteRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid request payload"))
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			apierror.Respond(c, apierror.FromValidator(err))
+			return
+		}
+		if req.Role == "" {
+			req.Role = models.RoleUser
+		}
+
+		var org models.Organization
+		if err := OrganizationCollection.FindOne(ctx, bson.M{"org_id": c.GetString("orgId")}).Decode(&org); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		token := hex.EncodeToString(b)
+		now := time.Now().UTC()
+		invitation := models.Invitation{
+			ID:          primitive.NewObjectID(),
+			OrgId:       org.OrgId,
+			Email:       strings.ToLower(req.Email),
+			Role:        req.Role,
+			TokenHash:   hashToken(token),
+			InvitedBy:   c.GetString("uid"),
+			ExpiresAt:   now.Add(InvitationTTL),
+			CreatedTime: now,
+		}
+		if _, err := InvitationCollection.InsertOne(ctx, invitation); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+
+		logger := logging.FromContext(ctx).With("invitationId", invitation.ID.Hex())
+		msg := invitationMessage(invitation.Email, org.Name, token)
+		go func() {
+			sendCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+			defer cancel()
+			if err := Mailer.Send(sendCtx, msg); err != nil {
+				logger.Error("sending invitation email failed", "error", err)
+			}
+		}()
+		logger.Info("user invited", "orgId", org.OrgId, "role", invitation.Role)
+		c.JSON(http.StatusCreated, invitation)
+	}
+}
+
+// AcceptInvitation godoc
+// @Summary Join an organization
+// @Description Consumes the invitation and returns a new access_token for the organization. Tokens issued earlier stop working.
+// @Tags Organizations
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param body body acceptInvitationRequest true "Invitation token"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400,403,409 {object} apierror.Problem
+// @Router /invitations/accept [post]
+func AcceptInvitation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		var req acceptInvitationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid request payload"))
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			apierror.Respond(c, apierror.FromValidator(err))
+			return
+		}
+		user, err := findUserByID(ctx, c.GetString("uid"))
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		if user.OrgId != "" {
+			apierror.Respond(c, apierror.Conflict("you are already a member of an organization"))
+			return
+		}
+		if user.Email == nil || *user.Email == "" {
+			apierror.Respond(c, apierror.Forbidden("add an email address to your account to accept invitations"))
+			return
+		}
+
+		now := time.Now().UTC()
+		filter := bson.M{
+			"token_hash":  hashToken(req.Token),
+			"email":       strings.ToLower(*user.Email),
+			"accepted_at": bson.M{"$exists": false},
+			"expires_at":  bson.M{"$gt": now},
+		}
+		update := bson.M{"$set": bson.M{"accepted_at": now, "accepted_by": user.UserId}}
+		var invitation models.Invitation
+		err = InvitationCollection.FindOneAndUpdate(ctx, filter, update).Decode(&invitation)
+		if err == mongo.ErrNoDocuments {
+			apierror.Respond(c, apierror.BadRequest("invitation is invalid or has expired"))
+			return
+		}
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+
+		user, err = setUserFields(ctx, user.UserId, bson.M{"org_id": invitation.OrgId, "role": invitation.Role})
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		token, err := issueTokens(ctx, user)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		logging.FromContext(ctx).Info("invitation accepted", "orgId", invitation.OrgId, "userId", user.UserId)
+		c.JSON(http.StatusOK, gin.H{"user": user.Response(), "access_token": token})
+	}
+}
@@ -0,0 +1,318 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yashaswini7291/Inventory/apierror"
+	"github.com/yashaswini7291/Inventory/database"
+	"github.com/yashaswini7291/Inventory/logging"
+	"github.com/yashaswini7291/Inventory/mailer"
+	"github.com/yashaswini7291/Inventory/models"
+	"github.com/yashaswini7291/Inventory/passwordpolicy"
+	"github.com/yashaswini7291/Inventory/tokens"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// PasswordResetTTL is how long a reset link stays valid.
+const PasswordResetTTL = 30 * time.Minute
+
+var (
+	PasswordResetCollection *mongo.Collection = database.OpenCollection(database.Client, "PasswordResets")
+	// Mailer delivers password reset emails. main replaces it with the
+	// mailer configured through the environment.
+	Mailer mailer.Mailer = mailer.LogMailer{}
+	// PasswordPolicy is applied to every new password. main replaces it
+	// with the policy configured through the environment.
+	PasswordPolicy = passwordpolicy.Default()
+)
+
+type forgotPasswordRequest struct {
+	UserName string `json:"username"`
+	Email    string `json:"email"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+type changePasswordRequest struct {
+	OldPassword string `json:"old_password" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required"`
+}
+
+// checkPassword returns a validation error with one entry per policy rule
+// the password breaks, reported against field.
+func checkPassword(field string, password string, username string) error {
+	problems := PasswordPolicy.Check(password, username)
+	if len(problems) == 0 {
+		return nil
+	}
+	fields := make([]apierror.FieldError, 0, len(problems))
+	for _, problem := range problems {
+		fields = append(fields, apierror.FieldError{Field: field, Message: problem})
+	}
+	return apierror.Validation(fields...)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func resetMessage(to string, token string) mailer.Message {
+	body := "Use this token to choose a new password: " + token
+	if base := os.Getenv("PASSWORD_RESET_URL"); base != "" {
+		body = "Open this link to choose a new password: " + base + "?token=" + url.QueryEscape(token)
+	}
+	body += fmt.Sprintf("\n\nThe link expires in %d minutes and can only be used once. If you did not ask for a password reset you can ignore this email.\n", int(PasswordResetTTL.Minutes()))
+	return mailer.Message{To: to, Subject: "Reset your password", Body: body}
+}
+
+// ForgotPassword godoc
+// @Summary Request a password reset email
+// @Description Always answers 202 so the response does not reveal whether an account exists.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param body body forgotPasswordRequest true "Username or email"
+// @Success 202 {object} map[string]string
+// @Failure 400 {object} apierror.Problem
+// @Router /password/forgot [post]
+func ForgotPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+
+		var req forgotPasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil || (req.UserName == "" && req.Email == "") {
+			apierror.Respond(c, apierror.BadRequest("username or email is required"))
+			return
+		}
+		accepted := gin.H{"message": "if the account exists a reset email has been sent"}
+
+		filter := bson.M{"username": req.UserName}
+		if req.UserName == "" {
+			filter = bson.M{"email": req.Email}
+		}
+		var user models.User
+		err := UserCollection.FindOne(ctx, filter).Decode(&user)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusAccepted, accepted)
+			return
+		}
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		logger := logging.FromContext(ctx).With("userId", user.UserId)
+		if user.Email == nil || *user.Email == "" {
+			logger.Warn("password reset requested for user without email")
+			c.JSON(http.StatusAccepted, accepted)
+			return
+		}
+
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		token := hex.EncodeToString(b)
+		now := time.Now().UTC()
+
+		// Only the most recent link works.
+		_, err = PasswordResetCollection.UpdateMany(ctx,
+			bson.M{"userId": user.UserId, "used_at": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"used_at": now}})
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		reset := models.PasswordReset{
+			ID:          primitive.NewObjectID(),
+			UserId:      user.UserId,
+			TokenHash:   hashToken(token),
+			ExpiresAt:   now.Add(PasswordResetTTL),
+			CreatedTime: now,
+		}
+		if _, err := PasswordResetCollection.InsertOne(ctx, reset); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+
+		// Send in the background so the response time does not reveal
+		// whether the account exists.
+		msg := resetMessage(*user.Email, token)
+		go func() {
+			sendCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+			defer cancel()
+			if err := Mailer.Send(sendCtx, msg); err != nil {
+				logger.Error("sending password reset email failed", "error", err)
+			}
+		}()
+		c.JSON(http.StatusAccepted, accepted)
+	}
+}
+
+// ResetPassword godoc
+// @Summary Set a new password with a reset token
+// @Description Consumes the token and signs the user out of every existing session.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param body body resetPasswordRequest true "Reset token and new password"
+// @Success 200 {object} map[string]string
+// @Failure 400,422 {object} apierror.Problem
+// @Router /password/reset [post]
+func ResetPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+
+		var req resetPasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid request payload"))
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			apierror.Respond(c, apierror.FromValidator(err))
+			return
+		}
+
+		now := time.Now().UTC()
+		filter := bson.M{
+			"token_hash": hashToken(req.Token),
+			"used_at":    bson.M{"$exists": false},
+			"expires_at": bson.M{"$gt": now},
+		}
+		var reset models.PasswordReset
+		err := PasswordResetCollection.FindOne(ctx, filter).Decode(&reset)
+		if err == mongo.ErrNoDocuments {
+			apierror.Respond(c, apierror.BadRequest("reset token is invalid or has expired"))
+			return
+		}
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		user, err := findUserByID(ctx, reset.UserId)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		// Check the policy before consuming the token so the user can try
+		// again with a better password.
+		if err := checkPassword("password", req.Password, *user.UserName); err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		filter["_id"] = reset.ID
+		result, err := PasswordResetCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"used_at": now}})
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		if result.ModifiedCount == 0 {
+			apierror.Respond(c, apierror.BadRequest("reset token is invalid or has expired"))
+			return
+		}
+
+		password, err := HashPassword(req.Password)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		_, err = UserCollection.UpdateOne(ctx, bson.M{"userId": reset.UserId},
+			bson.M{"$set": bson.M{"password": password, "updatedTime": now}})
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		if err := tokens.RevokeUserTokens(ctx, reset.UserId); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "password has been reset"})
+	}
+}
+
+// ChangePassword godoc
+// @Summary Change the current user's password
+// @Description Requires the current password. Other sessions are signed out and a new access token is returned.
+// @Tags Me
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param body body changePasswordRequest true "Current and new password"
+// @Success 200 {object} map[string]string
+// @Failure 400,401,422 {object} apierror.Problem
+// @Router /me/password [put]
+func ChangePassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+
+		var req changePasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid request payload"))
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			apierror.Respond(c, apierror.FromValidator(err))
+			return
+		}
+
+		user, err := findUserByID(ctx, c.GetString("uid"))
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		if valid, _ := VerifyPassword(req.OldPassword, *user.Password); !valid {
+			apierror.Respond(c, apierror.Validation(apierror.FieldError{Field: "old_password", Message: "is incorrect"}))
+			return
+		}
+		if err := checkPassword("new_password", req.NewPassword, *user.UserName); err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		if req.NewPassword == req.OldPassword {
+			apierror.Respond(c, apierror.Validation(apierror.FieldError{Field: "new_password", Message: "must be different from the current password"}))
+			return
+		}
+
+		password, err := HashPassword(req.NewPassword)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		_, err = UserCollection.UpdateOne(ctx, bson.M{"userId": user.UserId},
+			bson.M{"$set": bson.M{"password": password, "updatedTime": time.Now().UTC()}})
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		if err := tokens.RevokeUserTokens(ctx, user.UserId); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		token, err := issueTokens(ctx, user)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"access_token": token})
+	}
+}
@@ -0,0 +1,375 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yashaswini7291/Inventory/apierror"
+	"github.com/yashaswini7291/Inventory/database"
+	"github.com/yashaswini7291/Inventory/logging"
+	"github.com/yashaswini7291/Inventory/models"
+	"github.com/yashaswini7291/Inventory/repository"
+	"github.com/yashaswini7291/Inventory/stock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	PurchaseOrderCollection *mongo.Collection = database.OpenCollection(database.Client, "PurchaseOrders")
+	PurchaseOrders                            = repository.Scoped(PurchaseOrderCollection)
+)
+
+type purchaseOrderLineRequest struct {
+	ProductId   string  `json:"product_id" validate:"required"`
+	SupplierSKU string  `json:"supplier_sku" validate:"max=100"`
+	Quantity    int     `json:"quantity" validate:"required,min=1"`
+	UnitCost    float64 `json:"unit_cost" validate:"min=0"`
+}
+
+type purchaseOrderRequest struct {
+	SupplierId   string                     `json:"supplier_id" validate:"required"`
+	Lines        []purchaseOrderLineRequest `json:"lines" validate:"required,min=1,max=500,dive"`
+	ExpectedDate *time.Time                 `json:"expected_date"`
+	Notes        string                     `json:"notes" validate:"max=2000"`
+}
+
+// PurchaseOrderPage is one page of purchase orders.
+type PurchaseOrderPage struct {
+	Items []models.PurchaseOrder `json:"items"`
+	Page  int                    `json:"page"`
+	Limit int                    `json:"limit"`
+	Total int64                  `json:"total"`
+}
+
+// bindPurchaseOrder reads a purchase order request and resolves its
+// supplier and products. The result has no ID, status or timestamps.
+func bindPurchaseOrder(c *gin.Context, ctx context.Context) (*models.PurchaseOrder, error) {
+	var req purchaseOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		return nil, apierror.BadRequest("Invalid request payload")
+	}
+	if err := Validate.Struct(req); err != nil {
+		return nil, apierror.FromValidator(err)
+	}
+	supplierObjID, err := primitive.ObjectIDFromHex(req.SupplierId)
+	if err != nil {
+		return nil, apierror.Validation(apierror.FieldError{Field: "supplier_id", Message: "is not a valid ID"})
+	}
+	supplier, err := findSupplier(ctx, supplierObjID)
+	if isNotFound(err) {
+		return nil, apierror.Validation(apierror.FieldError{Field: "supplier_id", Message: "supplier not found"})
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Receiving posts the order's costs to the ledger, which only takes
+	// the currency the stock is valued in.
+	currency, err := stock.OrganizationCurrency(ctx)
+	if err != nil {
+		return nil, apierror.Internal(err)
+	}
+	if supplier.Currency != currency {
+		return nil, apierror.Validation(apierror.FieldError{Field: "supplier_id", Message: fmt.Sprintf("supplier's currency %s differs from the stock's currency %s", supplier.Currency, currency)})
+	}
+
+	order := &models.PurchaseOrder{
+		SupplierId:   supplier.ID,
+		SupplierName: supplier.Name,
+		Currency:     supplier.Currency,
+		Lines:        make([]models.PurchaseOrderLine, 0, len(req.Lines)),
+		ExpectedDate: req.ExpectedDate,
+		Notes:        req.Notes,
+	}
+	var problems []apierror.FieldError
+	for i, lineReq := range req.Lines {
+		field := fmt.Sprintf("lines[%d].product_id", i)
+		productObjID, err := primitive.ObjectIDFromHex(lineReq.ProductId)
+		if err != nil {
+			problems = append(problems, apierror.FieldError{Field: field, Message: "is not a valid ID"})
+			continue
+		}
+		product, err := findProduct(ctx, productObjID)
+		if isNotFound(err) {
+			problems = append(problems, apierror.FieldError{Field: field, Message: "product not found"})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		line := models.PurchaseOrderLine{
+			Line:        i + 1,
+			ProductId:   product.ProductId,
+			SKU:         product.SKU,
+			Name:        product.Name,
+			SupplierSKU: lineReq.SupplierSKU,
+			Quantity:    lineReq.Quantity,
+			UnitCost:    lineReq.UnitCost,
+			LineTotal:   roundMoney(float64(lineReq.Quantity) * lineReq.UnitCost),
+		}
+		order.Lines = append(order.Lines, line)
+		order.Total += line.LineTotal
+	}
+	if len(problems) > 0 {
+		return nil, apierror.Validation(problems...)
+	}
+	order.Total = roundMoney(order.Total)
+	return order, nil
+}
+
+// isNotFound reports whether err is a 404 API error.
+func isNotFound(err error) bool {
+	var apiErr *apierror.Error
+	return errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound
+}
+
+func findPurchaseOrder(c *gin.Context, ctx context.Context) (*models.PurchaseOrder, error) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return nil, apierror.BadRequest("Invalid purchase order ID")
+	}
+	var order models.PurchaseOrder
+	err = PurchaseOrders.FindOne(ctx, bson.M{"_id": objID}).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		return nil, apierror.NotFound("Purchase order not found")
+	}
+	if err != nil {
+		return nil, apierror.Internal(err)
+	}
+	return &order, nil
+}
+
+// updatePurchaseOrder applies update to the order named by :id if it is in
+// one of the from statuses and returns the updated order.
+func updatePurchaseOrder(c *gin.Context, ctx context.Context, from []string, update bson.M) (*models.PurchaseOrder, error) {
+	order, err := findPurchaseOrder(c, ctx)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"_id": order.ID, "status": bson.M{"$in": from}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var updated models.PurchaseOrder
+	err = PurchaseOrders.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		return nil, apierror.Conflict("purchase order is " + order.Status)
+	}
+	if err != nil {
+		return nil, apierror.Internal(err)
+	}
+	return &updated, nil
+}
+
+// CreatePurchaseOrder godoc
+// @Summary Create a draft purchase order
+// @Description The order takes the supplier's currency, which must be the currency the stock is valued in. Product names and SKUs are copied onto the lines.
+// @Tags Purchase Orders
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param body body purchaseOrderRequest true "Supplier and lines"
+// @Success 201 {object} models.PurchaseOrder
+// @Failure 400,403,422 {object} apierror.Problem
+// @Router /purchase-orders [post]
+func CreatePurchaseOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+
+		order, err := bindPurchaseOrder(c, ctx)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		now := time.Now().UTC()
+		order.ID = primitive.NewObjectID()
+		order.Status = models.POStatusDraft
+		order.CreatedBy = c.GetString("uid")
+		order.CreatedTime = now
+		order.UpdatedTime = now
+		if _, err := PurchaseOrders.InsertOne(ctx, order); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		logging.FromContext(ctx).Info("purchase order created", "purchaseOrderId", order.ID.Hex(), "supplierId", order.SupplierId.Hex())
+		c.JSON(http.StatusCreated, order)
+	}
+}
+
+// ListPurchaseOrders godoc
+// @Summary List purchase orders
+// @Description Newest first.
+// @Tags Purchase Orders
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Produce json
+// @Param status query string false "draft, submitted, partially_received, received or cancelled"
+// @Param supplier_id query string false "Only orders to this supplier"
+// @Param page query int false "Page number, from 1"
+// @Param limit query int false "Page size, at most 100"
+// @Success 200 {object} PurchaseOrderPage
+// @Failure 400 {object} apierror.Problem
+// @Router /purchase-orders [get]
+func ListPurchaseOrders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+
+		page, limit := pagination(c)
+		filter := bson.M{}
+		if status := c.Query("status"); status != "" {
+			filter["status"] = status
+		}
+		if supplier := c.Query("supplier_id"); supplier != "" {
+			objID, err := primitive.ObjectIDFromHex(supplier)
+			if err != nil {
+				apierror.Respond(c, apierror.BadRequest("Invalid supplier ID"))
+				return
+			}
+			filter["supplier_id"] = objID
+		}
+		total, err := PurchaseOrders.CountDocuments(ctx, filter)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		opts := options.Find().
+			SetSort(bson.D{{Key: "createdTime", Value: -1}, {Key: "_id", Value: -1}}).
+			SetSkip(int64((page - 1) * limit)).
+			SetLimit(int64(limit))
+		cursor, err := PurchaseOrders.Find(ctx, filter, opts)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		defer cursor.Close(ctx)
+
+		orders := make([]models.PurchaseOrder, 0)
+		if err := cursor.All(ctx, &orders); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		c.JSON(http.StatusOK, PurchaseOrderPage{Items: orders, Page: page, Limit: limit, Total: total})
+	}
+}
+
+// GetPurchaseOrder godoc
+// @Summary Get a purchase order
+// @Tags Purchase Orders
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Produce json
+// @Param id path string true "Purchase order ID"
+// @Success 200 {object} models.PurchaseOrder
+// @Failure 400,404 {object} apierror.Problem
+// @Router /purchase-orders/{id} [get]
+func GetPurchaseOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		order, err := findPurchaseOrder(c, ctx)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, order)
+	}
+}
+
+// UpdatePurchaseOrder godoc
+// @Summary Replace the supplier and lines of a draft purchase order
+// @Tags Purchase Orders
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Purchase order ID"
+// @Param body body purchaseOrderRequest true "Supplier and lines"
+// @Success 200 {object} models.PurchaseOrder
+// @Failure 400,403,404,409,422 {object} apierror.Problem
+// @Router /purchase-orders/{id} [put]
+func UpdatePurchaseOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+
+		order, err := bindPurchaseOrder(c, ctx)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		update := bson.M{"$set": bson.M{
+			"supplier_id":   order.SupplierId,
+			"supplier_name": order.SupplierName,
+			"currency":      order.Currency,
+			"lines":         order.Lines,
+			"total":         order.Total,
+			"expected_date": order.ExpectedDate,
+			"notes":         order.Notes,
+			"updatedTime":   time.Now().UTC(),
+		}}
+		updated, err := updatePurchaseOrder(c, ctx, []string{models.POStatusDraft}, update)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, updated)
+	}
+}
+
+// SubmitPurchaseOrder godoc
+// @Summary Submit a draft purchase order to the supplier
+// @Description Submitted orders can no longer be edited and are ready to be received.
+// @Tags Purchase Orders
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Purchase order ID"
+// @Success 200 {object} models.PurchaseOrder
+// @Failure 400,403,404,409 {object} apierror.Problem
+// @Router /purchase-orders/{id}/submit [post]
+func SubmitPurchaseOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		now := time.Now().UTC()
+		update := bson.M{"$set": bson.M{"status": models.POStatusSubmitted, "submitted_at": now, "updatedTime": now}}
+		updated, err := updatePurchaseOrder(c, ctx, []string{models.POStatusDraft}, update)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		logging.FromContext(ctx).Info("purchase order submitted", "purchaseOrderId", updated.ID.Hex())
+		c.JSON(http.StatusOK, updated)
+	}
+}
+
+// CancelPurchaseOrder godoc
+// @Summary Cancel a purchase order
+// @Description Only draft and submitted orders can be cancelled. Orders that were partly received are closed by receiving the rest.
+// @Tags Purchase Orders
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Purchase order ID"
+// @Success 200 {object} models.PurchaseOrder
+// @Failure 400,403,404,409 {object} apierror.Problem
+// @Router /purchase-orders/{id}/cancel [post]
+func CancelPurchaseOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		update := bson.M{"$set": bson.M{"status": models.POStatusCancelled, "updatedTime": time.Now().UTC()}}
+		updated, err := updatePurchaseOrder(c, ctx, []string{models.POStatusDraft, models.POStatusSubmitted}, update)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		logging.FromContext(ctx).Info("purchase order cancelled", "purchaseOrderId", updated.ID.Hex())
+		c.JSON(http.StatusOK, updated)
+	}
+}
@@ -0,0 +1,352 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yashaswini7291/Inventory/apierror"
+	"github.com/yashaswini7291/Inventory/database"
+	"github.com/yashaswini7291/Inventory/logging"
+	"github.com/yashaswini7291/Inventory/models"
+	"github.com/yashaswini7291/Inventory/repository"
+	"github.com/yashaswini7291/Inventory/stock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ReceiptCollection *mongo.Collection = database.OpenCollection(database.Client, "GoodsReceipts")
+	Receipts                            = repository.Scoped(ReceiptCollection)
+)
+
+// OverReceiptTolerance is the percentage by which a line may be received
+// beyond its ordered quantity without a manager's approval. It is read from
+// OVER_RECEIPT_TOLERANCE and defaults to 0.
+var OverReceiptTolerance = overReceiptTolerance()
+
+func overReceiptTolerance() float64 {
+	if pct, err := strconv.ParseFloat(os.Getenv("OVER_RECEIPT_TOLERANCE"), 64); err == nil && pct >= 0 {
+		return pct
+	}
+	return 0
+}
+
+type receiptLineRequest struct {
+	Line     int      `json:"line" validate:"required,min=1"`
+	Received int      `json:"received" validate:"required,min=1"`
+	Rejected int      `json:"rejected" validate:"min=0"`
+	Damaged  int      `json:"damaged" validate:"min=0"`
+	UnitCost *float64 `json:"unit_cost" validate:"omitempty,min=0"`
+	// Lot, with its dates, is required for products that track lots.
+	Lot            string     `json:"lot" validate:"max=100"`
+	ManufacturedAt *time.Time `json:"manufactured_at"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	// Serials lists one serial number per accepted unit for products that
+	// track them.
+	Serials []string `json:"serials" validate:"max=10000,dive,required,max=100"`
+}
+
+type receiptRequest struct {
+	Location string               `json:"location" validate:"max=50"`
+	Lines    []receiptLineRequest `json:"lines" validate:"required,min=1,max=500,dive"`
+	// Close marks the order received even though lines are still short.
+	Close bool `json:"close"`
+	// AllowOverReceipt accepts more than the tolerance allows.
+	AllowOverReceipt bool   `json:"allow_over_receipt"`
+	Notes            string `json:"notes" validate:"max=2000"`
+}
+
+// receiptLimit is the most units of line that may be accepted in total.
+func receiptLimit(line *models.PurchaseOrderLine) int {
+	return line.Quantity + int(float64(line.Quantity)*OverReceiptTolerance/100)
+}
+
+// applyReceipt checks req against order, records it on the order's lines
+// and returns the receipt. The order's status is updated as well.
+func applyReceipt(order *models.PurchaseOrder, req *receiptRequest) (*models.GoodsReceipt, error) {
+	receipt := &models.GoodsReceipt{
+		ID:              primitive.NewObjectID(),
+		PurchaseOrderId: order.ID,
+		SupplierId:      order.SupplierId,
+		Location:        req.Location,
+		Currency:        order.Currency,
+		Lines:           make([]models.ReceiptLine, 0, len(req.Lines)),
+		Notes:           req.Notes,
+	}
+	seen := make(map[int]bool, len(req.Lines))
+	var problems []apierror.FieldError
+	for i, lineReq := range req.Lines {
+		field := fmt.Sprintf("lines[%d]", i)
+		if lineReq.Line > len(order.Lines) {
+			problems = append(problems, apierror.FieldError{Field: field + ".line", Message: "no such line on the order"})
+			continue
+		}
+		if seen[lineReq.Line] {
+			problems = append(problems, apierror.FieldError{Field: field + ".line", Message: "is received twice"})
+			continue
+		}
+		seen[lineReq.Line] = true
+		if lineReq.Rejected+lineReq.Damaged > lineReq.Received {
+			problems = append(problems, apierror.FieldError{Field: field, Message: "rejected and damaged exceed received"})
+			continue
+		}
+
+		line := &order.Lines[lineReq.Line-1]
+		accepted := lineReq.Received - lineReq.Rejected - lineReq.Damaged
+		if line.Received+accepted > receiptLimit(line) && !req.AllowOverReceipt {
+			problems = append(problems, apierror.FieldError{
+				Field:   field + ".received",
+				Message: fmt.Sprintf("would accept %d of %d ordered", line.Received+accepted, line.Quantity),
+			})
+			continue
+		}
+		unitCost := line.UnitCost
+		if lineReq.UnitCost != nil {
+			unitCost = *lineReq.UnitCost
+		}
+		line.Received += accepted
+		line.Rejected += lineReq.Rejected
+		line.Damaged += lineReq.Damaged
+		receipt.Lines = append(receipt.Lines, models.ReceiptLine{
+			Line:      line.Line,
+			ProductId: line.ProductId,
+			SKU:       line.SKU,
+			Received:  lineReq.Received,
+			Rejected:  lineReq.Rejected,
+			Damaged:   lineReq.Damaged,
+			Accepted:  accepted,
+			UnitCost:  unitCost,
+			Lot:       lineReq.Lot,
+			Serials:   lineReq.Serials,
+		})
+	}
+	if len(problems) > 0 {
+		return nil, apierror.Validation(problems...)
+	}
+
+	order.Status = models.POStatusReceived
+	for _, line := range order.Lines {
+		if line.Outstanding() > 0 {
+			order.Status = models.POStatusPartiallyReceived
+			break
+		}
+	}
+	if req.Close && order.Status != models.POStatusReceived {
+		order.Status = models.POStatusReceived
+		receipt.Closed = true
+	}
+	return receipt, nil
+}
+
+// checkReceiptTracking requires a lot and serial numbers on the lines of
+// products that track them, and refuses them on the lines of products that
+// do not.
+func checkReceiptTracking(ctx context.Context, order *models.PurchaseOrder, req *receiptRequest) error {
+	var problems []apierror.FieldError
+	for i := range req.Lines {
+		lineReq := &req.Lines[i]
+		lineReq.Lot = strings.TrimSpace(lineReq.Lot)
+		if lineReq.Line > len(order.Lines) {
+			continue
+		}
+		product, err := findProduct(ctx, order.Lines[lineReq.Line-1].ProductId)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		field := fmt.Sprintf("lines[%d]", i)
+		switch {
+		case product.TrackLots && lineReq.Lot == "":
+			problems = append(problems, apierror.FieldError{Field: field + ".lot", Message: "is required, " + product.Name + " tracks lots"})
+		case !product.TrackLots && lineReq.Lot != "":
+			problems = append(problems, apierror.FieldError{Field: field + ".lot", Message: product.Name + " does not track lots"})
+		}
+		accepted := lineReq.Received - lineReq.Rejected - lineReq.Damaged
+		switch {
+		case product.TrackSerials && len(lineReq.Serials) != max(accepted, 0):
+			problems = append(problems, apierror.FieldError{Field: field + ".serials", Message: fmt.Sprintf("must list %d serial numbers, one per accepted unit", max(accepted, 0))})
+		case !product.TrackSerials && len(lineReq.Serials) > 0:
+			problems = append(problems, apierror.FieldError{Field: field + ".serials", Message: product.Name + " does not track serial numbers"})
+		}
+	}
+	if len(problems) > 0 {
+		return apierror.Validation(problems...)
+	}
+	return nil
+}
+
+// ReceivePurchaseOrder godoc
+// @Summary Receive goods against a purchase order
+// @Description Accepted units, received less rejected and damaged, are added to stock at location (default MAIN) at the actual unit_cost, which defaults to the order's. Lines of products that track lots must name a lot; a new lot is created with the given manufactured_at and expires_at. Lines of products that track serial numbers must list one serial per accepted unit, which registers them. The order must be in the currency the stock is valued in. A line may not be accepted beyond its ordered quantity plus OVER_RECEIPT_TOLERANCE percent unless a manager sets allow_over_receipt. The order becomes received once every line is complete, or partially_received otherwise; a manager may set close to finish a short order.
+// @Tags Purchase Orders
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Purchase order ID"
+// @Param body body receiptRequest true "Location and received lines"
+// @Success 201 {object} models.GoodsReceipt
+// @Failure 400,403,404,409,422 {object} apierror.Problem
+// @Router /purchase-orders/{id}/receipts [post]
+func ReceivePurchaseOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+
+		var req receiptRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid request payload"))
+			return
+		}
+		req.Location = strings.ToUpper(strings.TrimSpace(req.Location))
+		if req.Location == "" {
+			req.Location = stock.DefaultLocation
+		}
+		if err := Validate.Struct(req); err != nil {
+			apierror.Respond(c, apierror.FromValidator(err))
+			return
+		}
+		if req.Close || req.AllowOverReceipt {
+			switch c.GetString("role") {
+			case models.RoleManager, models.RoleAdmin:
+			default:
+				apierror.Respond(c, apierror.Forbidden("only managers may close an order or allow over-receipt"))
+				return
+			}
+		}
+		found, err := findPurchaseOrder(c, ctx)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		// The ledger adds costs up as one amount and there are no exchange
+		// rates to convert them, so they must be in the stock's currency.
+		currency, err := stock.OrganizationCurrency(ctx)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		if found.Currency != currency {
+			apierror.Respond(c, apierror.Conflict(fmt.Sprintf("purchase order is in %s but stock is valued in %s", found.Currency, currency)))
+			return
+		}
+		if err := checkReceiptTracking(ctx, found, &req); err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		var order models.PurchaseOrder
+		var receipt *models.GoodsReceipt
+		err = database.Transaction(ctx, func(sc mongo.SessionContext) error {
+			if err := PurchaseOrders.FindOne(sc, bson.M{"_id": found.ID}).Decode(&order); err != nil {
+				return err
+			}
+			if order.Status != models.POStatusSubmitted && order.Status != models.POStatusPartiallyReceived {
+				return apierror.Conflict("purchase order is " + order.Status)
+			}
+			var err error
+			receipt, err = applyReceipt(&order, &req)
+			if err != nil {
+				return err
+			}
+			now := time.Now().UTC()
+			receipt.ReceivedBy = c.GetString("uid")
+			receipt.CreatedTime = now
+			if _, err := Receipts.InsertOne(sc, receipt); err != nil {
+				return err
+			}
+			for i, line := range receipt.Lines {
+				if line.Accepted == 0 {
+					continue
+				}
+				if line.Lot != "" {
+					// Receipt lines follow the request lines one to one.
+					lineReq := req.Lines[i]
+					_, err := stock.EnsureLot(sc, &models.Lot{
+						ProductId:      line.ProductId,
+						LotNumber:      line.Lot,
+						ManufacturedAt: lineReq.ManufacturedAt,
+						ExpiresAt:      lineReq.ExpiresAt,
+					})
+					if err != nil {
+						return err
+					}
+				}
+				unitCost := line.UnitCost
+				err := stock.Post(sc, &models.StockMovement{
+					ProductId: line.ProductId,
+					Location:  receipt.Location,
+					Quantity:  line.Accepted,
+					Type:      models.MovementReceipt,
+					UnitCost:  &unitCost,
+					RefType:   "goods_receipt",
+					RefId:     receipt.ID.Hex(),
+					Lot:       line.Lot,
+					Serials:   line.Serials,
+					CreatedBy: receipt.ReceivedBy,
+				})
+				if err != nil {
+					return stockError(err)
+				}
+			}
+			order.UpdatedTime = now
+			update := bson.M{"$set": bson.M{"lines": order.Lines, "status": order.Status, "updatedTime": now}}
+			_, err = PurchaseOrders.UpdateOne(sc, bson.M{"_id": order.ID}, update)
+			return err
+		})
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		logging.FromContext(ctx).Info("goods received", "purchaseOrderId", order.ID.Hex(), "receiptId", receipt.ID.Hex(), "status", order.Status)
+		c.JSON(http.StatusCreated, receipt)
+	}
+}
+
+// ListPurchaseOrderReceipts godoc
+// @Summary List the receipts of a purchase order
+// @Description Oldest first.
+// @Tags Purchase Orders
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Produce json
+// @Param id path string true "Purchase order ID"
+// @Success 200 {array} models.GoodsReceipt
+// @Failure 400,404 {object} apierror.Problem
+// @Router /purchase-orders/{id}/receipts [get]
+func ListPurchaseOrderReceipts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		order, err := findPurchaseOrder(c, ctx)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		opts := options.Find().SetSort(bson.D{{Key: "createdTime", Value: 1}})
+		cursor, err := Receipts.Find(ctx, bson.M{"purchase_order_id": order.ID}, opts)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		defer cursor.Close(ctx)
+
+		receipts := make([]models.GoodsReceipt, 0)
+		if err := cursor.All(ctx, &receipts); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		c.JSON(http.StatusOK, receipts)
+	}
+}
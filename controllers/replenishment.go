@@ -0,0 +1,256 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yashaswini7291/Inventory/apierror"
+	"github.com/yashaswini7291/Inventory/database"
+	"github.com/yashaswini7291/Inventory/logging"
+	"github.com/yashaswini7291/Inventory/models"
+	"github.com/yashaswini7291/Inventory/replenishment"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type replenishmentSettingsRequest struct {
+	ReorderPoint        int    `json:"reorder_point" validate:"min=0"`
+	ReorderQty          int    `json:"reorder_qty" validate:"min=0"`
+	PreferredSupplierId string `json:"preferred_supplier_id"`
+}
+
+type replenishmentOrdersRequest struct {
+	// SupplierIds limits the orders to these suppliers. Empty means every
+	// supplier with suggestions.
+	SupplierIds []string `json:"supplier_ids"`
+}
+
+// SetProductReplenishment godoc
+// @Summary Set a product's reorder point, reorder quantity and preferred supplier
+// @Description A reorder_point of 0 leaves the product out of replenishment. An empty preferred_supplier_id clears it.
+// @Tags Replenishment
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param body body replenishmentSettingsRequest true "Replenishment settings"
+// @Success 200 {object} models.Product
+// @Failure 400,404,422 {object} apierror.Problem
+// @Router /products/{id}/replenishment [put]
+func SetProductReplenishment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid product ID"))
+			return
+		}
+		var req replenishmentSettingsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid request payload"))
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			apierror.Respond(c, apierror.FromValidator(err))
+			return
+		}
+
+		update := bson.M{"$set": bson.M{"reorder_point": req.ReorderPoint, "reorder_qty": req.ReorderQty}}
+		if req.PreferredSupplierId == "" {
+			update["$unset"] = bson.M{"preferred_supplier_id": ""}
+		} else {
+			supplierObjID, err := primitive.ObjectIDFromHex(req.PreferredSupplierId)
+			if err != nil {
+				apierror.Respond(c, apierror.Validation(apierror.FieldError{Field: "preferred_supplier_id", Message: "is not a valid ID"}))
+				return
+			}
+			if _, err := findSupplier(ctx, supplierObjID); isNotFound(err) {
+				apierror.Respond(c, apierror.Validation(apierror.FieldError{Field: "preferred_supplier_id", Message: "supplier not found"}))
+				return
+			} else if err != nil {
+				apierror.Respond(c, err)
+				return
+			}
+			update["$set"].(bson.M)["preferred_supplier_id"] = supplierObjID
+		}
+
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		var product models.Product
+		err = Products.FindOneAndUpdate(ctx, bson.M{"_id": objID}, update, opts).Decode(&product)
+		if err == mongo.ErrNoDocuments {
+			apierror.Respond(c, apierror.NotFound("Product not found"))
+			return
+		}
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		c.JSON(http.StatusOK, product)
+	}
+}
+
+// GetReplenishmentSuggestions godoc
+// @Summary Get purchase suggestions
+// @Description Products at or below their reorder point, grouped by preferred supplier. Stock is projected as available plus open purchase order quantities less the expected sales during the supplier's lead time, from the last 30 days of sales. Suggestions are refreshed by a background job every REPLENISHMENT_INTERVAL (default 1h); refresh=true computes them now.
+// @Tags Replenishment
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Produce json
+// @Param refresh query bool false "Compute the suggestions now"
+// @Success 200 {object} models.ReplenishmentRun
+// @Router /replenishment/suggestions [get]
+func GetReplenishmentSuggestions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+
+		var run *models.ReplenishmentRun
+		var err error
+		if c.Query("refresh") != "true" {
+			run, err = replenishment.Latest(ctx)
+		}
+		if err == nil && run == nil {
+			run, err = replenishment.Refresh(ctx)
+		}
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		c.JSON(http.StatusOK, run)
+	}
+}
+
+// CreateReplenishmentOrders godoc
+// @Summary Create draft purchase orders from the suggestions
+// @Description Computes the suggestions afresh and creates one draft purchase order per supplier, at the latest received unit cost. Products without a preferred supplier are skipped, and so are suppliers that still have a draft created from suggestions, so the request can safely be repeated.
+// @Tags Replenishment
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param body body replenishmentOrdersRequest false "Suppliers to order from"
+// @Success 201 {array} models.PurchaseOrder
+// @Failure 400,403,409 {object} apierror.Problem
+// @Router /replenishment/orders [post]
+func CreateReplenishmentOrders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+
+		var req replenishmentOrdersRequest
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				apierror.Respond(c, apierror.BadRequest("Invalid request payload"))
+				return
+			}
+		}
+		wanted := make(map[primitive.ObjectID]bool, len(req.SupplierIds))
+		for _, id := range req.SupplierIds {
+			objID, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				apierror.Respond(c, apierror.BadRequest("Invalid supplier ID"))
+				return
+			}
+			wanted[objID] = true
+		}
+
+		run, err := replenishment.Compute(ctx)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		now := time.Now().UTC()
+		created := make([]models.PurchaseOrder, 0)
+		for _, group := range run.Groups {
+			if group.SupplierId == nil || (len(wanted) > 0 && !wanted[*group.SupplierId]) {
+				continue
+			}
+			order := models.PurchaseOrder{
+				ID:           primitive.NewObjectID(),
+				SupplierId:   *group.SupplierId,
+				SupplierName: group.SupplierName,
+				Currency:     group.Currency,
+				Lines:        make([]models.PurchaseOrderLine, 0, len(group.Lines)),
+				Status:       models.POStatusDraft,
+				Notes:        "Created from replenishment suggestions",
+				Source:       models.POSourceReplenishment,
+				CreatedBy:    c.GetString("uid"),
+				CreatedTime:  now,
+				UpdatedTime:  now,
+			}
+			for i, suggestion := range group.Lines {
+				line := models.PurchaseOrderLine{
+					Line:      i + 1,
+					ProductId: suggestion.ProductId,
+					SKU:       suggestion.SKU,
+					Name:      suggestion.Name,
+					Quantity:  suggestion.SuggestedQty,
+					UnitCost:  suggestion.UnitCost,
+					LineTotal: roundMoney(float64(suggestion.SuggestedQty) * suggestion.UnitCost),
+				}
+				order.Lines = append(order.Lines, line)
+				order.Total += line.LineTotal
+			}
+			order.Total = roundMoney(order.Total)
+			created = append(created, order)
+		}
+		if len(created) == 0 {
+			apierror.Respond(c, apierror.Conflict("there is nothing to order"))
+			return
+		}
+
+		var inserted []models.PurchaseOrder
+		err = database.Transaction(ctx, func(sc mongo.SessionContext) error {
+			inserted = inserted[:0]
+			for i := range created {
+				order := &created[i]
+				// A supplier that still has a draft from suggestions is
+				// skipped, so repeating the request does not order twice.
+				// Touching the supplier makes concurrent requests conflict
+				// so they cannot both miss each other's draft.
+				touch := bson.M{"$set": bson.M{"updatedTime": now}}
+				if _, err := Suppliers.UpdateOne(sc, bson.M{"_id": order.SupplierId}, touch); err != nil {
+					return err
+				}
+				drafts, err := PurchaseOrders.CountDocuments(sc, bson.M{
+					"supplier_id": order.SupplierId,
+					"status":      models.POStatusDraft,
+					"source":      models.POSourceReplenishment,
+				})
+				if err != nil {
+					return err
+				}
+				if drafts > 0 {
+					continue
+				}
+				if _, err := PurchaseOrders.InsertOne(sc, order); err != nil {
+					return err
+				}
+				inserted = append(inserted, *order)
+			}
+			return nil
+		})
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		if len(inserted) == 0 {
+			apierror.Respond(c, apierror.Conflict("every supplier already has a draft order from suggestions; submit or cancel it first"))
+			return
+		}
+		created = inserted
+		// The new drafts count as on order, so the stored suggestions are
+		// out of date now.
+		if _, err := replenishment.Refresh(ctx); err != nil {
+			logging.FromContext(ctx).Error("refreshing replenishment suggestions failed", "error", err)
+		}
+		logging.FromContext(ctx).Info("purchase orders created from suggestions", "count", len(created))
+		c.JSON(http.StatusCreated, created)
+	}
+}
@@ -0,0 +1,297 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yashaswini7291/Inventory/apierror"
+	"github.com/yashaswini7291/Inventory/database"
+	"github.com/yashaswini7291/Inventory/logging"
+	"github.com/yashaswini7291/Inventory/models"
+	"github.com/yashaswini7291/Inventory/stock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type createReservationRequest struct {
+	ProductId  string `json:"product_id" validate:"required"`
+	Quantity   int    `json:"quantity" validate:"required,min=1"`
+	Holder     string `json:"holder" validate:"max=200"`
+	TTLSeconds int    `json:"ttl_seconds" validate:"min=0,max=3600"`
+}
+
+// ReservationPage is one page of reservations.
+type ReservationPage struct {
+	Items []models.Reservation `json:"items"`
+	Page  int                  `json:"page"`
+	Limit int                  `json:"limit"`
+	Total int64                `json:"total"`
+}
+
+// stockError maps errors of the stock package to API errors.
+func stockError(err error) error {
+	switch {
+	case errors.Is(err, stock.ErrInsufficientStock):
+		return apierror.Conflict(err.Error())
+	case errors.Is(err, stock.ErrReservationClosed):
+		return apierror.Conflict(err.Error())
+	case errors.Is(err, stock.ErrUnknownProduct):
+		return apierror.NotFound("Product not found")
+	case errors.Is(err, stock.ErrLotRequired), errors.Is(err, stock.ErrLotNotTracked), errors.Is(err, stock.ErrUnknownLot):
+		return apierror.Validation(apierror.FieldError{Field: "lot", Message: err.Error()})
+	case errors.Is(err, stock.ErrSerialsRequired), errors.Is(err, stock.ErrSerialsNotTracked):
+		return apierror.Validation(apierror.FieldError{Field: "serials", Message: err.Error()})
+	case errors.As(err, new(*stock.SerialError)):
+		return apierror.Conflict(err.Error())
+	default:
+		return err
+	}
+}
+
+// findReservation loads the reservation named by :id if the caller may
+// see it.
+func findReservation(c *gin.Context, ctx context.Context) (*models.Reservation, error) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return nil, apierror.BadRequest("Invalid reservation ID")
+	}
+	filter := visibleTo(c, "created_by")
+	filter["_id"] = objID
+	var reservation models.Reservation
+	err = stock.Reservations.FindOne(ctx, filter).Decode(&reservation)
+	if err == mongo.ErrNoDocuments {
+		return nil, apierror.NotFound("Reservation not found")
+	}
+	if err != nil {
+		return nil, apierror.Internal(err)
+	}
+	return &reservation, nil
+}
+
+// CreateReservation godoc
+// @Summary Hold stock of a product
+// @Description Lowers available but not on hand stock until the reservation is committed, released or expires. ttl_seconds defaults to RESERVATION_TTL (15 minutes) and may be at most 3600. Needs the manager or admin role, or an API key with the stock:adjust scope.
+// @Tags Reservations
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Accept json
+// @Produce json
+// @Param body body createReservationRequest true "Product, quantity, holder and lifetime"
+// @Success 201 {object} models.Reservation
+// @Failure 400,403,404,409,422 {object} apierror.Problem
+// @Router /reservations [post]
+func CreateReservation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		var req createReservationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid request payload"))
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			apierror.Respond(c, apierror.FromValidator(err))
+			return
+		}
+		objID, err := primitive.ObjectIDFromHex(req.ProductId)
+		if err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid product ID"))
+			return
+		}
+		if _, err := findProduct(ctx, objID); err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		ttl := stock.DefaultReservationTTL
+		if req.TTLSeconds > 0 {
+			ttl = time.Duration(req.TTLSeconds) * time.Second
+		}
+		if req.Holder == "" {
+			req.Holder = c.GetString("userName")
+		}
+		reservation := models.Reservation{
+			ID:        primitive.NewObjectID(),
+			ProductId: objID,
+			Quantity:  req.Quantity,
+			Holder:    req.Holder,
+			ExpiresAt: time.Now().UTC().Add(ttl),
+			CreatedBy: c.GetString("uid"),
+		}
+		err = database.Transaction(ctx, func(sc mongo.SessionContext) error {
+			return stock.Reserve(sc, &reservation)
+		})
+		if err != nil {
+			apierror.Respond(c, stockError(err))
+			return
+		}
+		logging.FromContext(ctx).Info("stock reserved", "reservationId", reservation.ID.Hex(), "productId", req.ProductId, "quantity", req.Quantity)
+		c.JSON(http.StatusCreated, reservation)
+	}
+}
+
+// ListReservations godoc
+// @Summary List reservations
+// @Description Users see the reservations they created; managers and admins see all of the organization. Newest first.
+// @Tags Reservations
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Produce json
+// @Param status query string false "active, committed, released or expired"
+// @Param product_id query string false "Only reservations of this product"
+// @Param page query int false "Page number, from 1"
+// @Param limit query int false "Page size, at most 100"
+// @Success 200 {object} ReservationPage
+// @Failure 400 {object} apierror.Problem
+// @Router /reservations [get]
+func ListReservations() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+
+		page, limit := pagination(c)
+		filter := visibleTo(c, "created_by")
+		if status := c.Query("status"); status != "" {
+			filter["status"] = status
+		}
+		if productID := c.Query("product_id"); productID != "" {
+			objID, err := primitive.ObjectIDFromHex(productID)
+			if err != nil {
+				apierror.Respond(c, apierror.BadRequest("Invalid product ID"))
+				return
+			}
+			filter["product_id"] = objID
+		}
+
+		total, err := stock.Reservations.CountDocuments(ctx, filter)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		opts := options.Find().
+			SetSort(bson.D{{Key: "createdTime", Value: -1}, {Key: "_id", Value: -1}}).
+			SetSkip(int64((page - 1) * limit)).
+			SetLimit(int64(limit))
+		cursor, err := stock.Reservations.Find(ctx, filter, opts)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		defer cursor.Close(ctx)
+
+		reservations := make([]models.Reservation, 0)
+		if err := cursor.All(ctx, &reservations); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		c.JSON(http.StatusOK, ReservationPage{Items: reservations, Page: page, Limit: limit, Total: total})
+	}
+}
+
+// GetReservation godoc
+// @Summary Get a reservation
+// @Tags Reservations
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Produce json
+// @Param id path string true "Reservation ID"
+// @Success 200 {object} models.Reservation
+// @Failure 400,404 {object} apierror.Problem
+// @Router /reservations/{id} [get]
+func GetReservation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		reservation, err := findReservation(c, ctx)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, reservation)
+	}
+}
+
+type commitReservationRequest struct {
+	Serials []string `json:"serials"`
+}
+
+// settleReservation commits or releases the reservation named by :id.
+// Reservations that belong to an order are settled through the order.
+func settleReservation(c *gin.Context, settle func(context.Context, primitive.ObjectID) (*models.Reservation, error)) {
+	var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	reservation, err := findReservation(c, ctx)
+	if err != nil {
+		apierror.Respond(c, err)
+		return
+	}
+	if reservation.OrderId != nil {
+		apierror.Respond(c, apierror.Conflict("this reservation belongs to order "+reservation.OrderId.Hex()))
+		return
+	}
+	var settled *models.Reservation
+	err = database.Transaction(ctx, func(sc mongo.SessionContext) error {
+		var err error
+		settled, err = settle(sc, reservation.ID)
+		return err
+	})
+	if err != nil {
+		apierror.Respond(c, stockError(err))
+		return
+	}
+	logging.FromContext(ctx).Info("reservation "+settled.Status, "reservationId", settled.ID.Hex())
+	c.JSON(http.StatusOK, settled)
+}
+
+// CommitReservation godoc
+// @Summary Commit a reservation
+// @Description Deducts the held units from on hand stock. Expired reservations cannot be committed. Products that track serial numbers need the serials of the shipped units. Needs the manager or admin role, or an API key with the stock:adjust scope.
+// @Tags Reservations
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Reservation ID"
+// @Param body body commitReservationRequest false "Serial numbers shipped"
+// @Success 200 {object} models.Reservation
+// @Failure 400,403,404,409,422 {object} apierror.Problem
+// @Router /reservations/{id}/commit [post]
+func CommitReservation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req commitReservationRequest
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				apierror.Respond(c, apierror.BadRequest("Invalid request payload"))
+				return
+			}
+		}
+		settleReservation(c, func(ctx context.Context, id primitive.ObjectID) (*models.Reservation, error) {
+			return stock.CommitSerials(ctx, id, req.Serials)
+		})
+	}
+}
+
+// ReleaseReservation godoc
+// @Summary Release a reservation
+// @Description Returns the held units to available stock. Needs the manager or admin role, or an API key with the stock:adjust scope.
+// @Tags Reservations
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Produce json
+// @Param id path string true "Reservation ID"
+// @Success 200 {object} models.Reservation
+// @Failure 400,403,404,409 {object} apierror.Problem
+// @Router /reservations/{id}/release [post]
+func ReleaseReservation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		settleReservation(c, stock.Release)
+	}
+}
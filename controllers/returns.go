@@ -0,0 +1,534 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yashaswini7291/Inventory/apierror"
+	"github.com/yashaswini7291/Inventory/database"
+	"github.com/yashaswini7291/Inventory/logging"
+	"github.com/yashaswini7291/Inventory/models"
+	"github.com/yashaswini7291/Inventory/repository"
+	"github.com/yashaswini7291/Inventory/stock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ReturnCollection *mongo.Collection = database.OpenCollection(database.Client, "Returns")
+	Returns                            = repository.Scoped(ReturnCollection)
+)
+
+type returnLineRequest struct {
+	OrderLine int    `json:"order_line" validate:"required,min=1"`
+	Quantity  int    `json:"quantity" validate:"required,min=1"`
+	Reason    string `json:"reason" validate:"required,oneof=damaged defective wrong_item not_as_described no_longer_needed other"`
+}
+
+type createReturnRequest struct {
+	OrderId string              `json:"order_id" validate:"required"`
+	Lines   []returnLineRequest `json:"lines" validate:"required,min=1,max=500,dive"`
+	Notes   string              `json:"notes" validate:"max=2000"`
+}
+
+type inspectLineRequest struct {
+	OrderLine int    `json:"order_line" validate:"required,min=1"`
+	Outcome   string `json:"outcome" validate:"required,oneof=restock scrap return_to_vendor"`
+	// RefundAmount defaults to the price paid for the returned units.
+	RefundAmount *float64 `json:"refund_amount" validate:"omitempty,min=0"`
+	// Lot is required for products that track lots, Serials, one per
+	// returned unit, for products that track serial numbers.
+	Lot     string   `json:"lot" validate:"max=100"`
+	Serials []string `json:"serials" validate:"max=10000,dive,required,max=100"`
+}
+
+type inspectReturnRequest struct {
+	Location string               `json:"location" validate:"max=50"`
+	Lines    []inspectLineRequest `json:"lines" validate:"required,min=1,max=500,dive"`
+}
+
+// ReturnPage is one page of return authorizations.
+type ReturnPage struct {
+	Items []models.ReturnAuthorization `json:"items"`
+	Page  int                          `json:"page"`
+	Limit int                          `json:"limit"`
+	Total int64                        `json:"total"`
+}
+
+// returnedQuantities sums, per order line, what open and completed returns
+// of the order already cover.
+func returnedQuantities(ctx context.Context, orderID primitive.ObjectID) (map[int]int, error) {
+	cursor, err := Returns.Find(ctx, bson.M{"order_id": orderID, "status": bson.M{"$ne": models.ReturnCancelled}})
+	if err != nil {
+		return nil, err
+	}
+	var returns []models.ReturnAuthorization
+	if err := cursor.All(ctx, &returns); err != nil {
+		return nil, err
+	}
+	returned := make(map[int]int)
+	for _, r := range returns {
+		for _, line := range r.Lines {
+			returned[line.OrderLine] += line.Quantity
+		}
+	}
+	return returned, nil
+}
+
+// findReturn loads the return named by :id if the caller may see it.
+func findReturn(c *gin.Context, ctx context.Context) (*models.ReturnAuthorization, error) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return nil, apierror.BadRequest("Invalid return ID")
+	}
+	filter := visibleTo(c, "userId")
+	filter["_id"] = objID
+	var rma models.ReturnAuthorization
+	err = Returns.FindOne(ctx, filter).Decode(&rma)
+	if err == mongo.ErrNoDocuments {
+		return nil, apierror.NotFound("Return not found")
+	}
+	if err != nil {
+		return nil, apierror.Internal(err)
+	}
+	return &rma, nil
+}
+
+// CreateReturn godoc
+// @Summary Authorize a customer return
+// @Description Lines refer to the lines of a placed sales order by position, from 1. A line cannot be returned more often than it was ordered. Users can return their own orders; managers and admins any order.
+// @Tags Returns
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param body body createReturnRequest true "Order, lines and reasons"
+// @Success 201 {object} models.ReturnAuthorization
+// @Failure 400,404,409,422 {object} apierror.Problem
+// @Router /returns [post]
+func CreateReturn() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+
+		var req createReturnRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid request payload"))
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			apierror.Respond(c, apierror.FromValidator(err))
+			return
+		}
+		orderID, err := primitive.ObjectIDFromHex(req.OrderId)
+		if err != nil {
+			apierror.Respond(c, apierror.Validation(apierror.FieldError{Field: "order_id", Message: "is not a valid ID"}))
+			return
+		}
+		filter := visibleTo(c, "userId")
+		filter["_id"] = orderID
+		var order models.SalesOrder
+		err = Orders.FindOne(ctx, filter).Decode(&order)
+		if err == mongo.ErrNoDocuments {
+			apierror.Respond(c, apierror.Validation(apierror.FieldError{Field: "order_id", Message: "order not found"}))
+			return
+		}
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		if order.Status != models.OrderStatusPlaced {
+			apierror.Respond(c, apierror.Conflict("order is "+order.Status))
+			return
+		}
+
+		now := time.Now().UTC()
+		rma := models.ReturnAuthorization{
+			ID:          primitive.NewObjectID(),
+			OrderId:     order.ID,
+			UserId:      order.UserId,
+			Lines:       make([]models.ReturnLine, 0, len(req.Lines)),
+			Status:      models.ReturnAuthorized,
+			Notes:       req.Notes,
+			CreatedBy:   c.GetString("uid"),
+			CreatedTime: now,
+			UpdatedTime: now,
+		}
+		err = database.Transaction(ctx, func(sc mongo.SessionContext) error {
+			// Touching the order makes concurrent returns of it conflict, so
+			// the quantities checked below cannot be overtaken.
+			if _, err := Orders.UpdateOne(sc, bson.M{"_id": order.ID}, bson.M{"$set": bson.M{"updatedTime": now}}); err != nil {
+				return err
+			}
+			returned, err := returnedQuantities(sc, order.ID)
+			if err != nil {
+				return err
+			}
+			rma.Lines = rma.Lines[:0]
+			rma.RefundTotal = 0
+			var problems []apierror.FieldError
+			for i, lineReq := range req.Lines {
+				field := fmt.Sprintf("lines[%d]", i)
+				if lineReq.OrderLine > len(order.Lines) {
+					problems = append(problems, apierror.FieldError{Field: field + ".order_line", Message: "no such line on the order"})
+					continue
+				}
+				orderLine := order.Lines[lineReq.OrderLine-1]
+				left := orderLine.Quantity - returned[lineReq.OrderLine]
+				if lineReq.Quantity > left {
+					problems = append(problems, apierror.FieldError{
+						Field:   field + ".quantity",
+						Message: fmt.Sprintf("only %d of %s can still be returned", max(left, 0), orderLine.Name),
+					})
+					continue
+				}
+				returned[lineReq.OrderLine] += lineReq.Quantity
+				line := models.ReturnLine{
+					OrderLine:    lineReq.OrderLine,
+					ProductId:    orderLine.ProductId,
+					SKU:          orderLine.SKU,
+					Name:         orderLine.Name,
+					Quantity:     lineReq.Quantity,
+					UnitPrice:    orderLine.UnitPrice,
+					Reason:       lineReq.Reason,
+					RefundAmount: roundMoney(float64(lineReq.Quantity) * orderLine.UnitPrice),
+				}
+				rma.Lines = append(rma.Lines, line)
+				rma.RefundTotal += line.RefundAmount
+			}
+			if len(problems) > 0 {
+				return apierror.Validation(problems...)
+			}
+			rma.RefundTotal = roundMoney(rma.RefundTotal)
+			_, err = Returns.InsertOne(sc, &rma)
+			return err
+		})
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		logging.FromContext(ctx).Info("return authorized", "returnId", rma.ID.Hex(), "orderId", order.ID.Hex())
+		c.JSON(http.StatusCreated, rma)
+	}
+}
+
+// ListReturns godoc
+// @Summary List return authorizations
+// @Description Users see returns of their own orders; managers and admins see all of the organization. Newest first.
+// @Tags Returns
+// @Security BearerAuth
+// @Produce json
+// @Param status query string false "authorized, completed or cancelled"
+// @Param order_id query string false "Only returns of this order"
+// @Param page query int false "Page number, from 1"
+// @Param limit query int false "Page size, at most 100"
+// @Success 200 {object} ReturnPage
+// @Failure 400 {object} apierror.Problem
+// @Router /returns [get]
+func ListReturns() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+
+		page, limit := pagination(c)
+		filter := visibleTo(c, "userId")
+		if status := c.Query("status"); status != "" {
+			filter["status"] = status
+		}
+		if orderID := c.Query("order_id"); orderID != "" {
+			objID, err := primitive.ObjectIDFromHex(orderID)
+			if err != nil {
+				apierror.Respond(c, apierror.BadRequest("Invalid order ID"))
+				return
+			}
+			filter["order_id"] = objID
+		}
+		total, err := Returns.CountDocuments(ctx, filter)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		opts := options.Find().
+			SetSort(bson.D{{Key: "createdTime", Value: -1}, {Key: "_id", Value: -1}}).
+			SetSkip(int64((page - 1) * limit)).
+			SetLimit(int64(limit))
+		cursor, err := Returns.Find(ctx, filter, opts)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		defer cursor.Close(ctx)
+
+		returns := make([]models.ReturnAuthorization, 0)
+		if err := cursor.All(ctx, &returns); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		c.JSON(http.StatusOK, ReturnPage{Items: returns, Page: page, Limit: limit, Total: total})
+	}
+}
+
+// GetReturn godoc
+// @Summary Get a return authorization
+// @Tags Returns
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Return ID"
+// @Success 200 {object} models.ReturnAuthorization
+// @Failure 400,404 {object} apierror.Problem
+// @Router /returns/{id} [get]
+func GetReturn() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		rma, err := findReturn(c, ctx)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, rma)
+	}
+}
+
+// returnMovements posts the ledger movements for an inspected line: the
+// units come back into stock at the line's location, and unless they are
+// restocked leave it again as a write-off or a return to the vendor.
+func returnMovements(ctx context.Context, rma *models.ReturnAuthorization, line *models.ReturnLine) error {
+	movement := models.StockMovement{
+		ProductId: line.ProductId,
+		Location:  line.Location,
+		Quantity:  line.Quantity,
+		Type:      models.MovementReturn,
+		RefType:   "return",
+		RefId:     rma.ID.Hex(),
+		Lot:       line.Lot,
+		Serials:   line.Serials,
+		Note:      line.Reason,
+		CreatedBy: line.InspectedBy,
+	}
+	if err := stock.Post(ctx, &movement); err != nil {
+		return err
+	}
+	switch line.Outcome {
+	case models.OutcomeScrap:
+		movement.Type = models.MovementScrap
+	case models.OutcomeReturnToVendor:
+		movement.Type = models.MovementVendorReturn
+	default:
+		return nil
+	}
+	movement.ID = primitive.NilObjectID
+	movement.Quantity = -line.Quantity
+	return stock.Post(ctx, &movement)
+}
+
+// shippedOnOrder returns the lots and serials of a product that left stock
+// on the sales order.
+func shippedOnOrder(ctx context.Context, orderID primitive.ObjectID, productID primitive.ObjectID) (lots map[string]bool, serials map[string]bool, err error) {
+	filter := bson.M{
+		"type":       models.MovementSale,
+		"ref_type":   "sales_order",
+		"ref_id":     orderID.Hex(),
+		"product_id": productID,
+	}
+	opts := options.Find().SetProjection(bson.M{"lot": 1, "serials": 1})
+	cursor, err := stock.Movements.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	var movements []models.StockMovement
+	if err := cursor.All(ctx, &movements); err != nil {
+		return nil, nil, err
+	}
+	lots = make(map[string]bool)
+	serials = make(map[string]bool)
+	for _, m := range movements {
+		if m.Lot != "" {
+			lots[m.Lot] = true
+		}
+		for _, serial := range m.Serials {
+			serials[serial] = true
+		}
+	}
+	return lots, serials, nil
+}
+
+// InspectReturn godoc
+// @Summary Record the inspection of returned goods
+// @Description Sets the outcome and refund of some or all lines. Restocked units go back into stock at location (default MAIN); scrapped units are written off and units returned to the vendor leave stock again, both recorded in the stock ledger. Lines of products that track lots must name the lot the units came from, and lines of products that track serial numbers must list their serials; both must have been shipped on the order. The refund defaults to the price paid and cannot exceed it. The return is completed once every line has been inspected.
+// @Tags Returns
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Return ID"
+// @Param body body inspectReturnRequest true "Location and outcomes"
+// @Success 200 {object} models.ReturnAuthorization
+// @Failure 400,403,404,409,422 {object} apierror.Problem
+// @Router /returns/{id}/inspect [post]
+func InspectReturn() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+
+		var req inspectReturnRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid request payload"))
+			return
+		}
+		req.Location = strings.ToUpper(strings.TrimSpace(req.Location))
+		if req.Location == "" {
+			req.Location = stock.DefaultLocation
+		}
+		if err := Validate.Struct(req); err != nil {
+			apierror.Respond(c, apierror.FromValidator(err))
+			return
+		}
+		found, err := findReturn(c, ctx)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		var rma models.ReturnAuthorization
+		err = database.Transaction(ctx, func(sc mongo.SessionContext) error {
+			if err := Returns.FindOne(sc, bson.M{"_id": found.ID}).Decode(&rma); err != nil {
+				return err
+			}
+			if rma.Status != models.ReturnAuthorized {
+				return apierror.Conflict("return is " + rma.Status)
+			}
+			now := time.Now().UTC()
+			var problems []apierror.FieldError
+			for i, lineReq := range req.Lines {
+				field := fmt.Sprintf("lines[%d]", i)
+				var line *models.ReturnLine
+				for j := range rma.Lines {
+					if rma.Lines[j].OrderLine == lineReq.OrderLine {
+						line = &rma.Lines[j]
+						break
+					}
+				}
+				if line == nil {
+					problems = append(problems, apierror.FieldError{Field: field + ".order_line", Message: "is not part of this return"})
+					continue
+				}
+				if line.InspectedAt != nil {
+					problems = append(problems, apierror.FieldError{Field: field + ".order_line", Message: "has already been inspected"})
+					continue
+				}
+				paid := roundMoney(float64(line.Quantity) * line.UnitPrice)
+				if lineReq.RefundAmount != nil {
+					if *lineReq.RefundAmount > paid {
+						problems = append(problems, apierror.FieldError{Field: field + ".refund_amount", Message: fmt.Sprintf("must be at most %.2f", paid)})
+						continue
+					}
+					line.RefundAmount = roundMoney(*lineReq.RefundAmount)
+				}
+				lot := strings.TrimSpace(lineReq.Lot)
+				if lot != "" || len(lineReq.Serials) > 0 {
+					lots, serials, err := shippedOnOrder(sc, rma.OrderId, line.ProductId)
+					if err != nil {
+						return err
+					}
+					if lot != "" && !lots[lot] {
+						problems = append(problems, apierror.FieldError{Field: field + ".lot", Message: "was not shipped on the order"})
+						continue
+					}
+					if unknown := slices.IndexFunc(lineReq.Serials, func(serial string) bool { return !serials[serial] }); unknown >= 0 {
+						problems = append(problems, apierror.FieldError{
+							Field:   fmt.Sprintf("%s.serials[%d]", field, unknown),
+							Message: "was not shipped on the order",
+						})
+						continue
+					}
+				}
+				line.Outcome = lineReq.Outcome
+				line.Location = req.Location
+				line.Lot = lot
+				line.Serials = lineReq.Serials
+				line.InspectedBy = c.GetString("uid")
+				line.InspectedAt = &now
+				if err := returnMovements(sc, &rma, line); err != nil {
+					return stockError(err)
+				}
+			}
+			if len(problems) > 0 {
+				return apierror.Validation(problems...)
+			}
+
+			rma.Status = models.ReturnCompleted
+			rma.RefundTotal = 0
+			for _, line := range rma.Lines {
+				if line.InspectedAt == nil {
+					rma.Status = models.ReturnAuthorized
+				}
+				rma.RefundTotal += line.RefundAmount
+			}
+			rma.RefundTotal = roundMoney(rma.RefundTotal)
+			rma.UpdatedTime = now
+			update := bson.M{"$set": bson.M{
+				"lines":        rma.Lines,
+				"status":       rma.Status,
+				"refund_total": rma.RefundTotal,
+				"updatedTime":  now,
+			}}
+			_, err := Returns.UpdateOne(sc, bson.M{"_id": rma.ID}, update)
+			return err
+		})
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		logging.FromContext(ctx).Info("return inspected", "returnId", rma.ID.Hex(), "status", rma.Status)
+		c.JSON(http.StatusOK, rma)
+	}
+}
+
+// CancelReturn godoc
+// @Summary Cancel a return authorization
+// @Description Only returns of which no line has been inspected can be cancelled.
+// @Tags Returns
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Return ID"
+// @Success 200 {object} models.ReturnAuthorization
+// @Failure 400,404,409 {object} apierror.Problem
+// @Router /returns/{id}/cancel [post]
+func CancelReturn() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		rma, err := findReturn(c, ctx)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		filter := bson.M{
+			"_id":                rma.ID,
+			"status":             models.ReturnAuthorized,
+			"lines.inspected_at": bson.M{"$exists": false},
+		}
+		update := bson.M{"$set": bson.M{"status": models.ReturnCancelled, "updatedTime": time.Now().UTC()}}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		var cancelled models.ReturnAuthorization
+		err = Returns.FindOneAndUpdate(ctx, filter, update, opts).Decode(&cancelled)
+		if err == mongo.ErrNoDocuments {
+			apierror.Respond(c, apierror.Conflict("return is "+rma.Status+" or partly inspected"))
+			return
+		}
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		logging.FromContext(ctx).Info("return cancelled", "returnId", cancelled.ID.Hex())
+		c.JSON(http.StatusOK, cancelled)
+	}
+}
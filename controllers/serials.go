@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yashaswini7291/Inventory/apierror"
+	"github.com/yashaswini7291/Inventory/stock"
+)
+
+// GetSerial godoc
+// @Summary Look up a serial number
+// @Description Returns every unit with this serial number, one per product, with its status, location and full history.
+// @Tags Serials
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Produce json
+// @Param sn path string true "Serial number"
+// @Success 200 {array} models.Serial
+// @Failure 404 {object} apierror.Problem
+// @Router /serials/{sn} [get]
+func GetSerial() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		serials, err := stock.FindSerial(ctx, c.Param("sn"))
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		if len(serials) == 0 {
+			apierror.Respond(c, apierror.NotFound("Serial number not found"))
+			return
+		}
+		c.JSON(http.StatusOK, serials)
+	}
+}
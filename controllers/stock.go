@@ -0,0 +1,255 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yashaswini7291/Inventory/apierror"
+	"github.com/yashaswini7291/Inventory/models"
+	"github.com/yashaswini7291/Inventory/stock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ProductStock is a product's stock in total and by location.
+type ProductStock struct {
+	ProductId primitive.ObjectID  `json:"product_id"`
+	OnHand    int                 `json:"on_hand"`
+	Reserved  int                 `json:"reserved"`
+	Available int                 `json:"available"`
+	Locations []models.StockLevel `json:"locations"`
+	// Lots holds the stock by lot and location, in picking order, for
+	// products that track lots.
+	Lots []models.LotLevel `json:"lots,omitempty"`
+	// Expired is the stock in expired lots. It counts towards available
+	// but cannot be sold or reserved.
+	Expired int `json:"expired"`
+}
+
+// StockReport is the stock of products by location at a point in time.
+type StockReport struct {
+	AsOf  time.Time         `json:"as_of"`
+	Items []StockReportLine `json:"items"`
+}
+
+// StockReportLine is the stock of one product at one location.
+type StockReportLine struct {
+	ProductId primitive.ObjectID `json:"product_id"`
+	SKU       string             `json:"sku"`
+	Name      string             `json:"name"`
+	Location  string             `json:"location"`
+	OnHand    int                `json:"on_hand"`
+	Value     float64            `json:"value"`
+}
+
+// MovementPage is one page of the stock ledger.
+type MovementPage struct {
+	Items []models.StockMovement `json:"items"`
+	Page  int                    `json:"page"`
+	Limit int                    `json:"limit"`
+	Total int64                  `json:"total"`
+}
+
+// GetProductStock godoc
+// @Summary Get a product's stock by location
+// @Tags Stock
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Produce json
+// @Param id path string true "Product ID"
+// @Success 200 {object} ProductStock
+// @Failure 400,404 {object} apierror.Problem
+// @Router /products/{id}/stock [get]
+func GetProductStock() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid product ID"))
+			return
+		}
+		product, err := findProduct(ctx, objID)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		levels, err := stock.LevelsOf(ctx, objID)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		result := ProductStock{
+			ProductId: product.ProductId,
+			OnHand:    product.OnHand,
+			Reserved:  product.Reserved,
+			Available: product.Available(),
+			Locations: levels,
+		}
+		if product.TrackLots {
+			if result.Lots, err = stock.LotLevelsOf(ctx, objID); err != nil {
+				apierror.Respond(c, apierror.Internal(err))
+				return
+			}
+			for _, lot := range result.Lots {
+				if lot.Expired {
+					result.Expired += lot.OnHand
+				}
+			}
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// ListStockMovements godoc
+// @Summary List stock movements
+// @Description The stock ledger, newest first.
+// @Tags Stock
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Produce json
+// @Param product_id query string false "Only movements of this product"
+// @Param location query string false "Only movements at this location"
+// @Param type query string false "opening, receipt, sale, adjustment, return, scrap or vendor_return"
+// @Param lot query string false "Only movements of this lot"
+// @Param page query int false "Page number, from 1"
+// @Param limit query int false "Page size, at most 100"
+// @Success 200 {object} MovementPage
+// @Failure 400 {object} apierror.Problem
+// @Router /stock/movements [get]
+func ListStockMovements() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+
+		page, limit := pagination(c)
+		filter := bson.M{}
+		if productID := c.Query("product_id"); productID != "" {
+			objID, err := primitive.ObjectIDFromHex(productID)
+			if err != nil {
+				apierror.Respond(c, apierror.BadRequest("Invalid product ID"))
+				return
+			}
+			filter["product_id"] = objID
+		}
+		if location := c.Query("location"); location != "" {
+			filter["location"] = strings.ToUpper(location)
+		}
+		if movementType := c.Query("type"); movementType != "" {
+			filter["type"] = movementType
+		}
+		if lot := c.Query("lot"); lot != "" {
+			filter["lot"] = lot
+		}
+
+		total, err := stock.Movements.CountDocuments(ctx, filter)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		opts := options.Find().
+			SetSort(bson.D{{Key: "createdTime", Value: -1}, {Key: "_id", Value: -1}}).
+			SetSkip(int64((page - 1) * limit)).
+			SetLimit(int64(limit))
+		cursor, err := stock.Movements.Find(ctx, filter, opts)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		defer cursor.Close(ctx)
+
+		movements := make([]models.StockMovement, 0)
+		if err := cursor.All(ctx, &movements); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		c.JSON(http.StatusOK, MovementPage{Items: movements, Page: page, Limit: limit, Total: total})
+	}
+}
+
+// productsOf loads the products of balances by ID.
+func productsOf(ctx context.Context, balances []models.StockBalance) (map[primitive.ObjectID]models.Product, error) {
+	ids := make([]primitive.ObjectID, 0, len(balances))
+	for _, b := range balances {
+		ids = append(ids, b.ProductId)
+	}
+	cursor, err := Products.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	var found []models.Product
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	products := make(map[primitive.ObjectID]models.Product, len(found))
+	for _, p := range found {
+		products[p.ProductId] = p
+	}
+	return products, nil
+}
+
+// GetStockReport godoc
+// @Summary Get the stock on hand at a point in time
+// @Description Stock and its value by product and location, rebuilt from the latest daily snapshot before as_of and the stock movements since.
+// @Tags Reports
+// @Security BearerAuth
+// @Produce json
+// @Param as_of query string true "Date (end of day, UTC) or RFC 3339 time"
+// @Param product_id query string false "Only this product"
+// @Param location query string false "Only this location"
+// @Success 200 {object} StockReport
+// @Failure 400,403 {object} apierror.Problem
+// @Router /reports/stock [get]
+func GetStockReport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 60*time.Second)
+		defer cancel()
+
+		asOf, err := parseAsOf(c.Query("as_of"))
+		if err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid as_of: "+err.Error()))
+			return
+		}
+		var productIDs []primitive.ObjectID
+		if productID := c.Query("product_id"); productID != "" {
+			objID, err := primitive.ObjectIDFromHex(productID)
+			if err != nil {
+				apierror.Respond(c, apierror.BadRequest("Invalid product ID"))
+				return
+			}
+			productIDs = append(productIDs, objID)
+		}
+		location := strings.ToUpper(c.Query("location"))
+
+		balances, err := stock.Balances(ctx, asOf, productIDs...)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		products, err := productsOf(ctx, balances)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		report := StockReport{AsOf: asOf, Items: make([]StockReportLine, 0, len(balances))}
+		for _, b := range balances {
+			if location != "" && b.Location != location {
+				continue
+			}
+			report.Items = append(report.Items, StockReportLine{
+				ProductId: b.ProductId,
+				SKU:       products[b.ProductId].SKU,
+				Name:      products[b.ProductId].Name,
+				Location:  b.Location,
+				OnHand:    b.OnHand,
+				Value:     b.Value,
+			})
+		}
+		c.JSON(http.StatusOK, report)
+	}
+}
@@ -0,0 +1,274 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yashaswini7291/Inventory/apierror"
+	"github.com/yashaswini7291/Inventory/database"
+	"github.com/yashaswini7291/Inventory/logging"
+	"github.com/yashaswini7291/Inventory/models"
+	"github.com/yashaswini7291/Inventory/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	SupplierCollection *mongo.Collection = database.OpenCollection(database.Client, "Suppliers")
+	Suppliers                            = repository.Scoped(SupplierCollection)
+)
+
+// SupplierPage is one page of the supplier directory.
+type SupplierPage struct {
+	Items []models.Supplier `json:"items"`
+	Page  int               `json:"page"`
+	Limit int               `json:"limit"`
+	Total int64             `json:"total"`
+}
+
+// bindSupplier reads and validates a supplier from the request body.
+func bindSupplier(c *gin.Context) (*models.Supplier, error) {
+	var supplier models.Supplier
+	if err := c.ShouldBindJSON(&supplier); err != nil {
+		return nil, apierror.BadRequest("Invalid request payload")
+	}
+	supplier.Name = strings.TrimSpace(supplier.Name)
+	supplier.Currency = strings.ToUpper(supplier.Currency)
+	if err := Validate.Struct(supplier); err != nil {
+		return nil, apierror.FromValidator(err)
+	}
+	return &supplier, nil
+}
+
+func supplierID(c *gin.Context) (primitive.ObjectID, error) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return objID, apierror.BadRequest("Invalid supplier ID")
+	}
+	return objID, nil
+}
+
+func findSupplier(ctx context.Context, id primitive.ObjectID) (*models.Supplier, error) {
+	var supplier models.Supplier
+	err := Suppliers.FindOne(ctx, bson.M{"_id": id}).Decode(&supplier)
+	if err == mongo.ErrNoDocuments {
+		return nil, apierror.NotFound("Supplier not found")
+	}
+	if err != nil {
+		return nil, apierror.Internal(err)
+	}
+	return &supplier, nil
+}
+
+// CreateSupplier godoc
+// @Summary Add a supplier
+// @Tags Suppliers
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param supplier body models.Supplier true "Supplier"
+// @Success 201 {object} models.Supplier
+// @Failure 400,403,422 {object} apierror.Problem
+// @Router /suppliers [post]
+func CreateSupplier() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		supplier, err := bindSupplier(c)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		now := time.Now().UTC()
+		supplier.ID = primitive.NewObjectID()
+		supplier.CreatedTime = now
+		supplier.UpdatedTime = now
+		if _, err := Suppliers.InsertOne(ctx, supplier); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		logging.FromContext(ctx).Info("supplier created", "supplierId", supplier.ID.Hex())
+		c.JSON(http.StatusCreated, supplier)
+	}
+}
+
+// ListSuppliers godoc
+// @Summary List suppliers
+// @Tags Suppliers
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Produce json
+// @Param q query string false "Only suppliers whose name contains this text"
+// @Param page query int false "Page number, from 1"
+// @Param limit query int false "Page size, at most 100"
+// @Success 200 {object} SupplierPage
+// @Router /suppliers [get]
+func ListSuppliers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+
+		page, limit := pagination(c)
+		filter := bson.M{}
+		if q := strings.TrimSpace(c.Query("q")); q != "" {
+			filter["name"] = primitive.Regex{Pattern: regexp.QuoteMeta(q), Options: "i"}
+		}
+		total, err := Suppliers.CountDocuments(ctx, filter)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		opts := options.Find().
+			SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}).
+			SetSkip(int64((page - 1) * limit)).
+			SetLimit(int64(limit))
+		cursor, err := Suppliers.Find(ctx, filter, opts)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		defer cursor.Close(ctx)
+
+		suppliers := make([]models.Supplier, 0)
+		if err := cursor.All(ctx, &suppliers); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		c.JSON(http.StatusOK, SupplierPage{Items: suppliers, Page: page, Limit: limit, Total: total})
+	}
+}
+
+// GetSupplier godoc
+// @Summary Get a supplier
+// @Tags Suppliers
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Produce json
+// @Param id path string true "Supplier ID"
+// @Success 200 {object} models.Supplier
+// @Failure 400,404 {object} apierror.Problem
+// @Router /suppliers/{id} [get]
+func GetSupplier() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		objID, err := supplierID(c)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		supplier, err := findSupplier(ctx, objID)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, supplier)
+	}
+}
+
+// UpdateSupplier godoc
+// @Summary Replace a supplier's details
+// @Description Existing purchase orders keep the currency and name they were created with.
+// @Tags Suppliers
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Supplier ID"
+// @Param supplier body models.Supplier true "Supplier"
+// @Success 200 {object} models.Supplier
+// @Failure 400,403,404,422 {object} apierror.Problem
+// @Router /suppliers/{id} [put]
+func UpdateSupplier() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		objID, err := supplierID(c)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		supplier, err := bindSupplier(c)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		update := bson.M{"$set": bson.M{
+			"name":           supplier.Name,
+			"contact_name":   supplier.ContactName,
+			"email":          supplier.Email,
+			"phone":          supplier.Phone,
+			"address":        supplier.Address,
+			"lead_time_days": supplier.LeadTimeDays,
+			"currency":       supplier.Currency,
+			"payment_terms":  supplier.PaymentTerms,
+			"notes":          supplier.Notes,
+			"updatedTime":    time.Now().UTC(),
+		}}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		var updated models.Supplier
+		err = Suppliers.FindOneAndUpdate(ctx, bson.M{"_id": objID}, update, opts).Decode(&updated)
+		if err == mongo.ErrNoDocuments {
+			apierror.Respond(c, apierror.NotFound("Supplier not found"))
+			return
+		}
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		c.JSON(http.StatusOK, updated)
+	}
+}
+
+// DeleteSupplier godoc
+// @Summary Delete a supplier
+// @Description Suppliers with open purchase orders cannot be deleted.
+// @Tags Suppliers
+// @Security BearerAuth
+// @Param id path string true "Supplier ID"
+// @Success 204
+// @Failure 400,403,404,409 {object} apierror.Problem
+// @Router /suppliers/{id} [delete]
+func DeleteSupplier() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		objID, err := supplierID(c)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		open, err := PurchaseOrders.CountDocuments(ctx, bson.M{
+			"supplier_id": objID,
+			"status":      bson.M{"$in": bson.A{models.POStatusDraft, models.POStatusSubmitted, models.POStatusPartiallyReceived}},
+		})
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		if open > 0 {
+			apierror.Respond(c, apierror.Conflict("the supplier has open purchase orders"))
+			return
+		}
+		result, err := Suppliers.DeleteOne(ctx, bson.M{"_id": objID})
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		if result.DeletedCount == 0 {
+			apierror.Respond(c, apierror.NotFound("Supplier not found"))
+			return
+		}
+		logging.FromContext(ctx).Info("supplier deleted", "supplierId", objID.Hex())
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,358 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yashaswini7291/Inventory/apierror"
+	"github.com/yashaswini7291/Inventory/apikeys"
+	"github.com/yashaswini7291/Inventory/lockout"
+	"github.com/yashaswini7291/Inventory/logging"
+	"github.com/yashaswini7291/Inventory/models"
+	"github.com/yashaswini7291/Inventory/tokens"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+type updateUserRequest struct {
+	Role   *string `json:"role" validate:"omitempty,oneof=user manager admin"`
+	Status *string `json:"status" validate:"omitempty,oneof=active disabled"`
+}
+
+// UserPage is one page of the user list.
+type UserPage struct {
+	Items []models.UserResponse `json:"items"`
+	Page  int                   `json:"page"`
+	Limit int                   `json:"limit"`
+	Total int64                 `json:"total"`
+}
+
+func findUserByID(ctx context.Context, uid string) (*models.User, error) {
+	return findUser(ctx, bson.M{"userId": uid})
+}
+
+// findMember loads the user named by :id if they belong to the caller's
+// organization. Users of other organizations are reported as not found.
+func findMember(c *gin.Context, ctx context.Context) (*models.User, error) {
+	filter := orgMembers(c.GetString("orgId"))
+	filter["userId"] = c.Param("id")
+	return findUser(ctx, filter)
+}
+
+func findUser(ctx context.Context, filter bson.M) (*models.User, error) {
+	var user models.User
+	err := UserCollection.FindOne(ctx, filter).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, apierror.NotFound("No User Found")
+	}
+	if err != nil {
+		return nil, apierror.Internal(err)
+	}
+	return &user, nil
+}
+
+// pagination reads ?page= and ?limit=, clamping them to sane values.
+func pagination(c *gin.Context) (page int, limit int) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err = strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageSize)))
+	if err != nil || limit < 1 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	return page, limit
+}
+
+// targetUser loads the user named by :id and refuses changes to the
+// caller's own account, so an admin cannot lock themselves out.
+func targetUser(c *gin.Context, ctx context.Context) (*models.User, error) {
+	if c.Param("id") == c.GetString("uid") {
+		return nil, apierror.Forbidden("administrators cannot change their own account here")
+	}
+	return findMember(c, ctx)
+}
+
+// setUserFields applies set to the user and returns the updated document.
+func setUserFields(ctx context.Context, uid string, set bson.M) (*models.User, error) {
+	set["updatedTime"] = time.Now().UTC()
+	var user models.User
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := UserCollection.FindOneAndUpdate(ctx, bson.M{"userId": uid}, bson.M{"$set": set}, opts).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, apierror.NotFound("No User Found")
+	}
+	if err != nil {
+		return nil, apierror.Internal(err)
+	}
+	return &user, nil
+}
+
+// GetMe godoc
+// @Summary Get the current user
+// @Tags Me
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Produce json
+// @Success 200 {object} models.UserResponse
+// @Failure 401,404 {object} apierror.Problem
+// @Router /me [get]
+func GetMe() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		user, err := findUserByID(ctx, c.GetString("uid"))
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		if *user.UserName != c.GetString("userName") {
+			apierror.Respond(c, apierror.NotFound("No User Found"))
+			return
+		}
+		c.JSON(http.StatusOK, user.Response())
+	}
+}
+
+// ListUsers godoc
+// @Summary List users
+// @Description Only members of the administrator's organization are listed.
+// @Tags Users
+// @Security BearerAuth
+// @Produce json
+// @Param page query int false "Page number, from 1"
+// @Param limit query int false "Page size, at most 100"
+// @Param role query string false "Only users with this role"
+// @Param status query string false "Only users with this status"
+// @Success 200 {object} UserPage
+// @Failure 403 {object} apierror.Problem
+// @Router /users [get]
+func ListUsers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+
+		page, limit := pagination(c)
+		filter := orgMembers(c.GetString("orgId"))
+		if role := c.Query("role"); role != "" {
+			filter["role"] = role
+		}
+		if status := c.Query("status"); status != "" {
+			filter["status"] = status
+		}
+
+		total, err := UserCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		opts := options.Find().
+			SetSort(bson.D{{Key: "createdTime", Value: 1}, {Key: "_id", Value: 1}}).
+			SetSkip(int64((page - 1) * limit)).
+			SetLimit(int64(limit))
+		cursor, err := UserCollection.Find(ctx, filter, opts)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		defer cursor.Close(ctx)
+
+		var users []models.User
+		if err := cursor.All(ctx, &users); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		items := make([]models.UserResponse, 0, len(users))
+		for i := range users {
+			items = append(items, users[i].Response())
+		}
+		c.JSON(http.StatusOK, UserPage{Items: items, Page: page, Limit: limit, Total: total})
+	}
+}
+
+// GetUser godoc
+// @Summary Get a user
+// @Tags Users
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} models.UserResponse
+// @Failure 403,404 {object} apierror.Problem
+// @Router /users/{id} [get]
+func GetUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		user, err := findMember(c, ctx)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, user.Response())
+	}
+}
+
+// UpdateUser godoc
+// @Summary Change a user's role or status
+// @Description Disabling a user signs them out everywhere.
+// @Tags Users
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param body body updateUserRequest true "New role and/or status"
+// @Success 200 {object} models.UserResponse
+// @Failure 400,403,404,422 {object} apierror.Problem
+// @Router /users/{id} [patch]
+func UpdateUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		var req updateUserRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid request payload"))
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			apierror.Respond(c, apierror.FromValidator(err))
+			return
+		}
+		if req.Role == nil && req.Status == nil {
+			apierror.Respond(c, apierror.BadRequest("role or status is required"))
+			return
+		}
+		target, err := targetUser(c, ctx)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+
+		set := bson.M{}
+		if req.Role != nil {
+			set["role"] = *req.Role
+		}
+		if req.Status != nil {
+			set["status"] = *req.Status
+		}
+		user, err := setUserFields(ctx, target.UserId, set)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		if user.Status == models.StatusDisabled {
+			if err := tokens.RevokeUserTokens(ctx, user.UserId); err != nil {
+				apierror.Respond(c, apierror.Internal(err))
+				return
+			}
+		}
+		logging.FromContext(ctx).Info("user updated", "userId", user.UserId, "role", user.Role, "status", user.Status)
+		c.JSON(http.StatusOK, user.Response())
+	}
+}
+
+func setUserStatus(status string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		target, err := targetUser(c, ctx)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		user, err := setUserFields(ctx, target.UserId, bson.M{"status": status})
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		if status == models.StatusDisabled {
+			if err := tokens.RevokeUserTokens(ctx, user.UserId); err != nil {
+				apierror.Respond(c, apierror.Internal(err))
+				return
+			}
+		}
+		logging.FromContext(ctx).Info("user status changed", "userId", user.UserId, "status", status)
+		c.JSON(http.StatusOK, user.Response())
+	}
+}
+
+// DisableUser godoc
+// @Summary Disable a user
+// @Description The user is signed out everywhere and their API keys stop working until re-enabled.
+// @Tags Users
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} models.UserResponse
+// @Failure 403,404 {object} apierror.Problem
+// @Router /users/{id}/disable [post]
+func DisableUser() gin.HandlerFunc {
+	return setUserStatus(models.StatusDisabled)
+}
+
+// EnableUser godoc
+// @Summary Enable a disabled user
+// @Tags Users
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} models.UserResponse
+// @Failure 403,404 {object} apierror.Problem
+// @Router /users/{id}/enable [post]
+func EnableUser() gin.HandlerFunc {
+	return setUserStatus(models.StatusActive)
+}
+
+// DeleteUser godoc
+// @Summary Delete a user
+// @Description Removes the user, revokes their API keys and clears their login attempts.
+// @Tags Users
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 204
+// @Failure 403,404 {object} apierror.Problem
+// @Router /users/{id} [delete]
+func DeleteUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		target, err := targetUser(c, ctx)
+		if err != nil {
+			apierror.Respond(c, err)
+			return
+		}
+		if _, err := UserCollection.DeleteOne(ctx, bson.M{"userId": target.UserId}); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		_, err = apikeys.APIKeyData.UpdateMany(ctx,
+			bson.M{"userId": target.UserId, "revoked_at": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"revoked_at": time.Now().UTC()}})
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		if err := lockout.Reset(ctx, lockout.UserKey(*target.UserName)); err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		logging.FromContext(ctx).Info("user deleted", "userId", target.UserId)
+		c.Status(http.StatusNoContent)
+	}
+}
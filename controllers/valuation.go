@@ -0,0 +1,242 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yashaswini7291/Inventory/apierror"
+	"github.com/yashaswini7291/Inventory/models"
+	"github.com/yashaswini7291/Inventory/stock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type productCostingRequest struct {
+	// CostMethod is fifo, average or standard. Empty uses the
+	// organization's method.
+	CostMethod   string   `json:"cost_method" validate:"omitempty,oneof=fifo average standard"`
+	StandardCost *float64 `json:"standard_cost" validate:"omitempty,min=0"`
+}
+
+// ValuationReport is the value of the stock on hand at a point in time.
+type ValuationReport struct {
+	AsOf       time.Time          `json:"as_of"`
+	OnHand     int                `json:"on_hand"`
+	Value      float64            `json:"value"`
+	Products   []ProductValuation `json:"products"`
+	ByType     []GroupValuation   `json:"by_type"`
+	ByLocation []GroupValuation   `json:"by_location"`
+}
+
+// ProductValuation is the value of one product's stock, in total and by
+// location.
+type ProductValuation struct {
+	ProductId  primitive.ObjectID `json:"product_id"`
+	SKU        string             `json:"sku"`
+	Name       string             `json:"name"`
+	Type       string             `json:"type"`
+	CostMethod string             `json:"cost_method"`
+	OnHand     int                `json:"on_hand"`
+	Value      float64            `json:"value"`
+	Locations  []GroupValuation   `json:"locations"`
+}
+
+// GroupValuation is the stock and its value of one type or location.
+type GroupValuation struct {
+	Name   string  `json:"name"`
+	OnHand int     `json:"on_hand"`
+	Value  float64 `json:"value"`
+}
+
+// parseAsOf reads a point in time given as RFC 3339 or as a date. A date
+// means the end of that day, UTC.
+func parseAsOf(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC(), nil
+	}
+	day, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return time.Time{}, errors.New("use a date such as 2024-06-30 or an RFC 3339 time")
+	}
+	return day.Add(24*time.Hour - time.Millisecond), nil
+}
+
+// groupValuations turns totals by name into a list sorted by name.
+func groupValuations(groups map[string]*GroupValuation) []GroupValuation {
+	list := make([]GroupValuation, 0, len(groups))
+	for _, group := range groups {
+		group.Value = roundMoney(group.Value)
+		list = append(list, *group)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// SetProductCosting godoc
+// @Summary Set a product's cost method and standard cost
+// @Description An empty cost_method uses the organization's method. A standard_cost left out keeps its value. Changes apply to stock moved afterwards.
+// @Tags Valuation
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param body body productCostingRequest true "Costing"
+// @Success 200 {object} models.Product
+// @Failure 400,404,422 {object} apierror.Problem
+// @Router /products/{id}/costing [put]
+func SetProductCosting() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid product ID"))
+			return
+		}
+		var req productCostingRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Respond(c, apierror.BadRequest("Invalid request payload"))
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			apierror.Respond(c, apierror.FromValidator(err))
+			return
+		}
+
+		update := bson.M{}
+		if req.CostMethod == "" {
+			update["$unset"] = bson.M{"cost_method": ""}
+		} else {
+			update["$set"] = bson.M{"cost_method": req.CostMethod}
+		}
+		if req.StandardCost != nil {
+			if update["$set"] == nil {
+				update["$set"] = bson.M{}
+			}
+			update["$set"].(bson.M)["standard_cost"] = *req.StandardCost
+		}
+
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		var product models.Product
+		err = Products.FindOneAndUpdate(ctx, bson.M{"_id": objID}, update, opts).Decode(&product)
+		if err == mongo.ErrNoDocuments {
+			apierror.Respond(c, apierror.NotFound("Product not found"))
+			return
+		}
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		c.JSON(http.StatusOK, product)
+	}
+}
+
+// GetValuation godoc
+// @Summary Get the value of the stock on hand
+// @Description Stock and its value by product, product type and location, from the stock ledger up to as_of. Every movement is valued when it is posted, by the cost method the product had then.
+// @Tags Valuation
+// @Security BearerAuth
+// @Produce json
+// @Param as_of query string false "Date (end of day, UTC) or RFC 3339 time, default now"
+// @Success 200 {object} ValuationReport
+// @Failure 400,403 {object} apierror.Problem
+// @Router /reports/valuation [get]
+func GetValuation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(c.Request.Context(), 60*time.Second)
+		defer cancel()
+
+		asOf := time.Now().UTC()
+		if s := c.Query("as_of"); s != "" {
+			var err error
+			if asOf, err = parseAsOf(s); err != nil {
+				apierror.Respond(c, apierror.BadRequest("Invalid as_of: "+err.Error()))
+				return
+			}
+		}
+
+		balances, err := stock.Balances(ctx, asOf)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		productsByID, err := productsOf(ctx, balances)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+		orgMethod, err := stock.OrganizationCostMethod(ctx)
+		if err != nil {
+			apierror.Respond(c, apierror.Internal(err))
+			return
+		}
+
+		report := ValuationReport{AsOf: asOf}
+		valuations := make(map[primitive.ObjectID]*ProductValuation)
+		byType := make(map[string]*GroupValuation)
+		byLocation := make(map[string]*GroupValuation)
+		for _, b := range balances {
+			valuation, ok := valuations[b.ProductId]
+			if !ok {
+				product := productsByID[b.ProductId]
+				method := product.CostMethod
+				if method == "" {
+					method = orgMethod
+				}
+				valuation = &ProductValuation{
+					ProductId:  b.ProductId,
+					SKU:        product.SKU,
+					Name:       product.Name,
+					Type:       product.Type,
+					CostMethod: method,
+				}
+				valuations[b.ProductId] = valuation
+			}
+			valuation.OnHand += b.OnHand
+			valuation.Value += b.Value
+			valuation.Locations = append(valuation.Locations, GroupValuation{Name: b.Location, OnHand: b.OnHand, Value: roundMoney(b.Value)})
+
+			if byType[valuation.Type] == nil {
+				byType[valuation.Type] = &GroupValuation{Name: valuation.Type}
+			}
+			byType[valuation.Type].OnHand += b.OnHand
+			byType[valuation.Type].Value += b.Value
+			if byLocation[b.Location] == nil {
+				byLocation[b.Location] = &GroupValuation{Name: b.Location}
+			}
+			byLocation[b.Location].OnHand += b.OnHand
+			byLocation[b.Location].Value += b.Value
+
+			report.OnHand += b.OnHand
+			report.Value += b.Value
+		}
+
+		report.Products = make([]ProductValuation, 0, len(valuations))
+		for _, valuation := range valuations {
+			valuation.Value = roundMoney(valuation.Value)
+			sort.Slice(valuation.Locations, func(i, j int) bool {
+				return valuation.Locations[i].Name < valuation.Locations[j].Name
+			})
+			report.Products = append(report.Products, *valuation)
+		}
+		sort.Slice(report.Products, func(i, j int) bool {
+			a, b := report.Products[i], report.Products[j]
+			if a.SKU != b.SKU {
+				return a.SKU < b.SKU
+			}
+			return a.ProductId.Hex() < b.ProductId.Hex()
+		})
+		report.ByType = groupValuations(byType)
+		report.ByLocation = groupValuations(byLocation)
+		report.Value = roundMoney(report.Value)
+		c.JSON(http.StatusOK, report)
+	}
+}
@@ -2,12 +2,13 @@ package database
 
 import (
 	"context"
-	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
+	"github.com/yashaswini7291/Inventory/logging"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
 )
 
 func DBSet() *mongo.Client {
@@ -16,21 +17,31 @@ func DBSet() *mongo.Client {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// Use mongo.Connect to create and connect a MongoDB client
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017"))
+	// Use mongo.Connect to create and connect a MongoDB client.
+	// The otelmongo monitor records a span for every command, parented to
+	// the span carried by the context passed to the collection call.
+	opts := options.Client().ApplyURI("mongodb://localhost:27017").SetMonitor(otelmongo.NewMonitor())
+	client, err := mongo.Connect(ctx, opts)
 	if err != nil {
-		log.Fatalf("Failed to connect to MongoDB: %v", err)
+		logging.Fatal("failed to connect to MongoDB", "error", err)
 	}
 
-	// Optionally, you can check the connection with a ping
-	if err := client.Ping(ctx, nil); err != nil {
-		log.Fatalf("Failed to ping MongoDB: %v", err)
-		return nil
-	}
-	fmt.Println("connection established sucessfully")
 	return client
 }
 
+// Ping checks that MongoDB is reachable. Connecting does not, so main calls
+// it once at startup; packages that only hold collections, and their
+// tests, can be loaded without a server.
+func Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := Client.Ping(ctx, nil); err != nil {
+		return err
+	}
+	slog.Info("connection established successfully")
+	return nil
+}
+
 var Client *mongo.Client = DBSet() // this client is same as the client used in main .go file
 func UserData(client *mongo.Client, collectionName string) *mongo.Collection {
 	var collection *mongo.Collection = client.Database("Inventory").Collection(collectionName)
@@ -41,3 +52,23 @@ func ProductData(client *mongo.Client, collectionName string) *mongo.Collection
 	var productCollection *mongo.Collection = client.Database("Inventory").Collection(collectionName)
 	return productCollection
 }
+
+// OpenCollection returns a collection in the Inventory database.
+func OpenCollection(client *mongo.Client, collectionName string) *mongo.Collection {
+	return client.Database("Inventory").Collection(collectionName)
+}
+
+// Transaction runs fn in a transaction on Client. The driver retries fn
+// and the commit on transient errors, so fn must be safe to run again.
+// An error returned by fn aborts the transaction and is returned as is.
+func Transaction(ctx context.Context, fn func(sc mongo.SessionContext) error) error {
+	session, err := Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
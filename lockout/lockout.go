@@ -0,0 +1,148 @@
+package lockout
+
+import (
+	"context"
+	"time"
+
+	"github.com/yashaswini7291/Inventory/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Policy controls how failed logins slow down and lock out further
+// attempts. Counters are kept per username and per client IP.
+type Policy struct {
+	// DelayAfter failures in a row, each further attempt has to wait twice
+	// as long as the previous one, up to MaxDelay.
+	DelayAfter int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	// UserLockAfter failures lock the username, IPLockAfter failures lock
+	// the client IP, both for LockDuration.
+	UserLockAfter int
+	IPLockAfter   int
+	LockDuration  time.Duration
+	// Window is how long a counter is kept after the last failure.
+	Window time.Duration
+}
+
+var DefaultPolicy = Policy{
+	DelayAfter:    3,
+	BaseDelay:     time.Second,
+	MaxDelay:      30 * time.Second,
+	UserLockAfter: 10,
+	IPLockAfter:   50,
+	LockDuration:  15 * time.Minute,
+	Window:        15 * time.Minute,
+}
+
+// attempt is stored in the LoginAttempts collection, one document per key.
+type attempt struct {
+	Key         string     `bson:"_id"`
+	Failures    int        `bson:"failures"`
+	LastFailure time.Time  `bson:"last_failure"`
+	LockedUntil *time.Time `bson:"locked_until,omitempty"`
+}
+
+var AttemptData *mongo.Collection = database.OpenCollection(database.Client, "LoginAttempts")
+
+func UserKey(userName string) string {
+	return "user:" + userName
+}
+
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+func isIPKey(key string) bool {
+	return len(key) > 3 && key[:3] == "ip:"
+}
+
+// Check returns how long the caller must wait before another attempt is
+// allowed for any of keys. Zero means the attempt may proceed.
+func Check(ctx context.Context, p Policy, keys ...string) (time.Duration, error) {
+	cursor, err := AttemptData.Find(ctx, bson.M{"_id": bson.M{"$in": keys}})
+	if err != nil {
+		return 0, err
+	}
+	var attempts []attempt
+	if err := cursor.All(ctx, &attempts); err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+	var wait time.Duration
+	for _, a := range attempts {
+		if a.LockedUntil != nil && a.LockedUntil.After(now) {
+			wait = max(wait, a.LockedUntil.Sub(now))
+		}
+		if now.Sub(a.LastFailure) > p.Window {
+			continue
+		}
+		if next := a.LastFailure.Add(p.delay(a.Failures)); next.After(now) {
+			wait = max(wait, next.Sub(now))
+		}
+	}
+	return wait, nil
+}
+
+func (p Policy) delay(failures int) time.Duration {
+	if failures < p.DelayAfter {
+		return 0
+	}
+	d := p.BaseDelay << (failures - p.DelayAfter)
+	if d <= 0 || d > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return d
+}
+
+// RecordFailure counts a failed attempt against every key and locks the
+// keys that reached their limit. It reports whether any key is now locked.
+func RecordFailure(ctx context.Context, p Policy, keys ...string) (bool, error) {
+	now := time.Now().UTC()
+	windowStart := now.Add(-p.Window)
+	locked := false
+	for _, key := range keys {
+		lockAfter := p.UserLockAfter
+		if isIPKey(key) {
+			lockAfter = p.IPLockAfter
+		}
+		// The counter restarts when the previous failure is outside the
+		// window, so old mistakes do not count forever.
+		update := mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{
+				"failures": bson.M{"$cond": bson.A{
+					bson.M{"$lt": bson.A{"$last_failure", windowStart}},
+					1,
+					bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$failures", 0}}, 1}},
+				}},
+				"last_failure": now,
+			}}},
+			{{Key: "$set", Value: bson.M{
+				"locked_until": bson.M{"$cond": bson.A{
+					bson.M{"$gte": bson.A{"$failures", lockAfter}},
+					now.Add(p.LockDuration),
+					"$locked_until",
+				}},
+			}}},
+		}
+		opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+		var a attempt
+		if err := AttemptData.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&a); err != nil {
+			return locked, err
+		}
+		if a.LockedUntil != nil && a.LockedUntil.After(now) {
+			locked = true
+		}
+	}
+	return locked, nil
+}
+
+// Reset clears the counter for key, after a successful login or when an
+// administrator unlocks an account.
+func Reset(ctx context.Context, key string) error {
+	_, err := AttemptData.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
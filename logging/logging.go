@@ -0,0 +1,95 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type contextKey struct{}
+
+// redactedKeys lists attribute keys whose values must never reach the logs.
+// Keys are compared case-insensitively.
+var redactedKeys = map[string]bool{
+	"password":      true,
+	"oldpassword":   true,
+	"old_password":  true,
+	"newpassword":   true,
+	"new_password":  true,
+	"token":         true,
+	"access_token":  true,
+	"refreshtoken":  true,
+	"refresh_token": true,
+	"authorization": true,
+	"secret":        true,
+}
+
+// The default logger is configured when the package is initialised so that
+// packages which connect to Mongo during their own initialisation already
+// log in the configured format.
+func init() {
+	Setup()
+}
+
+// Setup installs a JSON slog logger on stdout as the default logger. The
+// minimum level is read from LOG_LEVEL (debug, info, warn or error) and
+// defaults to info.
+func Setup() {
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level:       parseLevel(os.Getenv("LOG_LEVEL")),
+		ReplaceAttr: redact,
+	})
+	slog.SetDefault(slog.New(handler))
+}
+
+func parseLevel(s string) slog.Level {
+	switch strings.ToLower(s) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if redactedKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, "[REDACTED]")
+	}
+	return a
+}
+
+// WithContext returns a copy of ctx carrying logger.
+func WithContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the request-scoped logger stored in ctx, or the
+// default logger annotated with the trace ID of ctx.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return WithTrace(ctx, slog.Default())
+}
+
+// WithTrace adds the trace ID of the span in ctx, if any, so log lines can
+// be matched with traces.
+func WithTrace(ctx context.Context, logger *slog.Logger) *slog.Logger {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		return logger.With("trace_id", sc.TraceID().String())
+	}
+	return logger
+}
+
+// Fatal logs msg at error level and exits the process.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
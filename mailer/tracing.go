@@ -0,0 +1,36 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Traced wraps m so that every message is sent in a span of the caller's
+// trace. The span names the mailer but not the recipient.
+func Traced(m Mailer) Mailer {
+	return tracedMailer{next: m, kind: fmt.Sprintf("%T", m)}
+}
+
+type tracedMailer struct {
+	next Mailer
+	kind string
+}
+
+func (t tracedMailer) Send(ctx context.Context, msg Message) error {
+	ctx, span := otel.Tracer("github.com/yashaswini7291/Inventory/mailer").Start(ctx, "mailer.Send",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("mailer.type", t.kind)))
+	defer span.End()
+
+	err := t.next.Send(ctx, msg)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
@@ -1,13 +1,31 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
+	"github.com/yashaswini7291/Inventory/controllers"
+	"github.com/yashaswini7291/Inventory/database"
+	"github.com/yashaswini7291/Inventory/logging"
+	"github.com/yashaswini7291/Inventory/mailer"
+	"github.com/yashaswini7291/Inventory/middleware"
+	"github.com/yashaswini7291/Inventory/passwordpolicy"
+	"github.com/yashaswini7291/Inventory/replenishment"
 	"github.com/yashaswini7291/Inventory/routes"
+	"github.com/yashaswini7291/Inventory/stock"
+	"github.com/yashaswini7291/Inventory/tokens"
+	"github.com/yashaswini7291/Inventory/tracing"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 
 	_ "github.com/yashaswini7291/Inventory/docs"
 )
@@ -28,27 +46,139 @@ import (
 // @name Authorization
 // @description Type "Bearer" followed by a space and JWT token.
 
+// @securityDefinitions.apikey APIKeyAuth
+// @in header
+// @name X-API-Key
+// @description API key issued through POST /api-keys.
+
+// trustedProxies reads TRUSTED_PROXIES, a comma separated list of IPs and
+// CIDRs. Without it no proxy is trusted and the client IP is the remote
+// address of the connection.
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
 func main() {
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
 
-	log.Println("Server running on port", port)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Init(ctx)
+	if err != nil {
+		logging.Fatal("failed to initialise tracing", "error", err)
+	}
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			slog.Error("failed to flush traces", "error", err)
+		}
+	}()
+
+	if err := database.Ping(ctx); err != nil {
+		logging.Fatal("failed to ping MongoDB", "error", err)
+	}
+	if err := stock.EnsureIndexes(ctx); err != nil {
+		logging.Fatal("failed to create stock indexes", "error", err)
+	}
+
+	if err := tokens.InitKeys(ctx); err != nil {
+		logging.Fatal("failed to load signing keys", "error", err)
+	}
+	go tokens.Keys.Run(ctx, time.Minute)
+	if n, err := tokens.RemoveStrayTokenDocuments(ctx); err != nil {
+		slog.Error("removing stray token documents failed", "error", err)
+	} else if n > 0 {
+		slog.Info("removed stray token documents", "count", n)
+	}
+
+	mail, err := mailer.FromEnv()
+	if err != nil {
+		logging.Fatal("failed to configure mailer", "error", err)
+	}
+	controllers.Mailer = mailer.Traced(mail)
+
+	policy, err := passwordpolicy.FromEnv()
+	if err != nil {
+		logging.Fatal("failed to load password policy", "error", err)
+	}
+	controllers.PasswordPolicy = policy
+
+	go stock.RunSweeper(ctx, 30*time.Second)
+	go replenishment.Run(ctx, replenishment.RefreshInterval)
+	go stock.RunSnapshots(ctx, stock.SnapshotInterval)
+	// Post opens products as they move; this brings in the rest before
+	// the first request so reports see all stock.
+	if n, err := stock.OpenLedger(ctx); err != nil {
+		logging.Fatal("opening the stock ledger failed", "error", err)
+	} else if n > 0 {
+		slog.Info("opened stock ledger for existing products", "count", n)
+	}
+
+	slog.Info("server running", "port", port)
 
 	router := gin.New()
-	router.Use(gin.Logger())
+	// Client IPs key the login lockout and the per-IP rate limits, so
+	// X-Forwarded-For is only believed when it comes from a proxy we run.
+	if err := router.SetTrustedProxies(trustedProxies()); err != nil {
+		logging.Fatal("invalid TRUSTED_PROXIES", "error", err)
+	}
+	router.Use(otelgin.Middleware(tracing.ServiceName))
+	router.Use(middleware.RequestLogger())
+	router.Use(middleware.Recovery())
 
 	// Public routes
 	routes.UserRoutes(router)
+	routes.WellKnownRoutes(router)
 
 	// Swagger docs
 	//router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
 	// Protected routes
 	routes.ProductRoutes(router)
-	
+	routes.APIKeyRoutes(router)
+	routes.MFARoutes(router)
+	routes.MeRoutes(router)
+	routes.UserAdminRoutes(router)
+	routes.OrganizationRoutes(router)
+	routes.CartRoutes(router)
+	routes.OrderRoutes(router)
+	routes.ReturnRoutes(router)
+	routes.ReservationRoutes(router)
+	routes.SupplierRoutes(router)
+	routes.PurchaseOrderRoutes(router)
+	routes.ReplenishmentRoutes(router)
+	routes.StockRoutes(router)
+	routes.LotRoutes(router)
+	routes.SerialRoutes(router)
+	routes.CountRoutes(router)
+	routes.AdjustmentRoutes(router)
+	routes.ReportRoutes(router)
+
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
-	log.Fatal(router.Run(":" + port))
+	srv := &http.Server{Addr: ":" + port, Handler: router}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logging.Fatal("server failed", "error", err)
+		}
+	}()
+
+	<-ctx.Done()
+	slog.Info("shutting down server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("server forced to shutdown", "error", err)
+	}
 }
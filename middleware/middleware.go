@@ -1,40 +1,204 @@
 package middleware
 
 import (
-	"net/http"
+	"errors"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/yashaswini7291/Inventory/apierror"
+	"github.com/yashaswini7291/Inventory/apikeys"
+	"github.com/yashaswini7291/Inventory/logging"
+	"github.com/yashaswini7291/Inventory/models"
+	"github.com/yashaswini7291/Inventory/repository"
 	"github.com/yashaswini7291/Inventory/tokens"
 )
 
+const APIKeyHeader = "X-API-Key"
+
+// Authentication accepts either a Bearer JWT or an API key. API keys may
+// be sent in the X-API-Key header or as the Bearer credential. On success
+// it sets "userName", "uid", "orgId", "role" and "authMethod" on the
+// context, plus "apiKeyId" and "scopes" for API keys, which act with their
+// owner's current role. The
+// organization is also stored in the request context, which scopes every
+// repository call made while handling the request.
 func Authentication() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if key := c.GetHeader(APIKeyHeader); key != "" {
+			authenticateAPIKey(c, key)
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header not provided"})
-			c.Abort()
+			apierror.Respond(c, apierror.Unauthorized("Authorization header not provided"))
 			return
 		}
 
 		// Expected format: "Bearer <token>"
 		splitToken := strings.Split(authHeader, " ")
 		if len(splitToken) != 2 || strings.ToLower(splitToken[0]) != "bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header format must be Bearer {access_token}"})
-			c.Abort()
+			apierror.Respond(c, apierror.Unauthorized("Authorization header format must be Bearer {access_token}"))
 			return
 		}
 
 		ClientToken := splitToken[1]
+		if apikeys.LooksLikeKey(ClientToken) {
+			authenticateAPIKey(c, ClientToken)
+			return
+		}
+
 		claims, err := tokens.ValidateToken(ClientToken)
 		if err != "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": err})
-			c.Abort()
+			apierror.Respond(c, apierror.Unauthorized(err))
+			return
+		}
+		session, sessionErr := tokens.LoadSession(c.Request.Context(), claims.Id)
+		if sessionErr != nil {
+			apierror.Respond(c, apierror.Internal(sessionErr))
+			return
+		}
+		if session == nil || session.Revokes(claims) {
+			apierror.Respond(c, apierror.Unauthorized("token has been revoked"))
+			return
+		}
+		if session.Disabled() {
+			apierror.Respond(c, apierror.Unauthorized("account is disabled"))
+			return
+		}
+		// A token minted for another organization must not outlive a
+		// change of membership.
+		if claims.OrgId != session.OrgId {
+			apierror.Respond(c, apierror.Unauthorized("organization membership has changed, please log in again"))
 			return
 		}
 
 		c.Set("userName", claims.UserName)
 		c.Set("uid", claims.Id)
+		c.Set("role", session.Role)
+		c.Set("authMethod", "jwt")
+		setOrg(c, session.OrgId)
+		setLogger(c, "userName", claims.UserName, "orgId", session.OrgId)
 		c.Next()
 	}
 }
+
+func authenticateAPIKey(c *gin.Context, key string) {
+	apiKey, err := apikeys.Authenticate(c.Request.Context(), key)
+	if errors.Is(err, apikeys.ErrInvalidKey) || errors.Is(err, apikeys.ErrExpiredKey) || errors.Is(err, apikeys.ErrRevokedKey) {
+		apierror.Respond(c, apierror.Unauthorized(err.Error()))
+		return
+	}
+	if err != nil {
+		apierror.Respond(c, apierror.Internal(err))
+		return
+	}
+	owner, err := tokens.LoadSession(c.Request.Context(), apiKey.UserId)
+	if err != nil {
+		apierror.Respond(c, apierror.Internal(err))
+		return
+	}
+	if owner == nil || owner.Disabled() {
+		apierror.Respond(c, apierror.Unauthorized("API key owner is disabled"))
+		return
+	}
+
+	c.Set("userName", apiKey.UserName)
+	c.Set("uid", apiKey.UserId)
+	c.Set("role", owner.Role)
+	c.Set("authMethod", "api_key")
+	c.Set("apiKeyId", apiKey.ID.Hex())
+	c.Set("scopes", apiKey.Scopes)
+	setOrg(c, owner.OrgId)
+	setLogger(c, "userName", apiKey.UserName, "orgId", owner.OrgId, "apiKeyPrefix", apiKey.Prefix)
+	c.Next()
+}
+
+// setLogger adds args to the request logger for the rest of the request.
+func setLogger(c *gin.Context, args ...any) {
+	ctx := c.Request.Context()
+	logger := logging.FromContext(ctx).With(args...)
+	c.Request = c.Request.WithContext(logging.WithContext(ctx, logger))
+}
+
+// setOrg records the caller's organization for handlers and repositories.
+func setOrg(c *gin.Context, orgID string) {
+	c.Set("orgId", orgID)
+	if orgID != "" {
+		c.Request = c.Request.WithContext(repository.WithOrg(c.Request.Context(), orgID))
+	}
+}
+
+// RequireOrg rejects callers that do not belong to an organization, for
+// endpoints that only work on tenant data.
+func RequireOrg() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("orgId") == "" {
+			apierror.Respond(c, apierror.Forbidden("you are not a member of an organization"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireScope rejects API key requests whose key was not granted scope,
+// and keys with one of apikeys.ManagerScopes whose owner is no longer a
+// manager or admin. JWT sessions are not restricted.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("authMethod") != "api_key" {
+			c.Next()
+			return
+		}
+		if !apikeys.HasScope(c.GetStringSlice("scopes"), scope) {
+			apierror.Respond(c, apierror.Forbidden("API key is missing the "+scope+" scope"))
+			return
+		}
+		if role := c.GetString("role"); apikeys.ManagerScopes[scope] && role != models.RoleManager && role != models.RoleAdmin {
+			apierror.Respond(c, apierror.Forbidden("the "+scope+" scope needs a key of a manager or admin"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// SessionOnly rejects requests authenticated with an API key, for
+// endpoints such as key management that need an interactive login.
+func SessionOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("authMethod") == "api_key" {
+			apierror.Respond(c, apierror.Forbidden("this endpoint requires a user login"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireUserRole lets through users with one of roles, whether logged in
+// or calling with an API key of theirs. It is for endpoints that also take
+// a scope, with RequireScope checking the key.
+func RequireUserRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("role")
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+		apierror.Respond(c, apierror.Forbidden("this endpoint requires the "+strings.Join(roles, " or ")+" role"))
+	}
+}
+
+// RequireRole only lets through users logged in with one of roles. API
+// keys are refused whatever their owner's role.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	requireUserRole := RequireUserRole(roles...)
+	return func(c *gin.Context) {
+		if c.GetString("authMethod") == "api_key" {
+			apierror.Respond(c, apierror.Forbidden("this endpoint requires a user login"))
+			return
+		}
+		requireUserRole(c)
+	}
+}
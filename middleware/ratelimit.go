@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yashaswini7291/Inventory/apierror"
+	"github.com/yashaswini7291/Inventory/ratelimit"
+)
+
+// RateLimitKey picks the identity a request is counted against.
+type RateLimitKey func(c *gin.Context) string
+
+// ByIP counts requests per client IP. Use it for unauthenticated routes.
+func ByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// ByUser counts requests per authenticated user, falling back to the
+// client IP. It must run after Authentication.
+func ByUser(c *gin.Context) string {
+	if uid := c.GetString("uid"); uid != "" {
+		return "user:" + uid
+	}
+	return ByIP(c)
+}
+
+// ByAPIKey counts requests made with an API key against that key and
+// everything else per user, so a terminal cannot use up its owner's quota.
+func ByAPIKey(c *gin.Context) string {
+	if id := c.GetString("apiKeyId"); id != "" {
+		return "key:" + id
+	}
+	return ByUser(c)
+}
+
+// RateLimit enforces limit for each key in the named quota and reports the
+// state of the bucket in X-RateLimit-* headers. Rejected requests get 429
+// with Retry-After. If the store fails the request is let through.
+func RateLimit(store ratelimit.Store, name string, limit ratelimit.Limit, key RateLimitKey) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, err := store.Take(c.Request.Context(), name+":"+key(c), limit)
+		if err != nil {
+			_ = c.Error(err)
+			c.Next()
+			return
+		}
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.Reset.Seconds()))))
+		if !result.Allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+			apierror.Respond(c, apierror.TooManyRequests("rate limit exceeded"))
+			return
+		}
+		c.Next()
+	}
+}
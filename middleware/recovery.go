@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yashaswini7291/Inventory/apierror"
+	"github.com/yashaswini7291/Inventory/logging"
+)
+
+// Recovery turns a panic in a later handler into a logged 500 problem
+// response instead of dropping the connection. It must run after
+// RequestLogger so the log line carries the request ID.
+func Recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			logging.FromContext(c.Request.Context()).Error("panic recovered",
+				"panic", fmt.Sprint(rec), "stack", string(debug.Stack()))
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+			apierror.Respond(c, apierror.Internal(fmt.Errorf("panic: %v", rec)))
+		}()
+		c.Next()
+	}
+}
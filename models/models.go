@@ -1,14 +1,30 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Roles a user can hold. Users without a role are treated as RoleUser.
+const (
+	RoleUser    = "user"
+	RoleManager = "manager"
+	RoleAdmin   = "admin"
+)
+
+// Account statuses. Disabled users cannot log in and their tokens and API
+// keys stop working.
+const (
+	StatusActive   = "active"
+	StatusDisabled = "disabled"
+)
+
 type User struct {
 	ID           primitive.ObjectID `json:"_id" bson:"_id"`
 	UserName     *string            `json:"username" bson:"username" validate:"required,min=2,max=30"`
+	Email        *string            `json:"email,omitempty" bson:"email,omitempty" validate:"omitempty,email"`
 	Password     *string            `json:"password" bson:"password" validate:"required"`
 	Token        *string            `json:"access_token" bson:"access_token"`
 	RefreshToken *string            `json:"refreshToken" bson:"refreshToken"`
@@ -16,17 +32,123 @@ type User struct {
 	UpdatedTime  time.Time          `json:"updatedTime" bson:"updatedTime"`
 	UserId       string             `json:"userId" bson:"userId"`
 	UserCart     []ProductUser      `json:"usercart" bson:"usercart"`
+	Role         string             `json:"role" bson:"role"`
+	Status       string             `json:"status" bson:"status"`
+	OrgId        string             `json:"org_id,omitempty" bson:"org_id,omitempty"`
+
+	MFAEnabled       bool     `json:"mfa_enabled" bson:"mfa_enabled"`
+	MFASecret        string   `json:"-" bson:"mfa_secret,omitempty"`
+	MFAPendingSecret string   `json:"-" bson:"mfa_pending_secret,omitempty"`
+	MFALastStep      int64    `json:"-" bson:"mfa_last_step,omitempty"`
+	RecoveryCodes    []string `json:"-" bson:"recovery_codes,omitempty"`
+}
+
+// UserResponse is the public view of a User. Handlers return it instead of
+// User so password hashes, tokens and second-factor secrets never leave
+// the server.
+type UserResponse struct {
+	ID          primitive.ObjectID `json:"_id"`
+	UserId      string             `json:"userId"`
+	UserName    string             `json:"username"`
+	Email       string             `json:"email,omitempty"`
+	Role        string             `json:"role"`
+	Status      string             `json:"status"`
+	OrgId       string             `json:"org_id,omitempty"`
+	MFAEnabled  bool               `json:"mfa_enabled"`
+	CreatedTime time.Time          `json:"createdTime"`
+	UpdatedTime time.Time          `json:"updatedTime"`
+}
+
+func (u *User) Response() UserResponse {
+	r := UserResponse{
+		ID:          u.ID,
+		UserId:      u.UserId,
+		Role:        u.Role,
+		Status:      u.Status,
+		OrgId:       u.OrgId,
+		MFAEnabled:  u.MFAEnabled,
+		CreatedTime: u.CreatedTime,
+		UpdatedTime: u.UpdatedTime,
+	}
+	if u.UserName != nil {
+		r.UserName = *u.UserName
+	}
+	if u.Email != nil {
+		r.Email = *u.Email
+	}
+	if r.Role == "" {
+		r.Role = RoleUser
+	}
+	if r.Status == "" {
+		r.Status = StatusActive
+	}
+	return r
 }
 
 type Product struct {
 	ProductId   primitive.ObjectID `json:"_id" bson:"_id"`
+	OrgId       string             `json:"org_id" bson:"org_id"`
 	Name        string             `json:"name" bson:"name"`
 	Type        string             `json:"type" bson:"type"`
 	SKU         string             `json:"sku" bson:"sku"`
 	ImageURL    string             `json:"image_url" bson:"image_url"`
 	Description string             `json:"description" bson:"description"`
-	Quantity    int                `json:"quantity" bson:"quantity"`
-	Price       float64            `json:"price" bson:"price"`
+	// OnHand is the physical stock. It is stored as "quantity", the name
+	// the field had before reservations existed.
+	OnHand int `json:"on_hand" bson:"quantity"`
+	// Reserved is held by active reservations and cannot be sold.
+	Reserved int     `json:"reserved" bson:"reserved"`
+	Price    float64 `json:"price" bson:"price"`
+	// ReorderPoint is the stock level at which the product should be
+	// reordered, ReorderQty the usual quantity to order. A ReorderPoint of
+	// 0 leaves the product out of replenishment.
+	ReorderPoint        int                 `json:"reorder_point" bson:"reorder_point"`
+	ReorderQty          int                 `json:"reorder_qty" bson:"reorder_qty"`
+	PreferredSupplierId *primitive.ObjectID `json:"preferred_supplier_id,omitempty" bson:"preferred_supplier_id,omitempty"`
+	// TrackLots requires every stock movement of the product to name a
+	// lot. Lots are consumed first-expiry-first-out.
+	TrackLots bool `json:"track_lots" bson:"track_lots"`
+	// TrackSerials requires every stock movement of the product to list
+	// the serial numbers of the units it moves.
+	TrackSerials bool `json:"track_serials" bson:"track_serials"`
+	// CostMethod values the stock taken out of the product. Empty uses the
+	// organization's method.
+	CostMethod   string  `json:"cost_method,omitempty" bson:"cost_method,omitempty"`
+	StandardCost float64 `json:"standard_cost" bson:"standard_cost"`
+	// AverageCost is the moving average unit cost of the stock received,
+	// kept up to date by the stock ledger.
+	AverageCost float64 `json:"average_cost" bson:"average_cost"`
+	// LedgerOpened is set once the product's stock is in the stock ledger.
+	LedgerOpened bool `json:"-" bson:"ledger_opened,omitempty"`
+	// AdjustedTime is when a manual adjustment of the stock was last asked
+	// for. Writing it makes concurrent adjustments of the product conflict.
+	AdjustedTime *time.Time `json:"-" bson:"adjustedTime,omitempty"`
+}
+
+// Cost methods. Products without one use their organization's, and
+// organizations without one use CostFIFO.
+const (
+	CostFIFO     = "fifo"
+	CostAverage  = "average"
+	CostStandard = "standard"
+)
+
+// Available is the stock that can still be reserved or sold.
+func (p *Product) Available() int {
+	return p.OnHand - p.Reserved
+}
+
+// MarshalJSON adds the computed "available" field.
+func (p Product) MarshalJSON() ([]byte, error) {
+	type product Product
+	return json.Marshal(struct {
+		product
+		Available int `json:"available"`
+	}{product(p), p.Available()})
+}
+
+func (p *Product) SetOrgId(orgID string) {
+	p.OrgId = orgID
 }
 
 type ProductUser struct {
@@ -39,3 +161,614 @@ type ProductUser struct {
 	Quantity    int                `json:"quantity" bson:"quantity"`
 	Price       float64            `json:"price" bson:"price"`
 }
+
+type APIKey struct {
+	ID          primitive.ObjectID `json:"_id" bson:"_id"`
+	Name        string             `json:"name" bson:"name"`
+	Prefix      string             `json:"prefix" bson:"prefix"`
+	Hash        string             `json:"-" bson:"hash"`
+	UserId      string             `json:"userId" bson:"userId"`
+	UserName    string             `json:"username" bson:"username"`
+	Scopes      []string           `json:"scopes" bson:"scopes"`
+	ExpiresAt   *time.Time         `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	LastUsedAt  *time.Time         `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+	RevokedAt   *time.Time         `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+	CreatedTime time.Time          `json:"createdTime" bson:"createdTime"`
+}
+
+type PasswordReset struct {
+	ID          primitive.ObjectID `json:"_id" bson:"_id"`
+	UserId      string             `json:"userId" bson:"userId"`
+	TokenHash   string             `json:"-" bson:"token_hash"`
+	ExpiresAt   time.Time          `json:"expires_at" bson:"expires_at"`
+	UsedAt      *time.Time         `json:"used_at,omitempty" bson:"used_at,omitempty"`
+	CreatedTime time.Time          `json:"createdTime" bson:"createdTime"`
+}
+
+// Organization is a tenant, for example one franchise store. Users belong
+// to at most one organization and only see its data.
+type Organization struct {
+	ID          primitive.ObjectID `json:"_id" bson:"_id"`
+	OrgId       string             `json:"org_id" bson:"org_id"`
+	Name        string             `json:"name" bson:"name"`
+	CreatedBy   string             `json:"created_by" bson:"created_by"`
+	CreatedTime time.Time          `json:"createdTime" bson:"createdTime"`
+	// CostMethod is the cost method of products that do not set their own.
+	CostMethod string `json:"cost_method,omitempty" bson:"cost_method,omitempty"`
+	// Currency is the ISO 4217 code the stock is valued in. Empty uses the
+	// server's BASE_CURRENCY.
+	Currency string `json:"currency,omitempty" bson:"currency,omitempty"`
+}
+
+// Invitation lets the user with Email join an organization with Role.
+type Invitation struct {
+	ID          primitive.ObjectID `json:"_id" bson:"_id"`
+	OrgId       string             `json:"org_id" bson:"org_id"`
+	Email       string             `json:"email" bson:"email"`
+	Role        string             `json:"role" bson:"role"`
+	TokenHash   string             `json:"-" bson:"token_hash"`
+	InvitedBy   string             `json:"invited_by" bson:"invited_by"`
+	ExpiresAt   time.Time          `json:"expires_at" bson:"expires_at"`
+	AcceptedAt  *time.Time         `json:"accepted_at,omitempty" bson:"accepted_at,omitempty"`
+	AcceptedBy  string             `json:"accepted_by,omitempty" bson:"accepted_by,omitempty"`
+	CreatedTime time.Time          `json:"createdTime" bson:"createdTime"`
+}
+
+// Sales order statuses. Checkout creates a pending order that holds its
+// stock with reservations; confirming it after payment deducts the stock.
+const (
+	OrderStatusPending   = "pending"
+	OrderStatusPlaced    = "placed"
+	OrderStatusCancelled = "cancelled"
+	OrderStatusExpired   = "expired"
+)
+
+// SalesOrder is created from a cart at checkout. Lines keep the product
+// details and prices the cart had, so later product changes do not alter
+// the order.
+type SalesOrder struct {
+	ID            primitive.ObjectID `json:"_id" bson:"_id"`
+	OrgId         string             `json:"org_id" bson:"org_id"`
+	UserId        string             `json:"userId" bson:"userId"`
+	UserName      string             `json:"username" bson:"username"`
+	Lines         []SalesOrderLine   `json:"lines" bson:"lines"`
+	TotalQuantity int                `json:"total_quantity" bson:"total_quantity"`
+	Total         float64            `json:"total" bson:"total"`
+	Status        string             `json:"status" bson:"status"`
+	// ExpiresAt is when the stock held for a pending order is released.
+	ExpiresAt   *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	CreatedTime time.Time  `json:"createdTime" bson:"createdTime"`
+	UpdatedTime time.Time  `json:"updatedTime" bson:"updatedTime"`
+}
+
+func (o *SalesOrder) SetOrgId(orgID string) {
+	o.OrgId = orgID
+}
+
+type SalesOrderLine struct {
+	ProductId primitive.ObjectID `json:"product_id" bson:"product_id"`
+	SKU       string             `json:"sku" bson:"sku"`
+	Name      string             `json:"name" bson:"name"`
+	Quantity  int                `json:"quantity" bson:"quantity"`
+	UnitPrice float64            `json:"unit_price" bson:"unit_price"`
+	LineTotal float64            `json:"line_total" bson:"line_total"`
+	// ReservationId holds the line's stock while the order is pending.
+	ReservationId primitive.ObjectID `json:"reservation_id,omitempty" bson:"reservation_id,omitempty"`
+}
+
+// Reservation statuses. Only active reservations count towards
+// Product.Reserved.
+const (
+	ReservationActive    = "active"
+	ReservationCommitted = "committed"
+	ReservationReleased  = "released"
+	ReservationExpired   = "expired"
+)
+
+// Reservation holds stock of a product for Holder until ExpiresAt. It is
+// either committed, which deducts the stock, released or left to expire.
+type Reservation struct {
+	ID        primitive.ObjectID  `json:"_id" bson:"_id"`
+	OrgId     string              `json:"org_id" bson:"org_id"`
+	ProductId primitive.ObjectID  `json:"product_id" bson:"product_id"`
+	Quantity  int                 `json:"quantity" bson:"quantity"`
+	Holder    string              `json:"holder" bson:"holder"`
+	OrderId   *primitive.ObjectID `json:"order_id,omitempty" bson:"order_id,omitempty"`
+	Status    string              `json:"status" bson:"status"`
+	ExpiresAt time.Time           `json:"expires_at" bson:"expires_at"`
+	CreatedBy string              `json:"created_by" bson:"created_by"`
+
+	CreatedTime time.Time `json:"createdTime" bson:"createdTime"`
+	UpdatedTime time.Time `json:"updatedTime" bson:"updatedTime"`
+}
+
+func (r *Reservation) SetOrgId(orgID string) {
+	r.OrgId = orgID
+}
+
+// Supplier is a vendor that purchase orders are sent to.
+type Supplier struct {
+	ID           primitive.ObjectID `json:"_id" bson:"_id"`
+	OrgId        string             `json:"org_id" bson:"org_id"`
+	Name         string             `json:"name" bson:"name" validate:"required,max=200"`
+	ContactName  string             `json:"contact_name" bson:"contact_name" validate:"max=200"`
+	Email        string             `json:"email" bson:"email" validate:"omitempty,email"`
+	Phone        string             `json:"phone" bson:"phone" validate:"max=50"`
+	Address      string             `json:"address" bson:"address" validate:"max=500"`
+	LeadTimeDays int                `json:"lead_time_days" bson:"lead_time_days" validate:"min=0,max=365"`
+	// Currency is an ISO 4217 code such as "USD".
+	Currency     string    `json:"currency" bson:"currency" validate:"required,len=3,alpha"`
+	PaymentTerms string    `json:"payment_terms" bson:"payment_terms" validate:"max=200"`
+	Notes        string    `json:"notes" bson:"notes" validate:"max=2000"`
+	CreatedTime  time.Time `json:"createdTime" bson:"createdTime"`
+	UpdatedTime  time.Time `json:"updatedTime" bson:"updatedTime"`
+}
+
+func (s *Supplier) SetOrgId(orgID string) {
+	s.OrgId = orgID
+}
+
+// Purchase order statuses. Drafts can be edited; submitted orders are
+// received, in one or more deliveries, until every line is complete.
+const (
+	POStatusDraft             = "draft"
+	POStatusSubmitted         = "submitted"
+	POStatusPartiallyReceived = "partially_received"
+	POStatusReceived          = "received"
+	POStatusCancelled         = "cancelled"
+)
+
+// POSourceReplenishment marks purchase orders created from replenishment
+// suggestions.
+const POSourceReplenishment = "replenishment"
+
+type PurchaseOrder struct {
+	ID           primitive.ObjectID  `json:"_id" bson:"_id"`
+	OrgId        string              `json:"org_id" bson:"org_id"`
+	SupplierId   primitive.ObjectID  `json:"supplier_id" bson:"supplier_id"`
+	SupplierName string              `json:"supplier_name" bson:"supplier_name"`
+	Currency     string              `json:"currency" bson:"currency"`
+	Lines        []PurchaseOrderLine `json:"lines" bson:"lines"`
+	Total        float64             `json:"total" bson:"total"`
+	Status       string              `json:"status" bson:"status"`
+	ExpectedDate *time.Time          `json:"expected_date,omitempty" bson:"expected_date,omitempty"`
+	Notes        string              `json:"notes" bson:"notes"`
+	// Source is POSourceReplenishment for orders created from suggestions.
+	Source      string     `json:"source,omitempty" bson:"source,omitempty"`
+	CreatedBy   string     `json:"created_by" bson:"created_by"`
+	SubmittedAt *time.Time `json:"submitted_at,omitempty" bson:"submitted_at,omitempty"`
+	CreatedTime time.Time  `json:"createdTime" bson:"createdTime"`
+	UpdatedTime time.Time  `json:"updatedTime" bson:"updatedTime"`
+}
+
+func (o *PurchaseOrder) SetOrgId(orgID string) {
+	o.OrgId = orgID
+}
+
+// PurchaseOrderLine orders Quantity units of a product at UnitCost, in the
+// currency of the order. Lines are numbered from 1.
+type PurchaseOrderLine struct {
+	Line        int                `json:"line" bson:"line"`
+	ProductId   primitive.ObjectID `json:"product_id" bson:"product_id"`
+	SKU         string             `json:"sku" bson:"sku"`
+	Name        string             `json:"name" bson:"name"`
+	SupplierSKU string             `json:"supplier_sku" bson:"supplier_sku"`
+	Quantity    int                `json:"quantity" bson:"quantity"`
+	UnitCost    float64            `json:"unit_cost" bson:"unit_cost"`
+	LineTotal   float64            `json:"line_total" bson:"line_total"`
+	// Received counts the units accepted into stock so far. Rejected and
+	// damaged units are recorded but do not count towards the order.
+	Received int `json:"received" bson:"received"`
+	Rejected int `json:"rejected" bson:"rejected"`
+	Damaged  int `json:"damaged" bson:"damaged"`
+}
+
+// Outstanding is the number of units still expected.
+func (l *PurchaseOrderLine) Outstanding() int {
+	return max(l.Quantity-l.Received, 0)
+}
+
+// GoodsReceipt records one delivery against a purchase order.
+type GoodsReceipt struct {
+	ID              primitive.ObjectID `json:"_id" bson:"_id"`
+	OrgId           string             `json:"org_id" bson:"org_id"`
+	PurchaseOrderId primitive.ObjectID `json:"purchase_order_id" bson:"purchase_order_id"`
+	SupplierId      primitive.ObjectID `json:"supplier_id" bson:"supplier_id"`
+	Location        string             `json:"location" bson:"location"`
+	Currency        string             `json:"currency" bson:"currency"`
+	Lines           []ReceiptLine      `json:"lines" bson:"lines"`
+	// Closed is set when the receipt closed the order short.
+	Closed      bool      `json:"closed" bson:"closed"`
+	Notes       string    `json:"notes" bson:"notes"`
+	ReceivedBy  string    `json:"received_by" bson:"received_by"`
+	CreatedTime time.Time `json:"createdTime" bson:"createdTime"`
+}
+
+func (r *GoodsReceipt) SetOrgId(orgID string) {
+	r.OrgId = orgID
+}
+
+// ReceiptLine is what arrived for one purchase order line. Accepted units,
+// Received less Rejected and Damaged, go into stock at UnitCost.
+type ReceiptLine struct {
+	Line      int                `json:"line" bson:"line"`
+	ProductId primitive.ObjectID `json:"product_id" bson:"product_id"`
+	SKU       string             `json:"sku" bson:"sku"`
+	Received  int                `json:"received" bson:"received"`
+	Rejected  int                `json:"rejected" bson:"rejected"`
+	Damaged   int                `json:"damaged" bson:"damaged"`
+	Accepted  int                `json:"accepted" bson:"accepted"`
+	UnitCost  float64            `json:"unit_cost" bson:"unit_cost"`
+	Lot       string             `json:"lot,omitempty" bson:"lot,omitempty"`
+	Serials   []string           `json:"serials,omitempty" bson:"serials,omitempty"`
+}
+
+// Stock movement types.
+const (
+	MovementOpening    = "opening"
+	MovementReceipt    = "receipt"
+	MovementSale       = "sale"
+	MovementAdjustment = "adjustment"
+	// MovementReturn brings returned goods back into stock. Returns that
+	// are not restocked are taken out again at once as MovementScrap, a
+	// write-off, or MovementVendorReturn.
+	MovementReturn       = "return"
+	MovementScrap        = "scrap"
+	MovementVendorReturn = "vendor_return"
+)
+
+// StockMovement is one entry of the stock ledger. Quantity is positive for
+// stock coming in and negative for stock going out. Movements are never
+// changed; corrections are new movements.
+type StockMovement struct {
+	ID        primitive.ObjectID `json:"_id" bson:"_id"`
+	OrgId     string             `json:"org_id" bson:"org_id"`
+	ProductId primitive.ObjectID `json:"product_id" bson:"product_id"`
+	Location  string             `json:"location" bson:"location"`
+	Quantity  int                `json:"quantity" bson:"quantity"`
+	Type      string             `json:"type" bson:"type"`
+	// UnitCost is the cost per unit the stock came in at, or the cost of
+	// goods per unit of stock taken out.
+	UnitCost *float64 `json:"unit_cost,omitempty" bson:"unit_cost,omitempty"`
+	// Value is what the movement added to or, when negative, took from the
+	// value of the stock, by the product's cost method.
+	Value float64 `json:"value" bson:"value"`
+	// RefType and RefId name the document that caused the movement, for
+	// example "purchase_order" and its ID.
+	RefType string `json:"ref_type,omitempty" bson:"ref_type,omitempty"`
+	RefId   string `json:"ref_id,omitempty" bson:"ref_id,omitempty"`
+	// Lot is the lot number for products that track lots.
+	Lot string `json:"lot,omitempty" bson:"lot,omitempty"`
+	// Serials are the serial numbers moved, for products that track them.
+	Serials []string `json:"serials,omitempty" bson:"serials,omitempty"`
+	// Reason is the reason code of a manual adjustment.
+	Reason      string    `json:"reason,omitempty" bson:"reason,omitempty"`
+	Note        string    `json:"note,omitempty" bson:"note,omitempty"`
+	CreatedBy   string    `json:"created_by" bson:"created_by"`
+	CreatedTime time.Time `json:"createdTime" bson:"createdTime"`
+}
+
+func (m *StockMovement) SetOrgId(orgID string) {
+	m.OrgId = orgID
+}
+
+// StockBalance is the stock of a product at a location, and its value, at
+// some point in time.
+type StockBalance struct {
+	ProductId primitive.ObjectID `json:"product_id" bson:"product_id"`
+	Location  string             `json:"location" bson:"location"`
+	OnHand    int                `json:"on_hand" bson:"on_hand"`
+	Value     float64            `json:"value" bson:"value"`
+}
+
+// StockSnapshot holds every stock balance of an organization at the end of
+// Date, a day in UTC.
+type StockSnapshot struct {
+	ID          primitive.ObjectID `json:"_id" bson:"_id"`
+	OrgId       string             `json:"org_id" bson:"org_id"`
+	Date        string             `json:"date" bson:"date"`
+	AsOf        time.Time          `json:"as_of" bson:"as_of"`
+	Balances    []StockBalance     `json:"balances" bson:"balances"`
+	CreatedTime time.Time          `json:"createdTime" bson:"createdTime"`
+}
+
+// CostLayer is what is left of the stock one movement brought in, at the
+// cost it came in at. FIFO costing takes from the oldest layer first.
+type CostLayer struct {
+	ID          primitive.ObjectID `json:"_id" bson:"_id"`
+	OrgId       string             `json:"org_id" bson:"org_id"`
+	ProductId   primitive.ObjectID `json:"product_id" bson:"product_id"`
+	MovementId  primitive.ObjectID `json:"movement_id" bson:"movement_id"`
+	Quantity    int                `json:"quantity" bson:"quantity"`
+	Remaining   int                `json:"remaining" bson:"remaining"`
+	UnitCost    float64            `json:"unit_cost" bson:"unit_cost"`
+	CreatedTime time.Time          `json:"createdTime" bson:"createdTime"`
+}
+
+func (l *CostLayer) SetOrgId(orgID string) {
+	l.OrgId = orgID
+}
+
+// StockLevel is the on hand quantity of a product at one location. The
+// levels of a product add up to Product.OnHand.
+type StockLevel struct {
+	OrgId       string             `json:"org_id" bson:"org_id"`
+	ProductId   primitive.ObjectID `json:"product_id" bson:"product_id"`
+	Location    string             `json:"location" bson:"location"`
+	OnHand      int                `json:"on_hand" bson:"on_hand"`
+	UpdatedTime time.Time          `json:"updatedTime" bson:"updatedTime"`
+}
+
+// ReplenishmentRun is the latest set of purchase suggestions of an
+// organization, grouped by preferred supplier.
+type ReplenishmentRun struct {
+	OrgId       string               `json:"org_id" bson:"org_id"`
+	GeneratedAt time.Time            `json:"generated_at" bson:"generated_at"`
+	Groups      []SupplierSuggestion `json:"groups" bson:"groups"`
+}
+
+// SupplierSuggestion is what to order from one supplier. Products without
+// a preferred supplier are grouped under a nil SupplierId.
+type SupplierSuggestion struct {
+	SupplierId   *primitive.ObjectID `json:"supplier_id" bson:"supplier_id"`
+	SupplierName string              `json:"supplier_name" bson:"supplier_name"`
+	Currency     string              `json:"currency" bson:"currency"`
+	LeadTimeDays int                 `json:"lead_time_days" bson:"lead_time_days"`
+	Lines        []SuggestionLine    `json:"lines" bson:"lines"`
+	Total        float64             `json:"total" bson:"total"`
+}
+
+// SuggestionLine explains the suggested quantity of one product. Stock is
+// projected as Available plus OnOrder less LeadTimeDemand; when that is at
+// or below ReorderPoint, SuggestedQty brings it back above the point, and
+// is at least ReorderQty.
+type SuggestionLine struct {
+	ProductId      primitive.ObjectID `json:"product_id" bson:"product_id"`
+	SKU            string             `json:"sku" bson:"sku"`
+	Name           string             `json:"name" bson:"name"`
+	Available      int                `json:"available" bson:"available"`
+	OnOrder        int                `json:"on_order" bson:"on_order"`
+	DailyDemand    float64            `json:"daily_demand" bson:"daily_demand"`
+	LeadTimeDemand int                `json:"lead_time_demand" bson:"lead_time_demand"`
+	ReorderPoint   int                `json:"reorder_point" bson:"reorder_point"`
+	ReorderQty     int                `json:"reorder_qty" bson:"reorder_qty"`
+	SuggestedQty   int                `json:"suggested_qty" bson:"suggested_qty"`
+	UnitCost       float64            `json:"unit_cost" bson:"unit_cost"`
+}
+
+// Return authorization statuses. A return is authorized when it is
+// created and completed once every line has been inspected.
+const (
+	ReturnAuthorized = "authorized"
+	ReturnCompleted  = "completed"
+	ReturnCancelled  = "cancelled"
+)
+
+// Return reason codes.
+const (
+	ReturnReasonDamaged        = "damaged"
+	ReturnReasonDefective      = "defective"
+	ReturnReasonWrongItem      = "wrong_item"
+	ReturnReasonNotAsDescribed = "not_as_described"
+	ReturnReasonNoLongerNeeded = "no_longer_needed"
+	ReturnReasonOther          = "other"
+)
+
+// Inspection outcomes of a returned line.
+const (
+	OutcomeRestock        = "restock"
+	OutcomeScrap          = "scrap"
+	OutcomeReturnToVendor = "return_to_vendor"
+)
+
+// ReturnAuthorization (RMA) allows a customer to send back goods of a
+// placed sales order.
+type ReturnAuthorization struct {
+	ID          primitive.ObjectID `json:"_id" bson:"_id"`
+	OrgId       string             `json:"org_id" bson:"org_id"`
+	OrderId     primitive.ObjectID `json:"order_id" bson:"order_id"`
+	UserId      string             `json:"userId" bson:"userId"`
+	Lines       []ReturnLine       `json:"lines" bson:"lines"`
+	Status      string             `json:"status" bson:"status"`
+	RefundTotal float64            `json:"refund_total" bson:"refund_total"`
+	Notes       string             `json:"notes" bson:"notes"`
+	CreatedBy   string             `json:"created_by" bson:"created_by"`
+	CreatedTime time.Time          `json:"createdTime" bson:"createdTime"`
+	UpdatedTime time.Time          `json:"updatedTime" bson:"updatedTime"`
+}
+
+func (r *ReturnAuthorization) SetOrgId(orgID string) {
+	r.OrgId = orgID
+}
+
+// ReturnLine is one returned sales order line. OrderLine is the 1-based
+// position of the line on the order. Outcome, Location and RefundAmount
+// are set by the inspection.
+type ReturnLine struct {
+	OrderLine    int                `json:"order_line" bson:"order_line"`
+	ProductId    primitive.ObjectID `json:"product_id" bson:"product_id"`
+	SKU          string             `json:"sku" bson:"sku"`
+	Name         string             `json:"name" bson:"name"`
+	Quantity     int                `json:"quantity" bson:"quantity"`
+	UnitPrice    float64            `json:"unit_price" bson:"unit_price"`
+	Reason       string             `json:"reason" bson:"reason"`
+	Outcome      string             `json:"outcome,omitempty" bson:"outcome,omitempty"`
+	Location     string             `json:"location,omitempty" bson:"location,omitempty"`
+	Lot          string             `json:"lot,omitempty" bson:"lot,omitempty"`
+	Serials      []string           `json:"serials,omitempty" bson:"serials,omitempty"`
+	RefundAmount float64            `json:"refund_amount" bson:"refund_amount"`
+	InspectedBy  string             `json:"inspected_by,omitempty" bson:"inspected_by,omitempty"`
+	InspectedAt  *time.Time         `json:"inspected_at,omitempty" bson:"inspected_at,omitempty"`
+}
+
+// Lot is a batch of a product that was made or received together.
+type Lot struct {
+	ID             primitive.ObjectID `json:"_id" bson:"_id"`
+	OrgId          string             `json:"org_id" bson:"org_id"`
+	ProductId      primitive.ObjectID `json:"product_id" bson:"product_id"`
+	LotNumber      string             `json:"lot_number" bson:"lot_number"`
+	ManufacturedAt *time.Time         `json:"manufactured_at,omitempty" bson:"manufactured_at,omitempty"`
+	ExpiresAt      *time.Time         `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	CreatedTime    time.Time          `json:"createdTime" bson:"createdTime"`
+}
+
+func (l *Lot) SetOrgId(orgID string) {
+	l.OrgId = orgID
+}
+
+// LotLevel is the on hand quantity of one lot at one location. The expiry
+// date is copied from the lot so levels can be picked in FEFO order.
+type LotLevel struct {
+	OrgId       string             `json:"org_id" bson:"org_id"`
+	ProductId   primitive.ObjectID `json:"product_id" bson:"product_id"`
+	LotNumber   string             `json:"lot_number" bson:"lot_number"`
+	Location    string             `json:"location" bson:"location"`
+	OnHand      int                `json:"on_hand" bson:"on_hand"`
+	ExpiresAt   *time.Time         `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	UpdatedTime time.Time          `json:"updatedTime" bson:"updatedTime"`
+	// Expired is set on levels read for display whose lot has expired.
+	Expired bool `json:"expired" bson:"-"`
+}
+
+// IsExpired reports whether the lot has expired at t. Expired stock is not
+// sold or reserved; it can only be written off.
+func (l *LotLevel) IsExpired(t time.Time) bool {
+	return l.ExpiresAt != nil && !t.Before(*l.ExpiresAt)
+}
+
+// Serial number statuses. Only in_stock serials can leave stock.
+const (
+	SerialInStock          = "in_stock"
+	SerialShipped          = "shipped"
+	SerialScrapped         = "scrapped"
+	SerialReturnedToVendor = "returned_to_vendor"
+	SerialRemoved          = "removed"
+)
+
+// Serial is one unit of a product that tracks serial numbers. History
+// holds every movement of the unit, oldest first.
+type Serial struct {
+	ID           primitive.ObjectID `json:"_id" bson:"_id"`
+	OrgId        string             `json:"org_id" bson:"org_id"`
+	ProductId    primitive.ObjectID `json:"product_id" bson:"product_id"`
+	SerialNumber string             `json:"serial_number" bson:"serial_number"`
+	Status       string             `json:"status" bson:"status"`
+	Location     string             `json:"location,omitempty" bson:"location,omitempty"`
+	Lot          string             `json:"lot,omitempty" bson:"lot,omitempty"`
+	History      []SerialEvent      `json:"history" bson:"history"`
+	CreatedTime  time.Time          `json:"createdTime" bson:"createdTime"`
+	UpdatedTime  time.Time          `json:"updatedTime" bson:"updatedTime"`
+}
+
+func (s *Serial) SetOrgId(orgID string) {
+	s.OrgId = orgID
+}
+
+// SerialEvent is one movement of a serial.
+type SerialEvent struct {
+	Status     string             `json:"status" bson:"status"`
+	Location   string             `json:"location" bson:"location"`
+	Type       string             `json:"type" bson:"type"`
+	MovementId primitive.ObjectID `json:"movement_id" bson:"movement_id"`
+	RefType    string             `json:"ref_type,omitempty" bson:"ref_type,omitempty"`
+	RefId      string             `json:"ref_id,omitempty" bson:"ref_id,omitempty"`
+	By         string             `json:"by" bson:"by"`
+	At         time.Time          `json:"at" bson:"at"`
+}
+
+// Count session statuses. Counts are entered while a session is open; its
+// variances are posted to the stock ledger when a manager approves it.
+const (
+	CountOpen      = "open"
+	CountApproved  = "approved"
+	CountCancelled = "cancelled"
+)
+
+// ABC classes rank products by the cost of the goods they sold. A products
+// make up the first 80% of it, B products the next 15% and C products the
+// rest, including products that sold nothing.
+const (
+	ClassA = "A"
+	ClassB = "B"
+	ClassC = "C"
+)
+
+// CountSession is a cycle count or stocktake of the products picked by
+// Location, Type, ABCClass and ProductIds. The expected quantities are
+// frozen when the session starts.
+type CountSession struct {
+	ID          primitive.ObjectID   `json:"_id" bson:"_id"`
+	OrgId       string               `json:"org_id" bson:"org_id"`
+	Name        string               `json:"name" bson:"name"`
+	Status      string               `json:"status" bson:"status"`
+	Location    string               `json:"location,omitempty" bson:"location,omitempty"`
+	Type        string               `json:"type,omitempty" bson:"type,omitempty"`
+	ABCClass    string               `json:"abc_class,omitempty" bson:"abc_class,omitempty"`
+	ProductIds  []primitive.ObjectID `json:"product_ids,omitempty" bson:"product_ids,omitempty"`
+	Lines       []CountLine          `json:"lines" bson:"lines"`
+	Notes       string               `json:"notes" bson:"notes"`
+	CreatedBy   string               `json:"created_by" bson:"created_by"`
+	CreatedTime time.Time            `json:"createdTime" bson:"createdTime"`
+	UpdatedTime time.Time            `json:"updatedTime" bson:"updatedTime"`
+	ApprovedBy  string               `json:"approved_by,omitempty" bson:"approved_by,omitempty"`
+	ApprovedAt  *time.Time           `json:"approved_at,omitempty" bson:"approved_at,omitempty"`
+}
+
+func (s *CountSession) SetOrgId(orgID string) {
+	s.OrgId = orgID
+}
+
+// CountLine is the count of a product, or of one of its lots, at a
+// location. Expected is the stock when the session started. Counted is nil
+// until the line is counted; OnHand is the stock at that moment and
+// Variance is Counted less OnHand, which approval posts.
+type CountLine struct {
+	ProductId primitive.ObjectID `json:"product_id" bson:"product_id"`
+	SKU       string             `json:"sku" bson:"sku"`
+	Name      string             `json:"name" bson:"name"`
+	Location  string             `json:"location" bson:"location"`
+	Lot       string             `json:"lot,omitempty" bson:"lot,omitempty"`
+	Expected  int                `json:"expected" bson:"expected"`
+	Counted   *int               `json:"counted" bson:"counted"`
+	OnHand    int                `json:"on_hand" bson:"on_hand"`
+	Variance  int                `json:"variance" bson:"variance"`
+	CountedBy string             `json:"counted_by,omitempty" bson:"counted_by,omitempty"`
+	CountedAt *time.Time         `json:"counted_at,omitempty" bson:"counted_at,omitempty"`
+}
+
+// Adjustment statuses. Manual adjustments above the approval thresholds
+// wait as pending until a second user, a manager, approves or rejects them.
+const (
+	AdjustmentPending  = "pending"
+	AdjustmentApproved = "approved"
+	AdjustmentRejected = "rejected"
+)
+
+// StockAdjustment is a manual change of a product's stock that needs
+// approval. Quantity is the on hand stock that was asked for, OnHand the
+// stock at the time and Delta the change between them, which is what
+// approval applies. Value is Delta at the product's average cost.
+type StockAdjustment struct {
+	ID           primitive.ObjectID `json:"_id" bson:"_id"`
+	OrgId        string             `json:"org_id" bson:"org_id"`
+	ProductId    primitive.ObjectID `json:"product_id" bson:"product_id"`
+	SKU          string             `json:"sku" bson:"sku"`
+	Name         string             `json:"name" bson:"name"`
+	Quantity     int                `json:"quantity" bson:"quantity"`
+	OnHand       int                `json:"on_hand" bson:"on_hand"`
+	Delta        int                `json:"delta" bson:"delta"`
+	Value        float64            `json:"value" bson:"value"`
+	Reason       string             `json:"reason" bson:"reason"`
+	Note         string             `json:"note,omitempty" bson:"note,omitempty"`
+	Status       string             `json:"status" bson:"status"`
+	RequestedBy  string             `json:"requested_by" bson:"requested_by"`
+	ReviewedBy   string             `json:"reviewed_by,omitempty" bson:"reviewed_by,omitempty"`
+	ReviewedAt   *time.Time         `json:"reviewed_at,omitempty" bson:"reviewed_at,omitempty"`
+	RejectReason string             `json:"reject_reason,omitempty" bson:"reject_reason,omitempty"`
+	CreatedTime  time.Time          `json:"createdTime" bson:"createdTime"`
+	UpdatedTime  time.Time          `json:"updatedTime" bson:"updatedTime"`
+}
+
+func (a *StockAdjustment) SetOrgId(orgID string) {
+	a.OrgId = orgID
+}
@@ -0,0 +1,137 @@
+package passwordpolicy
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// common.txt holds widely used and breached passwords, one per line,
+// lower case. Operators can add a larger offline list through
+// PASSWORD_BLOCKLIST_FILE.
+//
+//go:embed common.txt
+var bundledList string
+
+// Policy describes the rules a new password must satisfy.
+type Policy struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// Blocklist holds lower-cased passwords that are always rejected.
+	Blocklist map[string]bool
+}
+
+// FromEnv builds the policy from the environment:
+//
+//	PASSWORD_MIN_LENGTH      minimum number of characters (default 8)
+//	PASSWORD_REQUIRE_UPPER   require an upper case letter (default false)
+//	PASSWORD_REQUIRE_LOWER   require a lower case letter (default false)
+//	PASSWORD_REQUIRE_DIGIT   require a digit (default false)
+//	PASSWORD_REQUIRE_SYMBOL  require a symbol (default false)
+//	PASSWORD_BLOCKLIST_FILE  extra passwords to reject, one per line
+func FromEnv() (Policy, error) {
+	p := Policy{
+		MinLength: envInt("PASSWORD_MIN_LENGTH", 8),
+		Blocklist: parseList(bundledList),
+	}
+	p.RequireUpper = envBool("PASSWORD_REQUIRE_UPPER")
+	p.RequireLower = envBool("PASSWORD_REQUIRE_LOWER")
+	p.RequireDigit = envBool("PASSWORD_REQUIRE_DIGIT")
+	p.RequireSymbol = envBool("PASSWORD_REQUIRE_SYMBOL")
+
+	if path := os.Getenv("PASSWORD_BLOCKLIST_FILE"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return p, fmt.Errorf("reading PASSWORD_BLOCKLIST_FILE: %w", err)
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if line := strings.ToLower(strings.TrimSpace(scanner.Text())); line != "" {
+				p.Blocklist[line] = true
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return p, fmt.Errorf("reading PASSWORD_BLOCKLIST_FILE: %w", err)
+		}
+	}
+	return p, nil
+}
+
+// Default is the policy used when the environment has not been loaded.
+func Default() Policy {
+	return Policy{MinLength: 8, Blocklist: parseList(bundledList)}
+}
+
+func parseList(list string) map[string]bool {
+	entries := make(map[string]bool)
+	for _, line := range strings.Split(list, "\n") {
+		if line = strings.ToLower(strings.TrimSpace(line)); line != "" {
+			entries[line] = true
+		}
+	}
+	return entries
+}
+
+func envInt(key string, fallback int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v > 0 {
+		return v
+	}
+	return fallback
+}
+
+func envBool(key string) bool {
+	v, _ := strconv.ParseBool(os.Getenv(key))
+	return v
+}
+
+// Check returns one message per rule the password breaks. An empty result
+// means the password is acceptable.
+func (p Policy) Check(password string, username string) []string {
+	var problems []string
+	if len([]rune(password)) < p.MinLength {
+		problems = append(problems, fmt.Sprintf("must be at least %d characters", p.MinLength))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.RequireUpper && !upper {
+		problems = append(problems, "must contain an upper case letter")
+	}
+	if p.RequireLower && !lower {
+		problems = append(problems, "must contain a lower case letter")
+	}
+	if p.RequireDigit && !digit {
+		problems = append(problems, "must contain a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		problems = append(problems, "must contain a symbol")
+	}
+
+	lowered := strings.ToLower(password)
+	if username != "" && lowered == strings.ToLower(username) {
+		problems = append(problems, "must not be the same as the username")
+	}
+	if p.Blocklist[lowered] {
+		problems = append(problems, "is too common or has appeared in a data breach")
+	}
+	return problems
+}
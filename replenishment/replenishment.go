@@ -0,0 +1,293 @@
+// Package replenishment suggests what to buy from which supplier, based on
+// the reorder points of products, their recent sales and the stock that is
+// already on order.
+package replenishment
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/yashaswini7291/Inventory/database"
+	"github.com/yashaswini7291/Inventory/models"
+	"github.com/yashaswini7291/Inventory/repository"
+	"github.com/yashaswini7291/Inventory/stock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DemandWindow is how far back sales are averaged to estimate demand
+// during a supplier's lead time.
+const DemandWindow = 30 * 24 * time.Hour
+
+// RefreshInterval is how often the job refreshes the suggestions of every
+// organization. REPLENISHMENT_INTERVAL overrides it, for example 15m.
+var RefreshInterval = refreshInterval()
+
+var (
+	SuggestionData    *mongo.Collection = database.OpenCollection(database.Client, "ReplenishmentSuggestions")
+	productData       *mongo.Collection = database.OpenCollection(database.Client, "Products")
+	supplierData      *mongo.Collection = database.OpenCollection(database.Client, "Suppliers")
+	purchaseOrderData *mongo.Collection = database.OpenCollection(database.Client, "PurchaseOrders")
+
+	Suggestions    = repository.Scoped(SuggestionData)
+	products       = repository.Scoped(productData)
+	suppliers      = repository.Scoped(supplierData)
+	purchaseOrders = repository.Scoped(purchaseOrderData)
+)
+
+func refreshInterval() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("REPLENISHMENT_INTERVAL")); err == nil && d > 0 {
+		return d
+	}
+	return time.Hour
+}
+
+// sumByProduct runs pipeline, which must group by product ID into a field
+// "total" holding a $sum, and returns the totals.
+func sumByProduct(ctx context.Context, coll *repository.Collection, pipeline mongo.Pipeline) (map[primitive.ObjectID]float64, error) {
+	cursor, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ProductId primitive.ObjectID `bson:"_id"`
+		Total     float64            `bson:"total"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	totals := make(map[primitive.ObjectID]float64, len(rows))
+	for _, row := range rows {
+		totals[row.ProductId] = row.Total
+	}
+	return totals, nil
+}
+
+// onOrder returns the units still expected from open purchase orders,
+// drafts included so that orders created from suggestions count at once.
+func onOrder(ctx context.Context) (map[primitive.ObjectID]float64, error) {
+	return sumByProduct(ctx, purchaseOrders, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"status": bson.M{"$in": bson.A{
+			models.POStatusDraft, models.POStatusSubmitted, models.POStatusPartiallyReceived,
+		}}}}},
+		{{Key: "$unwind", Value: "$lines"}},
+		{{Key: "$group", Value: bson.M{
+			"_id": "$lines.product_id",
+			"total": bson.M{"$sum": bson.M{"$max": bson.A{
+				bson.M{"$subtract": bson.A{"$lines.quantity", bson.M{"$ifNull": bson.A{"$lines.received", 0}}}},
+				0,
+			}}},
+		}}},
+	})
+}
+
+// sales returns the units sold per product since since.
+func sales(ctx context.Context, since time.Time) (map[primitive.ObjectID]float64, error) {
+	return sumByProduct(ctx, stock.Movements, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"type": models.MovementSale, "createdTime": bson.M{"$gte": since}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$product_id", "total": bson.M{"$sum": bson.M{"$multiply": bson.A{"$quantity", -1}}}}}},
+	})
+}
+
+// lastCosts returns the unit cost of the latest receipt of each product.
+func lastCosts(ctx context.Context) (map[primitive.ObjectID]float64, error) {
+	cursor, err := stock.Movements.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"type": models.MovementReceipt, "unit_cost": bson.M{"$exists": true}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "createdTime", Value: -1}, {Key: "_id", Value: -1}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$product_id", "unit_cost": bson.M{"$first": "$unit_cost"}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ProductId primitive.ObjectID `bson:"_id"`
+		UnitCost  float64            `bson:"unit_cost"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	costs := make(map[primitive.ObjectID]float64, len(rows))
+	for _, row := range rows {
+		costs[row.ProductId] = row.UnitCost
+	}
+	return costs, nil
+}
+
+// Compute works out the suggestions of the organization in ctx. Only
+// products with a reorder point take part.
+func Compute(ctx context.Context) (*models.ReplenishmentRun, error) {
+	cursor, err := products.Find(ctx, bson.M{"reorder_point": bson.M{"$gt": 0}})
+	if err != nil {
+		return nil, err
+	}
+	var candidates []models.Product
+	if err := cursor.All(ctx, &candidates); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	run := &models.ReplenishmentRun{GeneratedAt: now, Groups: make([]models.SupplierSuggestion, 0)}
+	if len(candidates) == 0 {
+		return run, nil
+	}
+
+	ordered, err := onOrder(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sold, err := sales(ctx, now.Add(-DemandWindow))
+	if err != nil {
+		return nil, err
+	}
+	costs, err := lastCosts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	supplierIDs := make([]primitive.ObjectID, 0)
+	for _, p := range candidates {
+		if p.PreferredSupplierId != nil {
+			supplierIDs = append(supplierIDs, *p.PreferredSupplierId)
+		}
+	}
+	cursor, err = suppliers.Find(ctx, bson.M{"_id": bson.M{"$in": supplierIDs}})
+	if err != nil {
+		return nil, err
+	}
+	var found []models.Supplier
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	known := make(map[primitive.ObjectID]models.Supplier, len(found))
+	for _, s := range found {
+		known[s.ID] = s
+	}
+
+	groups := make(map[primitive.ObjectID]*models.SupplierSuggestion)
+	unassigned := &models.SupplierSuggestion{}
+	windowDays := DemandWindow.Hours() / 24
+	for _, p := range candidates {
+		group := unassigned
+		var supplier models.Supplier
+		ok := false
+		if p.PreferredSupplierId != nil {
+			supplier, ok = known[*p.PreferredSupplierId]
+		}
+		if ok {
+			if groups[supplier.ID] == nil {
+				id := supplier.ID
+				groups[id] = &models.SupplierSuggestion{
+					SupplierId:   &id,
+					SupplierName: supplier.Name,
+					Currency:     supplier.Currency,
+					LeadTimeDays: supplier.LeadTimeDays,
+				}
+			}
+			group = groups[supplier.ID]
+		}
+
+		daily := math.Round(sold[p.ProductId]/windowDays*100) / 100
+		line := models.SuggestionLine{
+			ProductId:      p.ProductId,
+			SKU:            p.SKU,
+			Name:           p.Name,
+			Available:      p.Available(),
+			OnOrder:        int(ordered[p.ProductId]),
+			DailyDemand:    daily,
+			LeadTimeDemand: int(math.Ceil(sold[p.ProductId] / windowDays * float64(group.LeadTimeDays))),
+			ReorderPoint:   p.ReorderPoint,
+			ReorderQty:     p.ReorderQty,
+			UnitCost:       costs[p.ProductId],
+		}
+		projected := line.Available + line.OnOrder - line.LeadTimeDemand
+		if projected > p.ReorderPoint {
+			continue
+		}
+		line.SuggestedQty = max(p.ReorderQty, p.ReorderPoint-projected, 1)
+		group.Lines = append(group.Lines, line)
+		group.Total += float64(line.SuggestedQty) * line.UnitCost
+	}
+
+	for _, group := range groups {
+		if len(group.Lines) > 0 {
+			run.Groups = append(run.Groups, *group)
+		}
+	}
+	sort.Slice(run.Groups, func(i, j int) bool { return run.Groups[i].SupplierName < run.Groups[j].SupplierName })
+	if len(unassigned.Lines) > 0 {
+		run.Groups = append(run.Groups, *unassigned)
+	}
+	for i := range run.Groups {
+		run.Groups[i].Total = math.Round(run.Groups[i].Total*100) / 100
+	}
+	return run, nil
+}
+
+// Refresh computes the suggestions of the organization in ctx and stores
+// them as its latest run.
+func Refresh(ctx context.Context) (*models.ReplenishmentRun, error) {
+	run, err := Compute(ctx)
+	if err != nil {
+		return nil, err
+	}
+	orgID, _ := repository.OrgFromContext(ctx)
+	run.OrgId = orgID
+	update := bson.M{"$set": bson.M{"generated_at": run.GeneratedAt, "groups": run.Groups}}
+	if _, err := Suggestions.UpdateOne(ctx, bson.M{}, update, options.Update().SetUpsert(true)); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// Latest returns the stored suggestions of the organization in ctx, or
+// nil if none have been computed yet.
+func Latest(ctx context.Context) (*models.ReplenishmentRun, error) {
+	var run models.ReplenishmentRun
+	err := Suggestions.FindOne(ctx, bson.M{}).Decode(&run)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// RefreshAll refreshes the suggestions of every organization that has
+// products with a reorder point.
+func RefreshAll(ctx context.Context) error {
+	orgIDs, err := productData.Distinct(ctx, repository.OrgField, bson.M{"reorder_point": bson.M{"$gt": 0}})
+	if err != nil {
+		return err
+	}
+	for _, v := range orgIDs {
+		orgID, ok := v.(string)
+		if !ok || orgID == "" {
+			continue
+		}
+		if _, err := Refresh(repository.WithOrg(ctx, orgID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run calls RefreshAll every interval until ctx is cancelled.
+func Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := RefreshAll(ctx); err != nil {
+				slog.Error("refreshing replenishment suggestions failed", "error", err)
+			}
+		}
+	}
+}
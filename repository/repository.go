@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// OrgField is the field that holds the owning organization on every
+// tenant scoped document.
+const OrgField = "org_id"
+
+// ErrNoOrganization is returned when a scoped collection is used with a
+// context that does not carry an organization.
+var ErrNoOrganization = errors.New("no organization in context")
+
+type orgKey struct{}
+
+// WithOrg returns a copy of ctx that scopes repository calls to orgID.
+// middleware.Authentication stores the caller's organization this way.
+func WithOrg(ctx context.Context, orgID string) context.Context {
+	return context.WithValue(ctx, orgKey{}, orgID)
+}
+
+// OrgFromContext returns the organization stored by WithOrg.
+func OrgFromContext(ctx context.Context) (string, bool) {
+	orgID, ok := ctx.Value(orgKey{}).(string)
+	return orgID, ok && orgID != ""
+}
+
+// Tenanted is implemented by documents that belong to an organization.
+type Tenanted interface {
+	SetOrgId(orgID string)
+}
+
+// Collection wraps a collection whose documents belong to organizations.
+// Every read and write is restricted to the organization in the context,
+// so a document of another tenant behaves exactly like one that does not
+// exist. The underlying collection is deliberately not exposed.
+type Collection struct {
+	coll *mongo.Collection
+}
+
+// Scoped wraps coll.
+func Scoped(coll *mongo.Collection) *Collection {
+	return &Collection{coll: coll}
+}
+
+// scope returns a copy of filter restricted to the caller's organization.
+// A tenant given in filter is overwritten rather than trusted.
+func scope(ctx context.Context, filter bson.M) (bson.M, error) {
+	orgID, ok := OrgFromContext(ctx)
+	if !ok {
+		return nil, ErrNoOrganization
+	}
+	scoped := make(bson.M, len(filter)+1)
+	for k, v := range filter {
+		scoped[k] = v
+	}
+	scoped[OrgField] = orgID
+	return scoped, nil
+}
+
+func (c *Collection) Find(ctx context.Context, filter bson.M, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	scoped, err := scope(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return c.coll.Find(ctx, scoped, opts...)
+}
+
+func (c *Collection) FindOne(ctx context.Context, filter bson.M, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	scoped, err := scope(ctx, filter)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+	return c.coll.FindOne(ctx, scoped, opts...)
+}
+
+func (c *Collection) FindOneAndUpdate(ctx context.Context, filter bson.M, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	scoped, err := scope(ctx, filter)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+	return c.coll.FindOneAndUpdate(ctx, scoped, update, opts...)
+}
+
+func (c *Collection) CountDocuments(ctx context.Context, filter bson.M, opts ...*options.CountOptions) (int64, error) {
+	scoped, err := scope(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return c.coll.CountDocuments(ctx, scoped, opts...)
+}
+
+// InsertOne stamps doc with the caller's organization before inserting it.
+func (c *Collection) InsertOne(ctx context.Context, doc Tenanted, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	orgID, ok := OrgFromContext(ctx)
+	if !ok {
+		return nil, ErrNoOrganization
+	}
+	doc.SetOrgId(orgID)
+	return c.coll.InsertOne(ctx, doc, opts...)
+}
+
+func (c *Collection) UpdateOne(ctx context.Context, filter bson.M, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	scoped, err := scope(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return c.coll.UpdateOne(ctx, scoped, update, opts...)
+}
+
+func (c *Collection) UpdateMany(ctx context.Context, filter bson.M, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	scoped, err := scope(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return c.coll.UpdateMany(ctx, scoped, update, opts...)
+}
+
+func (c *Collection) DeleteOne(ctx context.Context, filter bson.M, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	scoped, err := scope(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return c.coll.DeleteOne(ctx, scoped, opts...)
+}
+
+// Aggregate runs pipeline after a leading $match on the organization.
+func (c *Collection) Aggregate(ctx context.Context, pipeline mongo.Pipeline, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	match, err := scope(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	scoped := append(mongo.Pipeline{{{Key: "$match", Value: match}}}, pipeline...)
+	return c.coll.Aggregate(ctx, scoped, opts...)
+}
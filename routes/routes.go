@@ -2,22 +2,314 @@ package routes
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/yashaswini7291/Inventory/apikeys"
 	"github.com/yashaswini7291/Inventory/controllers"
 	"github.com/yashaswini7291/Inventory/middleware"
+	"github.com/yashaswini7291/Inventory/models"
+	"github.com/yashaswini7291/Inventory/ratelimit"
 )
 
+// RateLimitStore holds the rate limit buckets for every route group. It is
+// in memory, so each instance enforces its own quota; replace it before
+// registering routes to share quotas between instances.
+var RateLimitStore ratelimit.Store = ratelimit.NewMemoryStore()
+
+// Quotas per route group. Each can be overridden with an environment
+// variable such as RATE_LIMIT_AUTH=5/m.
+var (
+	authLimit    = ratelimit.FromEnv("RATE_LIMIT_AUTH", ratelimit.PerMinute(10))
+	readLimit    = ratelimit.FromEnv("RATE_LIMIT_READ", ratelimit.PerMinute(600))
+	writeLimit   = ratelimit.FromEnv("RATE_LIMIT_WRITE", ratelimit.PerMinute(120))
+	defaultLimit = ratelimit.FromEnv("RATE_LIMIT_DEFAULT", ratelimit.PerMinute(300))
+	clientLimit  = ratelimit.FromEnv("RATE_LIMIT_CLIENT", ratelimit.PerMinute(1200))
+)
+
+// limitedByIP caps the requests to authenticated groups per client IP. It
+// runs before Authentication, so requests with made-up tokens or API keys
+// are turned away before they cost a database lookup.
+func limitedByIP() gin.HandlerFunc {
+	return middleware.RateLimit(RateLimitStore, "client", clientLimit, middleware.ByIP)
+}
+
+// limitedByUser applies the default quota to an authenticated group.
+func limitedByUser() gin.HandlerFunc {
+	return middleware.RateLimit(RateLimitStore, "default", defaultLimit, middleware.ByAPIKey)
+}
+
+// limitedRead applies the read quota to a route.
+func limitedRead() gin.HandlerFunc {
+	return middleware.RateLimit(RateLimitStore, "read", readLimit, middleware.ByAPIKey)
+}
+
+// limitedWrite applies the write quota to a route.
+func limitedWrite() gin.HandlerFunc {
+	return middleware.RateLimit(RateLimitStore, "write", writeLimit, middleware.ByAPIKey)
+}
+
+// readable lets sessions and API keys with the read scope call handler.
+func readable(handler gin.HandlerFunc) []gin.HandlerFunc {
+	return []gin.HandlerFunc{limitedRead(), middleware.RequireScope(apikeys.ScopeRead), handler}
+}
+
+// manageRoutes puts handler behind a manager's or admin's login. Changes to
+// suppliers, purchase orders, counts and adjustments go through it.
+func manageRoutes(handler gin.HandlerFunc) []gin.HandlerFunc {
+	return []gin.HandlerFunc{limitedByUser(), middleware.SessionOnly(), middleware.RequireRole(models.RoleManager, models.RoleAdmin), handler}
+}
+
+// stockGroup is an authenticated group of an organization's stock routes.
+func stockGroup(router *gin.Engine, path string) *gin.RouterGroup {
+	group := router.Group(path)
+	group.Use(limitedByIP(), middleware.Authentication(), middleware.RequireOrg())
+	return group
+}
+
 func UserRoutes(inRoute *gin.Engine) {
-	inRoute.POST("/register", controllers.SignUp())
-	inRoute.POST("/login", controllers.Login())
+	auth := inRoute.Group("")
+	auth.Use(middleware.RateLimit(RateLimitStore, "auth", authLimit, middleware.ByIP))
+
+	{
+		auth.POST("/register", controllers.SignUp())
+		auth.POST("/login", controllers.Login())
+		auth.POST("/login/mfa", controllers.LoginMFA())
+		auth.POST("/password/forgot", controllers.ForgotPassword())
+		auth.POST("/password/reset", controllers.ResetPassword())
+	}
+}
+
+func WellKnownRoutes(router *gin.Engine) {
+	router.GET("/.well-known/jwks.json", controllers.JWKS())
 }
 
 func ProductRoutes(router *gin.Engine) {
 	protected := router.Group("/products")
-	protected.Use(middleware.Authentication())
+	protected.Use(limitedByIP(), middleware.Authentication(), middleware.RequireOrg())
+
+	read := limitedRead()
+	write := limitedWrite()
+	{
+		protected.PUT("/:id/quantity", write, middleware.RequireScope(apikeys.ScopeStockAdjust), controllers.UpdateProductQuantity())
+		protected.GET("", read, middleware.RequireScope(apikeys.ScopeRead), controllers.GetAllProducts())
+		protected.GET("/:id", read, middleware.RequireScope(apikeys.ScopeRead), controllers.GetProduct())
+		protected.GET("/:id/stock", read, middleware.RequireScope(apikeys.ScopeRead), controllers.GetProductStock())
+		protected.PUT("/:id/replenishment", write, middleware.RequireScope(apikeys.ScopeProductsWrite), controllers.SetProductReplenishment())
+		protected.PUT("/:id/tracking", write, middleware.RequireScope(apikeys.ScopeProductsWrite), controllers.SetProductTracking())
+		protected.PUT("/:id/costing", write, middleware.RequireScope(apikeys.ScopeProductsWrite), controllers.SetProductCosting())
+		protected.POST("", write, middleware.RequireScope(apikeys.ScopeProductsWrite), controllers.AddProduct())
+	}
+}
+
+func APIKeyRoutes(router *gin.Engine) {
+	keys := router.Group("/api-keys")
+	keys.Use(limitedByIP(), middleware.Authentication(), limitedByUser(), middleware.SessionOnly())
+
+	{
+		keys.POST("", controllers.CreateAPIKey())
+		keys.GET("", controllers.ListAPIKeys())
+		keys.DELETE("/:id", controllers.RevokeAPIKey())
+	}
+}
+
+func MFARoutes(router *gin.Engine) {
+	mfa := router.Group("/mfa")
+	mfa.Use(limitedByIP(), middleware.Authentication(), limitedByUser(), middleware.SessionOnly())
+
+	{
+		mfa.POST("/enroll", controllers.EnrollMFA())
+		mfa.POST("/verify", controllers.VerifyMFA())
+		mfa.POST("/disable", controllers.DisableMFA())
+	}
+}
+
+func MeRoutes(router *gin.Engine) {
+	me := router.Group("/me")
+	me.Use(limitedByIP(), middleware.Authentication(), limitedByUser())
+
+	{
+		me.GET("", controllers.GetMe())
+		me.PUT("/password", middleware.SessionOnly(), controllers.ChangePassword())
+	}
+}
+
+func UserAdminRoutes(router *gin.Engine) {
+	users := router.Group("/users")
+	users.Use(limitedByIP(), middleware.Authentication(), limitedByUser(), middleware.SessionOnly(), middleware.RequireRole(models.RoleAdmin))
+
+	{
+		users.GET("", controllers.ListUsers())
+		users.GET("/:id", controllers.GetUser())
+		users.PATCH("/:id", controllers.UpdateUser())
+		users.DELETE("/:id", controllers.DeleteUser())
+		users.POST("/:id/disable", controllers.DisableUser())
+		users.POST("/:id/enable", controllers.EnableUser())
+		users.POST("/:id/unlock", controllers.UnlockUser())
+	}
+}
+
+func OrganizationRoutes(router *gin.Engine) {
+	orgs := router.Group("/orgs")
+	orgs.Use(limitedByIP(), middleware.Authentication(), limitedByUser())
+
+	{
+		orgs.POST("", middleware.SessionOnly(), middleware.RequireRole(models.RoleAdmin), controllers.CreateOrganization())
+		orgs.GET("/current", middleware.RequireOrg(), controllers.GetOrganization())
+		orgs.PUT("/current/costing", middleware.SessionOnly(), middleware.RequireOrg(), middleware.RequireRole(models.RoleAdmin), controllers.SetOrganizationCosting())
+		orgs.POST("/current/invitations", middleware.SessionOnly(), middleware.RequireOrg(), middleware.RequireRole(models.RoleAdmin), controllers.InviteUser())
+	}
+
+	invitations := router.Group("/invitations")
+	invitations.Use(limitedByIP(), middleware.Authentication(), limitedByUser(), middleware.SessionOnly())
+
+	{
+		invitations.POST("/accept", controllers.AcceptInvitation())
+	}
+}
+
+func CartRoutes(router *gin.Engine) {
+	cart := router.Group("/cart")
+	cart.Use(limitedByIP(), middleware.Authentication(), limitedByUser(), middleware.SessionOnly(), middleware.RequireOrg())
+
+	{
+		cart.GET("", controllers.GetCart())
+		cart.POST("", controllers.AddToCart())
+		cart.DELETE("", controllers.ClearCart())
+		cart.POST("/checkout", controllers.Checkout())
+		cart.PATCH("/:id", controllers.UpdateCartItem())
+		cart.DELETE("/:id", controllers.RemoveCartItem())
+	}
+}
+
+func ReturnRoutes(router *gin.Engine) {
+	returns := router.Group("/returns")
+	returns.Use(limitedByIP(), middleware.Authentication(), limitedByUser(), middleware.SessionOnly(), middleware.RequireOrg())
+
+	{
+		returns.GET("", controllers.ListReturns())
+		returns.GET("/:id", controllers.GetReturn())
+		returns.POST("", controllers.CreateReturn())
+		returns.POST("/:id/cancel", controllers.CancelReturn())
+		returns.POST("/:id/inspect", middleware.RequireRole(models.RoleManager, models.RoleAdmin), controllers.InspectReturn())
+	}
+}
+
+func OrderRoutes(router *gin.Engine) {
+	orders := router.Group("/orders")
+	orders.Use(limitedByIP(), middleware.Authentication(), limitedByUser(), middleware.SessionOnly(), middleware.RequireOrg())
+
+	{
+		orders.GET("", controllers.ListOrders())
+		orders.GET("/:id", controllers.GetOrder())
+		orders.POST("/:id/confirm", controllers.ConfirmOrder())
+		orders.POST("/:id/cancel", controllers.CancelOrder())
+	}
+}
+
+func ReservationRoutes(router *gin.Engine) {
+	reservations := router.Group("/reservations")
+	reservations.Use(limitedByIP(), middleware.Authentication(), middleware.RequireOrg())
+
+	read := limitedRead()
+	write := limitedWrite()
+	// Holding stock is for managers and for terminals with a stock:adjust
+	// key; customers reserve through checkout.
+	adjust := []gin.HandlerFunc{write, middleware.RequireScope(apikeys.ScopeStockAdjust), middleware.RequireUserRole(models.RoleManager, models.RoleAdmin)}
+	{
+		reservations.GET("", read, middleware.RequireScope(apikeys.ScopeRead), controllers.ListReservations())
+		reservations.GET("/:id", read, middleware.RequireScope(apikeys.ScopeRead), controllers.GetReservation())
+		reservations.POST("", append(adjust, controllers.CreateReservation())...)
+		reservations.POST("/:id/commit", append(adjust, controllers.CommitReservation())...)
+		reservations.POST("/:id/release", append(adjust, controllers.ReleaseReservation())...)
+	}
+}
+
+func SupplierRoutes(router *gin.Engine) {
+	suppliers := stockGroup(router, "/suppliers")
+	{
+		suppliers.GET("", readable(controllers.ListSuppliers())...)
+		suppliers.GET("/:id", readable(controllers.GetSupplier())...)
+		suppliers.POST("", manageRoutes(controllers.CreateSupplier())...)
+		suppliers.PUT("/:id", manageRoutes(controllers.UpdateSupplier())...)
+		suppliers.DELETE("/:id", manageRoutes(controllers.DeleteSupplier())...)
+	}
+}
+
+func PurchaseOrderRoutes(router *gin.Engine) {
+	orders := stockGroup(router, "/purchase-orders")
+	{
+		orders.GET("", readable(controllers.ListPurchaseOrders())...)
+		orders.GET("/:id", readable(controllers.GetPurchaseOrder())...)
+		orders.POST("", manageRoutes(controllers.CreatePurchaseOrder())...)
+		orders.PUT("/:id", manageRoutes(controllers.UpdatePurchaseOrder())...)
+		orders.POST("/:id/submit", manageRoutes(controllers.SubmitPurchaseOrder())...)
+		orders.POST("/:id/cancel", manageRoutes(controllers.CancelPurchaseOrder())...)
+		orders.GET("/:id/receipts", readable(controllers.ListPurchaseOrderReceipts())...)
+		// Receiving is warehouse work; closing short and over-receipt are
+		// checked for a manager in the handler.
+		orders.POST("/:id/receipts", limitedWrite(), middleware.RequireScope(apikeys.ScopeStockAdjust), controllers.ReceivePurchaseOrder())
+	}
+}
+
+func ReplenishmentRoutes(router *gin.Engine) {
+	replenishment := stockGroup(router, "/replenishment")
+	{
+		replenishment.GET("/suggestions", readable(controllers.GetReplenishmentSuggestions())...)
+		replenishment.POST("/orders", manageRoutes(controllers.CreateReplenishmentOrders())...)
+	}
+}
+
+func StockRoutes(router *gin.Engine) {
+	ledger := stockGroup(router, "/stock")
+	{
+		ledger.GET("/movements", readable(controllers.ListStockMovements())...)
+	}
+}
+
+func LotRoutes(router *gin.Engine) {
+	lots := stockGroup(router, "/lots")
+	{
+		lots.GET("/expiring", readable(controllers.ListExpiringLots())...)
+		lots.GET("/recall", readable(controllers.TraceLot())...)
+	}
+}
+
+func SerialRoutes(router *gin.Engine) {
+	serials := stockGroup(router, "/serials")
+	{
+		serials.GET("/:sn", readable(controllers.GetSerial())...)
+	}
+}
+
+func CountRoutes(router *gin.Engine) {
+	counts := stockGroup(router, "/counts")
+	{
+		counts.GET("", readable(controllers.ListCounts())...)
+		counts.GET("/:id", readable(controllers.GetCount())...)
+		counts.POST("", manageRoutes(controllers.CreateCount())...)
+		// Clerks enter counts, from scanners with an API key too; only a
+		// manager turns them into adjustments.
+		counts.POST("/:id/counts", limitedWrite(), middleware.RequireScope(apikeys.ScopeStockAdjust), controllers.SubmitCounts())
+		counts.POST("/:id/approve", manageRoutes(controllers.ApproveCount())...)
+		counts.POST("/:id/cancel", manageRoutes(controllers.CancelCount())...)
+	}
+}
+
+func AdjustmentRoutes(router *gin.Engine) {
+	adjustments := stockGroup(router, "/adjustments")
+	{
+		adjustments.GET("", readable(controllers.ListAdjustments())...)
+		adjustments.GET("/:id", readable(controllers.GetAdjustment())...)
+		adjustments.POST("/:id/approve", manageRoutes(controllers.ApproveAdjustment())...)
+		adjustments.POST("/:id/reject", manageRoutes(controllers.RejectAdjustment())...)
+	}
+}
 
+func ReportRoutes(router *gin.Engine) {
+	// Reports show what the stock is and was worth, which is for managers
+	// only.
+	reports := stockGroup(router, "/reports")
+	reports.Use(middleware.RequireRole(models.RoleManager, models.RoleAdmin))
 	{
-		protected.PUT("/:id/quantity", controllers.UpdateProductQuantity())
-		protected.GET("", controllers.GetAllProducts())
-		protected.POST("", controllers.AddProduct())
+		reports.GET("/valuation", limitedRead(), controllers.GetValuation())
+		reports.GET("/stock", limitedRead(), controllers.GetStockReport())
 	}
 }
@@ -0,0 +1,67 @@
+package stock
+
+import (
+	"context"
+	"time"
+
+	"github.com/yashaswini7291/Inventory/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ABCWindow is how far back ABCClasses looks at sales.
+const ABCWindow = 90 * 24 * time.Hour
+
+// ABCClasses ranks the products that sold during the last ABCWindow by the
+// cost of the goods they sold. Products that are not in the result are
+// models.ClassC.
+func ABCClasses(ctx context.Context) (map[primitive.ObjectID]string, error) {
+	since := time.Now().UTC().Add(-ABCWindow)
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"type": models.MovementSale, "createdTime": bson.M{"$gte": since}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$product_id", "cost": bson.M{"$sum": bson.M{"$multiply": bson.A{"$value", -1}}}}}},
+		{{Key: "$match", Value: bson.M{"cost": bson.M{"$gt": 0}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "cost", Value: -1}, {Key: "_id", Value: 1}}}},
+	}
+	cursor, err := Movements.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var sold []soldCost
+	if err := cursor.All(ctx, &sold); err != nil {
+		return nil, err
+	}
+	return classify(sold), nil
+}
+
+// soldCost is the cost of the goods a product sold.
+type soldCost struct {
+	ProductId primitive.ObjectID `bson:"_id"`
+	Cost      float64            `bson:"cost"`
+}
+
+// classify ranks sold, ordered by cost from highest to lowest: the
+// products making up the first 80% of the cost are models.ClassA, the
+// next 15% models.ClassB and the rest models.ClassC.
+func classify(sold []soldCost) map[primitive.ObjectID]string {
+	var total float64
+	for _, s := range sold {
+		total += s.Cost
+	}
+	classes := make(map[primitive.ObjectID]string, len(sold))
+	var cumulative float64
+	for _, s := range sold {
+		// A product belongs to the class its first unit of cost falls in.
+		switch share := cumulative / total; {
+		case share < 0.80:
+			classes[s.ProductId] = models.ClassA
+		case share < 0.95:
+			classes[s.ProductId] = models.ClassB
+		default:
+			classes[s.ProductId] = models.ClassC
+		}
+		cumulative += s.Cost
+	}
+	return classes
+}
@@ -0,0 +1,41 @@
+package stock
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/yashaswini7291/Inventory/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestClassify(t *testing.T) {
+	ids := make([]primitive.ObjectID, 4)
+	for i := range ids {
+		ids[i] = primitive.NewObjectID()
+	}
+	tests := []struct {
+		name  string
+		costs []float64
+		want  []string
+	}{
+		{"no sales", nil, nil},
+		{"one product", []float64{10}, []string{models.ClassA}},
+		{"every class", []float64{50, 30, 15, 5}, []string{models.ClassA, models.ClassA, models.ClassB, models.ClassC}},
+		{"a share of 80% starts B", []float64{80, 15, 5}, []string{models.ClassA, models.ClassB, models.ClassC}},
+		{"past 95% is C", []float64{79, 20, 1}, []string{models.ClassA, models.ClassA, models.ClassC}},
+		{"even costs", []float64{1, 1, 1, 1}, []string{models.ClassA, models.ClassA, models.ClassA, models.ClassA}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sold := make([]soldCost, len(tt.costs))
+			want := make(map[primitive.ObjectID]string, len(tt.want))
+			for i, cost := range tt.costs {
+				sold[i] = soldCost{ProductId: ids[i], Cost: cost}
+				want[ids[i]] = tt.want[i]
+			}
+			if got := classify(sold); !maps.Equal(got, want) {
+				t.Errorf("classify(%v) = %v, want %v", tt.costs, got, want)
+			}
+		})
+	}
+}
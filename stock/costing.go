@@ -0,0 +1,210 @@
+package stock
+
+import (
+	"context"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/yashaswini7291/Inventory/database"
+	"github.com/yashaswini7291/Inventory/models"
+	"github.com/yashaswini7291/Inventory/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	CostLayerData    *mongo.Collection = database.OpenCollection(database.Client, "CostLayers")
+	organizationData *mongo.Collection = database.OpenCollection(database.Client, "Organizations")
+
+	CostLayers = repository.Scoped(CostLayerData)
+)
+
+func roundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// roundUnitCost keeps unit costs to four decimals so values of large
+// quantities still add up to the cent.
+func roundUnitCost(cost float64) float64 {
+	return math.Round(cost*10000) / 10000
+}
+
+// CostMethod returns the cost method of product: its own, or else the
+// organization's, or else models.CostFIFO.
+func CostMethod(ctx context.Context, product *models.Product) (string, error) {
+	if product.CostMethod != "" {
+		return product.CostMethod, nil
+	}
+	return OrganizationCostMethod(ctx)
+}
+
+// OrganizationCostMethod returns the cost method of the organization in
+// ctx, or models.CostFIFO when it has none.
+func OrganizationCostMethod(ctx context.Context) (string, error) {
+	org, err := organization(ctx)
+	if err != nil {
+		return "", err
+	}
+	if org.CostMethod == "" {
+		return models.CostFIFO, nil
+	}
+	return org.CostMethod, nil
+}
+
+// DefaultCurrency is the currency stock is valued in for organizations
+// that have not set one. It is read from BASE_CURRENCY and defaults to USD.
+var DefaultCurrency = defaultCurrency()
+
+func defaultCurrency() string {
+	if currency := strings.ToUpper(strings.TrimSpace(os.Getenv("BASE_CURRENCY"))); currency != "" {
+		return currency
+	}
+	return "USD"
+}
+
+// OrganizationCurrency returns the currency the organization in ctx values
+// its stock in. Costs posted to the ledger must be in it.
+func OrganizationCurrency(ctx context.Context) (string, error) {
+	org, err := organization(ctx)
+	if err != nil {
+		return "", err
+	}
+	if org.Currency == "" {
+		return DefaultCurrency, nil
+	}
+	return org.Currency, nil
+}
+
+// organization loads the costing settings of the organization in ctx. An
+// organization that does not exist has none.
+func organization(ctx context.Context) (*models.Organization, error) {
+	orgID, ok := repository.OrgFromContext(ctx)
+	if !ok {
+		return nil, repository.ErrNoOrganization
+	}
+	var org models.Organization
+	opts := options.FindOne().SetProjection(bson.M{"cost_method": 1, "currency": 1})
+	err := organizationData.FindOne(ctx, bson.M{"org_id": orgID}, opts).Decode(&org)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// costMovement sets the unit cost and value of m. product is the product
+// as it was before m. Stock coming in is valued at m.UnitCost, or when the
+// movement brings no cost of its own at the average cost, and the
+// standard cost for products without one. It adds a cost layer and moves
+// the average cost. Stock going out consumes cost layers oldest first and
+// is valued by the product's cost method. Products costed at standard
+// cost are valued at it both ways.
+func costMovement(ctx context.Context, m *models.StockMovement, product *models.Product, now time.Time) error {
+	method, err := CostMethod(ctx, product)
+	if err != nil {
+		return err
+	}
+
+	if m.Quantity > 0 {
+		unitCost := product.AverageCost
+		switch {
+		case m.UnitCost != nil:
+			unitCost = *m.UnitCost
+		case unitCost == 0:
+			unitCost = product.StandardCost
+		}
+		m.UnitCost = &unitCost
+		m.Value = roundMoney(float64(m.Quantity) * unitCost)
+		if method == models.CostStandard {
+			m.Value = roundMoney(float64(m.Quantity) * product.StandardCost)
+		}
+
+		_, err := CostLayers.InsertOne(ctx, &models.CostLayer{
+			ID:          primitive.NewObjectID(),
+			ProductId:   m.ProductId,
+			MovementId:  m.ID,
+			Quantity:    m.Quantity,
+			Remaining:   m.Quantity,
+			UnitCost:    unitCost,
+			CreatedTime: now,
+		})
+		if err != nil {
+			return err
+		}
+		held := max(product.OnHand, 0)
+		average := (float64(held)*product.AverageCost + float64(m.Quantity)*unitCost) / float64(held+m.Quantity)
+		_, err = products.UpdateOne(ctx, bson.M{"_id": m.ProductId}, bson.M{"$set": bson.M{"average_cost": roundUnitCost(average)}})
+		return err
+	}
+
+	quantity := -m.Quantity
+	fifo, err := consumeLayers(ctx, m.ProductId, quantity, product.AverageCost)
+	if err != nil {
+		return err
+	}
+	var cost float64
+	switch method {
+	case models.CostAverage:
+		cost = float64(quantity) * product.AverageCost
+	case models.CostStandard:
+		cost = float64(quantity) * product.StandardCost
+	default:
+		cost = fifo
+	}
+	unitCost := roundUnitCost(cost / float64(quantity))
+	m.UnitCost = &unitCost
+	m.Value = -roundMoney(cost)
+	return nil
+}
+
+// consumeLayers takes quantity units out of the cost layers of a product,
+// oldest first, and returns their cost. Units beyond the layers, stock
+// from before costing existed, are costed at fallback.
+func consumeLayers(ctx context.Context, productID primitive.ObjectID, quantity int, fallback float64) (float64, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdTime", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := CostLayers.Find(ctx, bson.M{"product_id": productID, "remaining": bson.M{"$gt": 0}}, opts)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var layers []models.CostLayer
+	for covered := 0; covered < quantity && cursor.Next(ctx); {
+		var layer models.CostLayer
+		if err := cursor.Decode(&layer); err != nil {
+			return 0, err
+		}
+		layers = append(layers, layer)
+		covered += layer.Remaining
+	}
+	if err := cursor.Err(); err != nil {
+		return 0, err
+	}
+
+	cost, takes := takeLayers(layers, quantity, fallback)
+	for i, take := range takes {
+		if _, err := CostLayers.UpdateOne(ctx, bson.M{"_id": layers[i].ID}, bson.M{"$inc": bson.M{"remaining": -take}}); err != nil {
+			return 0, err
+		}
+	}
+	return cost, nil
+}
+
+// takeLayers works out how many units to take from each of layers, in
+// order, to make up quantity, and what they cost. Units beyond the layers
+// are costed at fallback. takes has an entry for each layer used.
+func takeLayers(layers []models.CostLayer, quantity int, fallback float64) (cost float64, takes []int) {
+	for _, layer := range layers {
+		if quantity <= 0 {
+			break
+		}
+		take := min(layer.Remaining, quantity)
+		takes = append(takes, take)
+		cost += float64(take) * layer.UnitCost
+		quantity -= take
+	}
+	return cost + float64(quantity)*fallback, takes
+}
@@ -0,0 +1,43 @@
+package stock
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/yashaswini7291/Inventory/models"
+)
+
+func TestTakeLayers(t *testing.T) {
+	layers := []models.CostLayer{
+		{Remaining: 3, UnitCost: 2},
+		{Remaining: 5, UnitCost: 4},
+	}
+	tests := []struct {
+		name     string
+		layers   []models.CostLayer
+		quantity int
+		fallback float64
+		cost     float64
+		takes    []int
+	}{
+		{"part of the oldest layer", layers, 2, 10, 4, []int{2}},
+		{"the whole oldest layer", layers, 3, 10, 6, []int{3}},
+		{"into the next layer", layers, 5, 10, 14, []int{3, 2}},
+		{"every layer", layers, 8, 10, 26, []int{3, 5}},
+		{"beyond the layers", layers, 10, 10, 46, []int{3, 5}},
+		{"no layers", nil, 4, 1.5, 6, nil},
+		{"no layers and no fallback", nil, 4, 0, 0, nil},
+		{"nothing", layers, 0, 10, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cost, takes := takeLayers(tt.layers, tt.quantity, tt.fallback)
+			if cost != tt.cost {
+				t.Errorf("cost = %v, want %v", cost, tt.cost)
+			}
+			if !slices.Equal(takes, tt.takes) {
+				t.Errorf("takes = %v, want %v", takes, tt.takes)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package stock
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/yashaswini7291/Inventory/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// uniqueIndexes are the fields that identify a document in the stock
+// collections whose documents are created on first use. The unique
+// indexes on them keep concurrent writers from creating one twice.
+var uniqueIndexes = []struct {
+	coll   *mongo.Collection
+	fields []string
+}{
+	{LotData, []string{repository.OrgField, "product_id", "lot_number"}},
+	{LotLevelData, []string{repository.OrgField, "product_id", "lot_number", "location"}},
+	{SerialData, []string{repository.OrgField, "product_id", "serial_number"}},
+	{SnapshotData, []string{repository.OrgField, "date"}},
+}
+
+// EnsureIndexes creates the unique indexes of the stock collections. It
+// fails if a collection already holds duplicates.
+func EnsureIndexes(ctx context.Context) error {
+	for _, index := range uniqueIndexes {
+		keys := bson.D{}
+		for _, field := range index.fields {
+			keys = append(keys, bson.E{Key: field, Value: 1})
+		}
+		model := mongo.IndexModel{Keys: keys, Options: options.Index().SetUnique(true)}
+		if _, err := index.coll.Indexes().CreateOne(ctx, model); err != nil {
+			return fmt.Errorf("indexing %s: %w", index.coll.Name(), err)
+		}
+	}
+	return nil
+}
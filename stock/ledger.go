@@ -0,0 +1,265 @@
+package stock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/yashaswini7291/Inventory/database"
+	"github.com/yashaswini7291/Inventory/models"
+	"github.com/yashaswini7291/Inventory/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultLocation holds stock that was not put anywhere else, including
+// all stock that existed before locations were introduced.
+const DefaultLocation = "MAIN"
+
+var ErrUnknownProduct = errors.New("product not found")
+
+var (
+	MovementData *mongo.Collection = database.OpenCollection(database.Client, "StockMovements")
+	LevelData    *mongo.Collection = database.OpenCollection(database.Client, "StockLevels")
+
+	Movements = repository.Scoped(MovementData)
+	Levels    = repository.Scoped(LevelData)
+)
+
+// Post records m in the ledger and applies it to the stock level at
+// m.Location and to the product's on hand total. Stock at a location never
+// goes below zero; taking more than is there fails with
+// ErrInsufficientStock. Movements of products that track lots must name a
+// lot, and are applied to the lot's level as well. Movements of products
+// that track serial numbers must list one serial per unit, which moves the
+// serials in or out of stock. Every movement is costed, see
+// costMovement. Post does not look at reserved stock, callers that take
+// stock out must check it themselves. It must run inside a transaction.
+func Post(ctx context.Context, m *models.StockMovement) error {
+	if m.Quantity == 0 {
+		return errors.New("stock movement without quantity")
+	}
+	if m.Location == "" {
+		m.Location = DefaultLocation
+	}
+	now := time.Now().UTC()
+
+	if m.ID.IsZero() {
+		m.ID = primitive.NewObjectID()
+	}
+	if _, err := openLedger(ctx, m.ProductId, now); err != nil {
+		return err
+	}
+	var product models.Product
+	projection := bson.M{
+		"quantity": 1, "track_lots": 1, "track_serials": 1,
+		"cost_method": 1, "standard_cost": 1, "average_cost": 1,
+	}
+	opts := options.FindOneAndUpdate().SetProjection(projection)
+	err := products.FindOneAndUpdate(ctx, bson.M{"_id": m.ProductId}, bson.M{"$inc": bson.M{"quantity": m.Quantity}}, opts).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		return ErrUnknownProduct
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case product.TrackLots && m.Lot == "":
+		return ErrLotRequired
+	case !product.TrackLots && m.Lot != "":
+		return ErrLotNotTracked
+	case m.Lot != "":
+		if err := postLot(ctx, m, now); err != nil {
+			return err
+		}
+	}
+	switch {
+	case product.TrackSerials:
+		if err := postSerials(ctx, m, now); err != nil {
+			return err
+		}
+	case len(m.Serials) > 0:
+		return ErrSerialsNotTracked
+	}
+
+	filter := bson.M{"product_id": m.ProductId, "location": m.Location}
+	update := bson.M{
+		"$inc": bson.M{"on_hand": m.Quantity},
+		"$set": bson.M{"updatedTime": now},
+	}
+	if m.Quantity < 0 {
+		filter["on_hand"] = bson.M{"$gte": -m.Quantity}
+		result, err := Levels.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return fmt.Errorf("%w at %s", ErrInsufficientStock, m.Location)
+		}
+	} else if _, err := Levels.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true)); err != nil {
+		return err
+	}
+
+	if err := costMovement(ctx, m, &product, now); err != nil {
+		return err
+	}
+
+	m.CreatedTime = now
+	_, err = Movements.InsertOne(ctx, m)
+	return err
+}
+
+// Issue takes quantity units of m.ProductId out of stock, posting one
+// movement like m per location it draws from. DefaultLocation is drawn
+// first, then the other locations in name order. Products that track lots
+// are drawn first-expiry-first-out instead, and products that track serial
+// numbers from wherever the serials in m.Serials are. It must run inside a
+// transaction.
+func Issue(ctx context.Context, m models.StockMovement, quantity int) error {
+	var product models.Product
+	err := products.FindOne(ctx, bson.M{"_id": m.ProductId}).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		return ErrUnknownProduct
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := openLedger(ctx, m.ProductId, time.Now().UTC()); err != nil {
+		return err
+	}
+	switch {
+	case product.TrackSerials:
+		return issueSerials(ctx, m, quantity)
+	case product.TrackLots:
+		return issueLots(ctx, m, quantity)
+	}
+	levels, err := LevelsOf(ctx, m.ProductId)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(levels, func(i, j int) bool {
+		return levels[i].Location == DefaultLocation && levels[j].Location != DefaultLocation
+	})
+	for _, level := range levels {
+		if quantity == 0 {
+			break
+		}
+		take := min(level.OnHand, quantity)
+		if take <= 0 {
+			continue
+		}
+		movement := m
+		movement.ID = primitive.NilObjectID
+		movement.Location = level.Location
+		movement.Quantity = -take
+		if err := Post(ctx, &movement); err != nil {
+			return err
+		}
+		quantity -= take
+	}
+	if quantity > 0 {
+		return ErrInsufficientStock
+	}
+	return nil
+}
+
+// LevelsOf returns the stock levels of a product, by location.
+func LevelsOf(ctx context.Context, productID primitive.ObjectID) ([]models.StockLevel, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "location", Value: 1}})
+	cursor, err := Levels.Find(ctx, bson.M{"product_id": productID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	levels := make([]models.StockLevel, 0)
+	if err := cursor.All(ctx, &levels); err != nil {
+		return nil, err
+	}
+	return levels, nil
+}
+
+// openLedger gives a product that is not in the stock ledger yet an
+// opening balance at DefaultLocation equal to its on hand stock, and marks
+// it as opened. Products that already have stock levels are only marked.
+// Marking writes the product, so of two transactions opening the same
+// product one conflicts and is run again, and then finds it opened. It
+// returns whether an opening balance was recorded and must run inside a
+// transaction.
+func openLedger(ctx context.Context, productID primitive.ObjectID, now time.Time) (bool, error) {
+	var product models.Product
+	opts := options.FindOneAndUpdate().SetProjection(bson.M{"quantity": 1})
+	filter := bson.M{"_id": productID, "ledger_opened": bson.M{"$ne": true}}
+	err := products.FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"ledger_opened": true}}, opts).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	levels, err := Levels.CountDocuments(ctx, bson.M{"product_id": productID}, options.Count().SetLimit(1))
+	if err != nil || levels > 0 {
+		return false, err
+	}
+
+	level := bson.M{"$setOnInsert": bson.M{"on_hand": product.OnHand, "updatedTime": now}}
+	levelFilter := bson.M{"product_id": productID, "location": DefaultLocation}
+	if _, err := Levels.UpdateOne(ctx, levelFilter, level, options.Update().SetUpsert(true)); err != nil {
+		return false, err
+	}
+	if product.OnHand == 0 {
+		return false, nil
+	}
+	_, err = Movements.InsertOne(ctx, &models.StockMovement{
+		ID:          primitive.NewObjectID(),
+		ProductId:   productID,
+		Location:    DefaultLocation,
+		Quantity:    product.OnHand,
+		Type:        models.MovementOpening,
+		Note:        "balance before the stock ledger",
+		CreatedBy:   "system",
+		CreatedTime: now,
+	})
+	return err == nil, err
+}
+
+// OpenLedger brings every product that is not in the stock ledger yet into
+// it, see openLedger. Post opens products as they move, so this only makes
+// the stock of products that have not moved since the ledger was
+// introduced show in the ledger and its reports. It is safe to run on
+// several instances at once and returns the number of opening balances
+// recorded.
+func OpenLedger(ctx context.Context) (int, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"org_id": bson.M{"$nin": bson.A{nil, ""}}, "ledger_opened": bson.M{"$ne": true}}}},
+		{{Key: "$project", Value: bson.M{"_id": 1, "org_id": 1}}},
+	}
+	cursor, err := productData.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	var unopened []models.Product
+	if err := cursor.All(ctx, &unopened); err != nil {
+		return 0, err
+	}
+
+	opened := 0
+	for _, p := range unopened {
+		orgCtx := repository.WithOrg(ctx, p.OrgId)
+		var recorded bool
+		err := database.Transaction(orgCtx, func(sc mongo.SessionContext) error {
+			var err error
+			recorded, err = openLedger(sc, p.ProductId, time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return opened, err
+		}
+		if recorded {
+			opened++
+		}
+	}
+	return opened, nil
+}
@@ -0,0 +1,225 @@
+package stock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/yashaswini7291/Inventory/database"
+	"github.com/yashaswini7291/Inventory/models"
+	"github.com/yashaswini7291/Inventory/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrLotRequired   = errors.New("the product tracks lots, a lot number is required")
+	ErrLotNotTracked = errors.New("the product does not track lots")
+	ErrUnknownLot    = errors.New("lot not found")
+)
+
+var (
+	LotData      *mongo.Collection = database.OpenCollection(database.Client, "Lots")
+	LotLevelData *mongo.Collection = database.OpenCollection(database.Client, "LotLevels")
+
+	Lots      = repository.Scoped(LotData)
+	LotLevels = repository.Scoped(LotLevelData)
+)
+
+// EnsureLot returns the lot of lot.ProductId numbered lot.LotNumber,
+// creating it from lot if it does not exist yet. The dates of an existing
+// lot are kept.
+func EnsureLot(ctx context.Context, lot *models.Lot) (*models.Lot, error) {
+	insert := bson.M{"_id": primitive.NewObjectID(), "createdTime": time.Now().UTC()}
+	if lot.ManufacturedAt != nil {
+		insert["manufactured_at"] = lot.ManufacturedAt.UTC()
+	}
+	if lot.ExpiresAt != nil {
+		insert["expires_at"] = lot.ExpiresAt.UTC()
+	}
+	filter := bson.M{"product_id": lot.ProductId, "lot_number": lot.LotNumber}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var stored models.Lot
+	err := Lots.FindOneAndUpdate(ctx, filter, bson.M{"$setOnInsert": insert}, opts).Decode(&stored)
+	if mongo.IsDuplicateKeyError(err) {
+		// A concurrent receipt created the lot first.
+		return FindLot(ctx, lot.ProductId, lot.LotNumber)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+// FindLot returns the lot of a product by its number.
+func FindLot(ctx context.Context, productID primitive.ObjectID, lotNumber string) (*models.Lot, error) {
+	var lot models.Lot
+	err := Lots.FindOne(ctx, bson.M{"product_id": productID, "lot_number": lotNumber}).Decode(&lot)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUnknownLot
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &lot, nil
+}
+
+// postLot applies m to the level of its lot at its location.
+func postLot(ctx context.Context, m *models.StockMovement, now time.Time) error {
+	filter := bson.M{"product_id": m.ProductId, "lot_number": m.Lot, "location": m.Location}
+	update := bson.M{
+		"$inc": bson.M{"on_hand": m.Quantity},
+		"$set": bson.M{"updatedTime": now},
+	}
+	if m.Quantity < 0 {
+		filter["on_hand"] = bson.M{"$gte": -m.Quantity}
+		result, err := LotLevels.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return fmt.Errorf("%w of lot %s at %s", ErrInsufficientStock, m.Lot, m.Location)
+		}
+		return nil
+	}
+	lot, err := FindLot(ctx, m.ProductId, m.Lot)
+	if err != nil {
+		return err
+	}
+	if lot.ExpiresAt != nil {
+		update["$set"].(bson.M)["expires_at"] = lot.ExpiresAt
+	}
+	_, err = LotLevels.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// A concurrent receipt created the level first; it matches now.
+		_, err = LotLevels.UpdateOne(ctx, filter, update)
+	}
+	return err
+}
+
+// LotLevelsOf returns the lot levels of a product that hold stock, in the
+// order they are picked: first expiry first, lots without an expiry date
+// last, and DefaultLocation before the other locations. Expired levels are
+// flagged.
+func LotLevelsOf(ctx context.Context, productID primitive.ObjectID) ([]models.LotLevel, error) {
+	cursor, err := LotLevels.Find(ctx, bson.M{"product_id": productID, "on_hand": bson.M{"$gt": 0}})
+	if err != nil {
+		return nil, err
+	}
+	levels := make([]models.LotLevel, 0)
+	if err := cursor.All(ctx, &levels); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	for i := range levels {
+		levels[i].Expired = levels[i].IsExpired(now)
+	}
+	sort.SliceStable(levels, func(i, j int) bool {
+		a, b := levels[i], levels[j]
+		switch {
+		case a.ExpiresAt == nil && b.ExpiresAt != nil:
+			return false
+		case a.ExpiresAt != nil && b.ExpiresAt == nil:
+			return true
+		case a.ExpiresAt != nil && !a.ExpiresAt.Equal(*b.ExpiresAt):
+			return a.ExpiresAt.Before(*b.ExpiresAt)
+		case a.Location != b.Location:
+			if a.Location == DefaultLocation || b.Location == DefaultLocation {
+				return a.Location == DefaultLocation
+			}
+			return a.Location < b.Location
+		default:
+			return a.LotNumber < b.LotNumber
+		}
+	})
+	return levels, nil
+}
+
+// ExpiringLots returns the lot levels holding stock that expire before
+// until, expired ones included, soonest first.
+func ExpiringLots(ctx context.Context, until time.Time) ([]models.LotLevel, error) {
+	filter := bson.M{"on_hand": bson.M{"$gt": 0}, "expires_at": bson.M{"$lte": until}}
+	opts := options.Find().SetSort(bson.D{
+		{Key: "expires_at", Value: 1},
+		{Key: "product_id", Value: 1},
+		{Key: "lot_number", Value: 1},
+		{Key: "location", Value: 1},
+	})
+	cursor, err := LotLevels.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	levels := make([]models.LotLevel, 0)
+	if err := cursor.All(ctx, &levels); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	for i := range levels {
+		levels[i].Expired = levels[i].IsExpired(now)
+	}
+	return levels, nil
+}
+
+// ExpiredStock returns the units of a product held in lots that have
+// expired.
+func ExpiredStock(ctx context.Context, productID primitive.ObjectID) (int, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"product_id": productID,
+			"on_hand":    bson.M{"$gt": 0},
+			"expires_at": bson.M{"$lte": time.Now().UTC()},
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "on_hand": bson.M{"$sum": "$on_hand"}}}},
+	}
+	cursor, err := LotLevels.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	var totals []struct {
+		OnHand int `bson:"on_hand"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return 0, err
+	}
+	if len(totals) == 0 {
+		return 0, nil
+	}
+	return totals[0].OnHand, nil
+}
+
+// issueLots takes quantity units of a lot tracked product out of stock in
+// FEFO order, posting one movement like m per lot and location. Sales skip
+// expired lots; other deductions, such as writing stock off, take them
+// first.
+func issueLots(ctx context.Context, m models.StockMovement, quantity int) error {
+	levels, err := LotLevelsOf(ctx, m.ProductId)
+	if err != nil {
+		return err
+	}
+	for _, level := range levels {
+		if quantity == 0 {
+			break
+		}
+		if level.Expired && m.Type == models.MovementSale {
+			continue
+		}
+		take := min(level.OnHand, quantity)
+		movement := m
+		movement.ID = primitive.NilObjectID
+		movement.Location = level.Location
+		movement.Lot = level.LotNumber
+		movement.Quantity = -take
+		if err := Post(ctx, &movement); err != nil {
+			return err
+		}
+		quantity -= take
+	}
+	if quantity > 0 {
+		return ErrInsufficientStock
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package stock
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/yashaswini7291/Inventory/database"
+	"github.com/yashaswini7291/Inventory/models"
+	"github.com/yashaswini7291/Inventory/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MaxReservationTTL is the longest a reservation may hold stock. Longer
+// holds would let a single caller take stock off the shelf for days.
+const MaxReservationTTL = time.Hour
+
+// DefaultReservationTTL is used when a reservation does not ask for a
+// lifetime. RESERVATION_TTL overrides it, for example RESERVATION_TTL=30m,
+// up to MaxReservationTTL.
+var DefaultReservationTTL = reservationTTL()
+
+var (
+	ErrInsufficientStock = errors.New("not enough stock available")
+	// ErrReservationClosed is returned when a reservation is no longer
+	// active, because it was committed, released or has expired.
+	ErrReservationClosed = errors.New("reservation is no longer active")
+)
+
+var (
+	ReservationData *mongo.Collection = database.OpenCollection(database.Client, "Reservations")
+	productData     *mongo.Collection = database.OpenCollection(database.Client, "Products")
+	orderData       *mongo.Collection = database.OpenCollection(database.Client, "SalesOrders")
+
+	Reservations = repository.Scoped(ReservationData)
+	products     = repository.Scoped(productData)
+	orders       = repository.Scoped(orderData)
+)
+
+func reservationTTL() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("RESERVATION_TTL")); err == nil && d > 0 {
+		return min(d, MaxReservationTTL)
+	}
+	return 15 * time.Minute
+}
+
+// availableAtLeast matches products whose on hand stock minus reserved
+// stock is at least quantity.
+func availableAtLeast(quantity int) bson.M {
+	return bson.M{"$gte": bson.A{
+		bson.M{"$subtract": bson.A{"$quantity", bson.M{"$ifNull": bson.A{"$reserved", 0}}}},
+		quantity,
+	}}
+}
+
+// Reserve holds r.Quantity units of r.ProductId and stores r as active.
+// Stock in expired lots cannot be reserved, since it cannot be sold. It
+// must run inside a transaction, see database.Transaction, so the hold and
+// the reservation are written together, and undone together when the
+// expired stock turns out to be needed.
+func Reserve(ctx context.Context, r *models.Reservation) error {
+	filter := bson.M{"_id": r.ProductId, "$expr": availableAtLeast(r.Quantity)}
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"quantity": 1, "reserved": 1, "track_lots": 1})
+	var product models.Product
+	err := products.FindOneAndUpdate(ctx, filter, bson.M{"$inc": bson.M{"reserved": r.Quantity}}, opts).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		return ErrInsufficientStock
+	}
+	if err != nil {
+		return err
+	}
+	if product.TrackLots {
+		expired, err := ExpiredStock(ctx, r.ProductId)
+		if err != nil {
+			return err
+		}
+		if product.Available() < expired {
+			return ErrInsufficientStock
+		}
+	}
+	now := time.Now().UTC()
+	if r.ID.IsZero() {
+		r.ID = primitive.NewObjectID()
+	}
+	r.Status = models.ReservationActive
+	r.CreatedTime = now
+	r.UpdatedTime = now
+	_, err = Reservations.InsertOne(ctx, r)
+	return err
+}
+
+// finish moves an active reservation to status and returns it. Commit
+// additionally requires that the reservation has not expired yet.
+func finish(ctx context.Context, id primitive.ObjectID, status string) (*models.Reservation, error) {
+	now := time.Now().UTC()
+	filter := bson.M{"_id": id, "status": models.ReservationActive}
+	if status == models.ReservationCommitted {
+		filter["expires_at"] = bson.M{"$gt": now}
+	}
+	update := bson.M{"$set": bson.M{"status": status, "updatedTime": now}}
+	var r models.Reservation
+	err := Reservations.FindOneAndUpdate(ctx, filter, update).Decode(&r)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrReservationClosed
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.Status = status
+	return &r, nil
+}
+
+// Commit turns the reservation into a deduction: the held units leave
+// reserved stock and are issued from the ledger as a sale, see Issue. It
+// must run inside a transaction.
+func Commit(ctx context.Context, id primitive.ObjectID) (*models.Reservation, error) {
+	return CommitSerials(ctx, id, nil)
+}
+
+// CommitSerials is Commit for products that track serial numbers: serials
+// lists the units that are shipped.
+func CommitSerials(ctx context.Context, id primitive.ObjectID, serials []string) (*models.Reservation, error) {
+	r, err := finish(ctx, id, models.ReservationCommitted)
+	if err != nil {
+		return nil, err
+	}
+	update := bson.M{"$inc": bson.M{"reserved": -r.Quantity}}
+	if _, err := products.UpdateOne(ctx, bson.M{"_id": r.ProductId}, update); err != nil {
+		return nil, err
+	}
+	sale := models.StockMovement{
+		ProductId: r.ProductId,
+		Type:      models.MovementSale,
+		RefType:   "reservation",
+		RefId:     r.ID.Hex(),
+		Serials:   serials,
+		CreatedBy: r.CreatedBy,
+	}
+	if r.OrderId != nil {
+		sale.RefType, sale.RefId = "sales_order", r.OrderId.Hex()
+	}
+	if err := Issue(ctx, sale, r.Quantity); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Release gives the held units back to available stock. It must run
+// inside a transaction.
+func Release(ctx context.Context, id primitive.ObjectID) (*models.Reservation, error) {
+	return release(ctx, id, models.ReservationReleased)
+}
+
+func release(ctx context.Context, id primitive.ObjectID, status string) (*models.Reservation, error) {
+	r, err := finish(ctx, id, status)
+	if err != nil {
+		return nil, err
+	}
+	update := bson.M{"$inc": bson.M{"reserved": -r.Quantity}}
+	if _, err := products.UpdateOne(ctx, bson.M{"_id": r.ProductId}, update); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SweepExpired releases active reservations whose time is up and marks
+// pending orders that held them as expired. It is safe to run on several
+// instances at once. It returns the number of reservations released.
+func SweepExpired(ctx context.Context) (int, error) {
+	filter := bson.M{"status": models.ReservationActive, "expires_at": bson.M{"$lte": time.Now().UTC()}}
+	opts := options.Find().SetLimit(500).SetProjection(bson.M{"_id": 1, "org_id": 1, "order_id": 1})
+	cursor, err := ReservationData.Find(ctx, filter, opts)
+	if err != nil {
+		return 0, err
+	}
+	var expired []models.Reservation
+	if err := cursor.All(ctx, &expired); err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for _, r := range expired {
+		orgCtx := repository.WithOrg(ctx, r.OrgId)
+		err := database.Transaction(orgCtx, func(sc mongo.SessionContext) error {
+			if _, err := release(sc, r.ID, models.ReservationExpired); err != nil {
+				return err
+			}
+			if r.OrderId == nil {
+				return nil
+			}
+			_, err := orders.UpdateOne(sc,
+				bson.M{"_id": *r.OrderId, "status": models.OrderStatusPending},
+				bson.M{"$set": bson.M{"status": models.OrderStatusExpired, "updatedTime": time.Now().UTC()}})
+			return err
+		})
+		// Another instance got there first.
+		if errors.Is(err, ErrReservationClosed) {
+			continue
+		}
+		if err != nil {
+			return released, err
+		}
+		released++
+	}
+	return released, nil
+}
+
+// RunSweeper calls SweepExpired every interval until ctx is cancelled.
+func RunSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := SweepExpired(ctx)
+			if err != nil {
+				slog.Error("releasing expired reservations failed", "error", err)
+				continue
+			}
+			if n > 0 {
+				slog.Info("released expired reservations", "count", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package stock
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// eval evaluates the aggregation operators availableAtLeast uses against
+// doc, the way $expr does on the server.
+func eval(t *testing.T, expr interface{}, doc bson.M) interface{} {
+	t.Helper()
+	switch e := expr.(type) {
+	case string:
+		if field, ok := strings.CutPrefix(e, "$"); ok {
+			return doc[field]
+		}
+		return e
+	case int:
+		return e
+	case bson.M:
+		for op, args := range e {
+			a := args.(bson.A)
+			switch op {
+			case "$gte":
+				return eval(t, a[0], doc).(int) >= eval(t, a[1], doc).(int)
+			case "$subtract":
+				return eval(t, a[0], doc).(int) - eval(t, a[1], doc).(int)
+			case "$ifNull":
+				if v := eval(t, a[0], doc); v != nil {
+					return v
+				}
+				return eval(t, a[1], doc)
+			}
+			t.Fatalf("unexpected operator %s", op)
+		}
+	}
+	t.Fatalf("unexpected expression %#v", expr)
+	return nil
+}
+
+func TestAvailableAtLeast(t *testing.T) {
+	tests := []struct {
+		doc      bson.M
+		quantity int
+		want     bool
+	}{
+		{bson.M{"quantity": 5}, 5, true},
+		{bson.M{"quantity": 5}, 6, false},
+		{bson.M{"quantity": 5, "reserved": nil}, 5, true},
+		{bson.M{"quantity": 5, "reserved": 0}, 5, true},
+		{bson.M{"quantity": 5, "reserved": 2}, 3, true},
+		{bson.M{"quantity": 5, "reserved": 2}, 4, false},
+		{bson.M{"quantity": 5, "reserved": 5}, 1, false},
+		{bson.M{"quantity": 0}, 1, false},
+		{bson.M{"quantity": 3, "reserved": 4}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.doc, tt.quantity), func(t *testing.T) {
+			if got := eval(t, availableAtLeast(tt.quantity), tt.doc); got != tt.want {
+				t.Errorf("availableAtLeast(%d) on %v = %v, want %v", tt.quantity, tt.doc, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package stock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yashaswini7291/Inventory/database"
+	"github.com/yashaswini7291/Inventory/models"
+	"github.com/yashaswini7291/Inventory/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrSerialsRequired   = errors.New("the product tracks serial numbers, one is required per unit")
+	ErrSerialsNotTracked = errors.New("the product does not track serial numbers")
+)
+
+// SerialError names serial numbers that cannot be moved and why.
+type SerialError struct {
+	Serials []string
+	Reason  string
+}
+
+func (e *SerialError) Error() string {
+	return fmt.Sprintf("serial numbers %s %s", strings.Join(e.Serials, ", "), e.Reason)
+}
+
+var (
+	SerialData *mongo.Collection = database.OpenCollection(database.Client, "Serials")
+
+	Serials = repository.Scoped(SerialData)
+)
+
+// serialStatus is the status a serial takes when it leaves stock through a
+// movement of movementType.
+func serialStatus(movementType string) string {
+	switch movementType {
+	case models.MovementSale:
+		return models.SerialShipped
+	case models.MovementScrap:
+		return models.SerialScrapped
+	case models.MovementVendorReturn:
+		return models.SerialReturnedToVendor
+	default:
+		return models.SerialRemoved
+	}
+}
+
+// findSerials loads the serials of a product by number.
+func findSerials(ctx context.Context, productID primitive.ObjectID, numbers []string) (map[string]models.Serial, error) {
+	cursor, err := Serials.Find(ctx, bson.M{"product_id": productID, "serial_number": bson.M{"$in": numbers}})
+	if err != nil {
+		return nil, err
+	}
+	var found []models.Serial
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	serials := make(map[string]models.Serial, len(found))
+	for _, s := range found {
+		serials[s.SerialNumber] = s
+	}
+	return serials, nil
+}
+
+// postSerials moves the serials of m into or out of stock and records the
+// movement in their history. m.ID must be set.
+func postSerials(ctx context.Context, m *models.StockMovement, now time.Time) error {
+	if len(m.Serials) != max(m.Quantity, -m.Quantity) {
+		return ErrSerialsRequired
+	}
+	seen := make(map[string]bool, len(m.Serials))
+	for _, sn := range m.Serials {
+		if seen[sn] {
+			return &SerialError{Serials: []string{sn}, Reason: "are listed twice"}
+		}
+		seen[sn] = true
+	}
+	existing, err := findSerials(ctx, m.ProductId, m.Serials)
+	if err != nil {
+		return err
+	}
+
+	event := models.SerialEvent{
+		Location:   m.Location,
+		Type:       m.Type,
+		MovementId: m.ID,
+		RefType:    m.RefType,
+		RefId:      m.RefId,
+		By:         m.CreatedBy,
+		At:         now,
+	}
+	var bad []string
+	if m.Quantity > 0 {
+		event.Status = models.SerialInStock
+		for _, sn := range m.Serials {
+			if existing[sn].Status == models.SerialInStock {
+				bad = append(bad, sn)
+			}
+		}
+		if len(bad) > 0 {
+			return &SerialError{Serials: bad, Reason: "are already in stock"}
+		}
+		for _, sn := range m.Serials {
+			if s, ok := existing[sn]; ok {
+				update := bson.M{
+					"$set":  bson.M{"status": models.SerialInStock, "location": m.Location, "lot": m.Lot, "updatedTime": now},
+					"$push": bson.M{"history": event},
+				}
+				// The status guard, like the unique index for new serials,
+				// stops a concurrent receipt of the same unit.
+				result, err := Serials.UpdateOne(ctx, bson.M{"_id": s.ID, "status": bson.M{"$ne": models.SerialInStock}}, update)
+				if err != nil {
+					return err
+				}
+				if result.MatchedCount == 0 {
+					return &SerialError{Serials: []string{sn}, Reason: "are already in stock"}
+				}
+				continue
+			}
+			_, err := Serials.InsertOne(ctx, &models.Serial{
+				ID:           primitive.NewObjectID(),
+				ProductId:    m.ProductId,
+				SerialNumber: sn,
+				Status:       models.SerialInStock,
+				Location:     m.Location,
+				Lot:          m.Lot,
+				History:      []models.SerialEvent{event},
+				CreatedTime:  now,
+				UpdatedTime:  now,
+			})
+			if mongo.IsDuplicateKeyError(err) {
+				return &SerialError{Serials: []string{sn}, Reason: "are already in stock"}
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	event.Status = serialStatus(m.Type)
+	for _, sn := range m.Serials {
+		s, ok := existing[sn]
+		if !ok || s.Status != models.SerialInStock || s.Location != m.Location || s.Lot != m.Lot {
+			bad = append(bad, sn)
+		}
+	}
+	if len(bad) > 0 {
+		return &SerialError{Serials: bad, Reason: "are not in stock at " + m.Location}
+	}
+	filter := bson.M{"product_id": m.ProductId, "serial_number": bson.M{"$in": m.Serials}, "status": models.SerialInStock}
+	update := bson.M{
+		"$set":  bson.M{"status": event.Status, "updatedTime": now},
+		"$push": bson.M{"history": event},
+	}
+	_, err = Serials.UpdateMany(ctx, filter, update)
+	return err
+}
+
+// issueSerials takes the units listed in m.Serials out of stock wherever
+// they are, posting one movement like m per location and lot.
+func issueSerials(ctx context.Context, m models.StockMovement, quantity int) error {
+	if len(m.Serials) != quantity {
+		return ErrSerialsRequired
+	}
+	existing, err := findSerials(ctx, m.ProductId, m.Serials)
+	if err != nil {
+		return err
+	}
+	type place struct{ location, lot string }
+	groups := make(map[place][]string)
+	var bad []string
+	for _, sn := range m.Serials {
+		s, ok := existing[sn]
+		if !ok || s.Status != models.SerialInStock {
+			bad = append(bad, sn)
+			continue
+		}
+		key := place{s.Location, s.Lot}
+		groups[key] = append(groups[key], sn)
+	}
+	if len(bad) > 0 {
+		return &SerialError{Serials: bad, Reason: "are not in stock"}
+	}
+	places := make([]place, 0, len(groups))
+	for key := range groups {
+		places = append(places, key)
+	}
+	sort.Slice(places, func(i, j int) bool {
+		if places[i].location != places[j].location {
+			return places[i].location < places[j].location
+		}
+		return places[i].lot < places[j].lot
+	})
+	for _, key := range places {
+		movement := m
+		movement.ID = primitive.NilObjectID
+		movement.Location = key.location
+		movement.Lot = key.lot
+		movement.Serials = groups[key]
+		movement.Quantity = -len(groups[key])
+		if err := Post(ctx, &movement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindSerial returns every serial with the number sn, across products.
+func FindSerial(ctx context.Context, sn string) ([]models.Serial, error) {
+	cursor, err := Serials.Find(ctx, bson.M{"serial_number": sn})
+	if err != nil {
+		return nil, err
+	}
+	serials := make([]models.Serial, 0)
+	if err := cursor.All(ctx, &serials); err != nil {
+		return nil, err
+	}
+	return serials, nil
+}
@@ -0,0 +1,199 @@
+package stock
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/yashaswini7291/Inventory/database"
+	"github.com/yashaswini7291/Inventory/models"
+	"github.com/yashaswini7291/Inventory/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SnapshotInterval is how often the snapshot job looks for days that ended
+// without a snapshot.
+const SnapshotInterval = time.Hour
+
+// snapshotSettle is how long after midnight a day is snapshot, so that
+// movements posted at its very end have committed.
+const snapshotSettle = 5 * time.Minute
+
+var (
+	SnapshotData *mongo.Collection = database.OpenCollection(database.Client, "StockSnapshots")
+
+	Snapshots = repository.Scoped(SnapshotData)
+)
+
+// EndOfDay returns the last instant of the UTC day of t that MongoDB can
+// store.
+func EndOfDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour).Add(24*time.Hour - time.Millisecond)
+}
+
+// Balances returns the stock of products by location at asOf, starting
+// from the latest snapshot at or before asOf and adding the movements
+// posted since. Balances without stock or value are left out. productIDs
+// limits the balances to those products when it is not empty.
+func Balances(ctx context.Context, asOf time.Time, productIDs ...primitive.ObjectID) ([]models.StockBalance, error) {
+	type key struct {
+		productID primitive.ObjectID
+		location  string
+	}
+	balances := make(map[key]*models.StockBalance)
+	wanted := make(map[primitive.ObjectID]bool, len(productIDs))
+	for _, id := range productIDs {
+		wanted[id] = true
+	}
+
+	var base models.StockSnapshot
+	opts := options.FindOne().SetSort(bson.D{{Key: "as_of", Value: -1}})
+	err := Snapshots.FindOne(ctx, bson.M{"as_of": bson.M{"$lte": asOf}}, opts).Decode(&base)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	for _, b := range base.Balances {
+		if len(wanted) > 0 && !wanted[b.ProductId] {
+			continue
+		}
+		balance := b
+		balances[key{b.ProductId, b.Location}] = &balance
+	}
+
+	created := bson.M{"$lte": asOf}
+	if !base.AsOf.IsZero() {
+		created["$gt"] = base.AsOf
+	}
+	match := bson.M{"createdTime": created}
+	if len(productIDs) > 0 {
+		match["product_id"] = bson.M{"$in": productIDs}
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     bson.M{"product_id": "$product_id", "location": "$location"},
+			"on_hand": bson.M{"$sum": "$quantity"},
+			"value":   bson.M{"$sum": "$value"},
+		}}},
+	}
+	cursor, err := Movements.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var changes []struct {
+		Key struct {
+			ProductId primitive.ObjectID `bson:"product_id"`
+			Location  string             `bson:"location"`
+		} `bson:"_id"`
+		OnHand int     `bson:"on_hand"`
+		Value  float64 `bson:"value"`
+	}
+	if err := cursor.All(ctx, &changes); err != nil {
+		return nil, err
+	}
+	for _, change := range changes {
+		k := key{change.Key.ProductId, change.Key.Location}
+		if balances[k] == nil {
+			balances[k] = &models.StockBalance{ProductId: k.productID, Location: k.location}
+		}
+		balances[k].OnHand += change.OnHand
+		balances[k].Value += change.Value
+	}
+
+	result := make([]models.StockBalance, 0, len(balances))
+	for _, balance := range balances {
+		balance.Value = roundMoney(balance.Value)
+		if balance.OnHand == 0 && balance.Value == 0 {
+			continue
+		}
+		result = append(result, *balance)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ProductId != result[j].ProductId {
+			return result[i].ProductId.Hex() < result[j].ProductId.Hex()
+		}
+		return result[i].Location < result[j].Location
+	})
+	return result, nil
+}
+
+// TakeSnapshot records the balances at the end of day. A day that already
+// has a snapshot keeps it; the result tells whether one was taken.
+func TakeSnapshot(ctx context.Context, day time.Time) (bool, error) {
+	asOf := EndOfDay(day)
+	balances, err := Balances(ctx, asOf)
+	if err != nil {
+		return false, err
+	}
+	date := asOf.Format(time.DateOnly)
+	insert := bson.M{
+		"_id":         primitive.NewObjectID(),
+		"as_of":       asOf,
+		"balances":    balances,
+		"createdTime": time.Now().UTC(),
+	}
+	result, err := Snapshots.UpdateOne(ctx, bson.M{"date": date}, bson.M{"$setOnInsert": insert}, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// Another instance took it at the same moment.
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return result.UpsertedCount > 0, nil
+}
+
+// SnapshotAll snapshots every day that ended since the last snapshot, for
+// every organization with stock movements. Organizations without a
+// snapshot start with the last day that ended.
+func SnapshotAll(ctx context.Context) error {
+	orgIDs, err := MovementData.Distinct(ctx, repository.OrgField, bson.M{})
+	if err != nil {
+		return err
+	}
+	latest := time.Now().UTC().Add(-snapshotSettle).Truncate(24*time.Hour).AddDate(0, 0, -1)
+	for _, v := range orgIDs {
+		orgID, ok := v.(string)
+		if !ok || orgID == "" {
+			continue
+		}
+		orgCtx := repository.WithOrg(ctx, orgID)
+
+		day := latest
+		var last models.StockSnapshot
+		opts := options.FindOne().SetSort(bson.D{{Key: "as_of", Value: -1}}).SetProjection(bson.M{"as_of": 1})
+		err := Snapshots.FindOne(orgCtx, bson.M{}, opts).Decode(&last)
+		switch {
+		case err == nil:
+			day = last.AsOf.UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+		case err != mongo.ErrNoDocuments:
+			return err
+		}
+		for ; !day.After(latest); day = day.AddDate(0, 0, 1) {
+			if _, err := TakeSnapshot(orgCtx, day); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RunSnapshots calls SnapshotAll every interval until ctx is cancelled.
+func RunSnapshots(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := SnapshotAll(ctx); err != nil {
+				slog.Error("taking stock snapshots failed", "error", err)
+			}
+		}
+	}
+}
@@ -1,3 +1,4 @@
+import os
 import requests
 
 BASE_URL = "http://localhost:8080"  # Change this to your server URL
@@ -15,8 +16,11 @@ def print_result(test_name, passed, expected=None, got=None, request_data=None,
             print(f"  Response Body: {response_body}")
 
 def test_register_user():
+    # Start the server with BOOTSTRAP_ADMINS=puja and the same
+    # BOOTSTRAP_SECRET as this script so the test user becomes the admin.
     payload = {"username": "puja", "password": "mypassword"}
-    res = requests.post(f"{BASE_URL}/register", json=payload)
+    headers = {"X-Bootstrap-Secret": os.environ.get("BOOTSTRAP_SECRET", "")}
+    res = requests.post(f"{BASE_URL}/register", json=payload, headers=headers)
     passed = res.status_code in [201, 409]
     print_result("User Registration", passed, "201 or 409", res.status_code, payload, res.text)
 
@@ -34,6 +38,19 @@ def test_login():
     print_result("Login Test", passed, {"username": payload["username"], "password": payload["password"]}, res.text, payload, res.text)
     return token
 
+def test_create_organization(token):
+    # Products belong to an organization. The test user must be an admin,
+    # see test_register_user.
+    payload = {"name": "Test Store"}
+    res = requests.post(f"{BASE_URL}/orgs", json=payload, headers={"Authorization": f"Bearer {token}"})
+    if res.status_code != 201:
+        print_result("Create Organization", False, 201, res.status_code, payload, res.text)
+        return token
+    print("Create Organization: PASSED")
+    # The first organization an admin creates is joined and comes with a
+    # new token; later runs keep the existing membership.
+    return res.json().get("access_token") or token
+
 def test_add_product(token):
     payload = {
         "name": "Phone",
@@ -41,7 +58,7 @@ def test_add_product(token):
         "sku": "PHN-001",
         "image_url": "https://example.com/phone.jpg",
         "description": "Latest Phone",
-        "quantity": 5,
+        "on_hand": 5,
         "price": 999.99
     }
     res = requests.post(f"{BASE_URL}/products", json=payload, headers={"Authorization": f"Bearer {token}"})
@@ -57,7 +74,7 @@ def test_add_product(token):
         return None
 
 def test_update_quantity(token, product_id, new_quantity):
-    payload = {"quantity": new_quantity}
+    payload = {"quantity": new_quantity, "reason": "correction"}
     res = requests.put(
         f"{BASE_URL}/products/{product_id}/quantity",
         json=payload,
@@ -67,7 +84,7 @@ def test_update_quantity(token, product_id, new_quantity):
     if passed:
         try:
             updated_info = res.json()
-            updated_qty = updated_info.get("quantity", "unknown")
+            updated_qty = updated_info.get("on_hand", "unknown")
             print(f"Update Quantity: PASSED, Updated quantity: {updated_qty}")
         except:
             print("Update Quantity: PASSED, but response body is not valid JSON")
@@ -89,7 +106,7 @@ def test_get_products(token, expected_quantity):
         print("Get Products: FAILED")
         print("  Could not find product named 'Phone'")
         return
-    phone_quantity = phone_products[0].get("quantity")
+    phone_quantity = phone_products[0].get("on_hand")
     if phone_quantity == expected_quantity:
         print(f"Get Products: PASSED (Quantity = {phone_quantity})")
     else:
@@ -102,6 +119,7 @@ def run_all_tests():
     if not token:
         print("Login failed. Skipping further tests.")
         return
+    token = test_create_organization(token)
     product_id = test_add_product(token)
     if not product_id:
         print("Product creation failed. Skipping further tests.")
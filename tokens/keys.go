@@ -0,0 +1,479 @@
+package tokens
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/yashaswini7291/Inventory/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Supported signing algorithms.
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+// SigningKey is one key known to the KeyManager. Tokens are signed with the
+// newest key that has not retired and verified with any key that has not
+// expired, so tokens signed just before a rotation stay valid.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	CreatedAt time.Time
+	// RetireAt is when the key stops signing; zero means never.
+	RetireAt time.Time
+	// ExpiresAt is when tokens signed by the key stop verifying; zero
+	// means never.
+	ExpiresAt time.Time
+
+	private interface{}
+	public  interface{}
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+func (k *SigningKey) started(now time.Time) bool {
+	return !now.Before(k.CreatedAt)
+}
+
+func (k *SigningKey) retired(now time.Time) bool {
+	return !k.RetireAt.IsZero() && !now.Before(k.RetireAt)
+}
+
+func (k *SigningKey) expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt)
+}
+
+// KeyManager holds the signing keys. Static keys come from the environment;
+// when rotation is enabled new keys are generated on schedule and stored in
+// Mongo so every instance signs and verifies with the same set.
+type KeyManager struct {
+	mu     sync.RWMutex
+	static []*SigningKey
+	keys   map[string]*SigningKey
+	// legacy verifies tokens issued before kid headers were introduced,
+	// which were all signed with SECRET_KEY.
+	legacy *SigningKey
+
+	algorithm string
+	rotation  time.Duration
+	overlap   time.Duration
+}
+
+// Keys is the process wide key manager used by TokenGenerator and
+// ValidateToken. It is empty until InitKeys succeeds.
+var Keys = &KeyManager{keys: map[string]*SigningKey{}}
+
+var KeyData *mongo.Collection = database.OpenCollection(database.Client, "SigningKeys")
+
+// storedKey is the Mongo representation of a generated key.
+type storedKey struct {
+	ID         string    `bson:"_id"`
+	Algorithm  string    `bson:"algorithm"`
+	PrivateKey []byte    `bson:"private_key"`
+	CreatedAt  time.Time `bson:"created_at"`
+	RetireAt   time.Time `bson:"retire_at"`
+	ExpiresAt  time.Time `bson:"expires_at"`
+}
+
+// InitKeys configures Keys from the environment and refuses to continue
+// without a usable signing key:
+//
+//	JWT_ALGORITHM          HS256 (default), RS256 or ES256
+//	SECRET_KEY             HMAC secret for HS256
+//	SECRET_KEY_PREVIOUS    an older HMAC secret accepted for a token lifetime
+//	JWT_PRIVATE_KEY_FILE   PEM private key for RS256 or ES256
+//	JWT_KEY_ID             kid for the configured key (derived if unset)
+//	JWT_ROTATION_INTERVAL  generate a new key this often, e.g. 720h
+//	JWT_KEY_OVERLAP        keep verifying retired keys this long (default 168h)
+func InitKeys(ctx context.Context) error {
+	algorithm := os.Getenv("JWT_ALGORITHM")
+	if algorithm == "" {
+		algorithm = HS256
+	}
+	if algorithm != HS256 && algorithm != RS256 && algorithm != ES256 {
+		return fmt.Errorf("unsupported JWT_ALGORITHM %q", algorithm)
+	}
+	m := &KeyManager{keys: map[string]*SigningKey{}, algorithm: algorithm, overlap: RefreshTokenTTL}
+
+	if v := os.Getenv("JWT_KEY_OVERLAP"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid JWT_KEY_OVERLAP: %w", err)
+		}
+		m.overlap = d
+	}
+	if v := os.Getenv("JWT_ROTATION_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid JWT_ROTATION_INTERVAL %q", v)
+		}
+		m.rotation = d
+	}
+
+	switch algorithm {
+	case HS256:
+		if secret := os.Getenv("SECRET_KEY"); secret != "" {
+			m.legacy = hmacKey(os.Getenv("JWT_KEY_ID"), secret)
+			m.static = append(m.static, m.legacy)
+		}
+		if previous := os.Getenv("SECRET_KEY_PREVIOUS"); previous != "" {
+			// The previous secret never signs, and stops verifying once
+			// every token it could have signed before the restart has
+			// expired.
+			key := hmacKey("", previous)
+			key.RetireAt = time.Unix(0, 0)
+			key.ExpiresAt = time.Now().Add(max(AccessTokenTTL, RefreshTokenTTL))
+			m.static = append(m.static, key)
+		}
+	default:
+		if path := os.Getenv("JWT_PRIVATE_KEY_FILE"); path != "" {
+			key, err := loadPrivateKey(path, algorithm, os.Getenv("JWT_KEY_ID"))
+			if err != nil {
+				return err
+			}
+			m.static = append(m.static, key)
+		}
+	}
+
+	if m.rotation == 0 && len(m.static) == 0 {
+		if algorithm == HS256 {
+			return errors.New("no signing key: set SECRET_KEY or JWT_ROTATION_INTERVAL")
+		}
+		return errors.New("no signing key: set JWT_PRIVATE_KEY_FILE or JWT_ROTATION_INTERVAL")
+	}
+	if err := m.Refresh(ctx); err != nil {
+		return err
+	}
+	if _, err := m.signingKey(); err != nil {
+		return err
+	}
+
+	Keys = m
+	return nil
+}
+
+func hmacKey(kid string, secret string) *SigningKey {
+	if kid == "" {
+		sum := sha256.Sum256([]byte(secret))
+		kid = "hs-" + hex.EncodeToString(sum[:])[:12]
+	}
+	return &SigningKey{ID: kid, Algorithm: HS256, private: []byte(secret), public: []byte(secret)}
+}
+
+func loadPrivateKey(path string, algorithm string, kid string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading JWT_PRIVATE_KEY_FILE: %w", err)
+	}
+	key, err := parsePrivateKey(data, algorithm)
+	if err != nil {
+		return nil, fmt.Errorf("JWT_PRIVATE_KEY_FILE: %w", err)
+	}
+	if kid != "" {
+		key.ID = kid
+	}
+	return key, nil
+}
+
+func parsePrivateKey(data []byte, algorithm string) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &SigningKey{Algorithm: algorithm, private: parsed}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		if algorithm != RS256 {
+			return nil, fmt.Errorf("RSA key cannot be used with %s", algorithm)
+		}
+		key.public = &k.PublicKey
+	case *ecdsa.PrivateKey:
+		if algorithm != ES256 || k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("ES256 needs a P-256 key")
+		}
+		key.public = &k.PublicKey
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", parsed)
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.public)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+	key.ID = hex.EncodeToString(sum[:])[:16]
+	return key, nil
+}
+
+func generateKey(algorithm string) (*SigningKey, []byte, error) {
+	var private crypto.Signer
+	var err error
+	switch algorithm {
+	case HS256:
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, nil, err
+		}
+		return &SigningKey{Algorithm: HS256, private: secret, public: secret}, secret, nil
+	case RS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case ES256:
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, nil, err
+	}
+	encoded := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	key, err := parsePrivateKey(encoded, algorithm)
+	return key, encoded, err
+}
+
+func decodeStoredKey(s storedKey) (*SigningKey, error) {
+	var key *SigningKey
+	if s.Algorithm == HS256 {
+		key = &SigningKey{Algorithm: HS256, private: s.PrivateKey, public: s.PrivateKey}
+	} else {
+		var err error
+		if key, err = parsePrivateKey(s.PrivateKey, s.Algorithm); err != nil {
+			return nil, err
+		}
+	}
+	key.ID = s.ID
+	key.CreatedAt = s.CreatedAt
+	key.RetireAt = s.RetireAt
+	key.ExpiresAt = s.ExpiresAt
+	return key, nil
+}
+
+// Refresh reloads generated keys from Mongo, generates a new key when the
+// current one is due for rotation and forgets expired keys.
+func (m *KeyManager) Refresh(ctx context.Context) error {
+	keys := map[string]*SigningKey{}
+	for _, k := range m.static {
+		keys[k.ID] = k
+	}
+	if m.rotation == 0 {
+		m.mu.Lock()
+		m.keys = keys
+		m.mu.Unlock()
+		return nil
+	}
+
+	// The next period's key is created ahead of time so every instance
+	// has loaded it before anyone signs with it.
+	now := time.Now().UTC()
+	if err := m.rotate(ctx, now); err != nil {
+		return err
+	}
+	if err := m.rotate(ctx, now.Add(m.rotation)); err != nil {
+		return err
+	}
+	if _, err := KeyData.DeleteMany(ctx, bson.M{"expires_at": bson.M{"$lte": now}}); err != nil {
+		return err
+	}
+	cursor, err := KeyData.Find(ctx, bson.M{"algorithm": m.algorithm})
+	if err != nil {
+		return err
+	}
+	var stored []storedKey
+	if err := cursor.All(ctx, &stored); err != nil {
+		return err
+	}
+	for _, s := range stored {
+		key, err := decodeStoredKey(s)
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", s.ID, err)
+		}
+		keys[key.ID] = key
+	}
+
+	m.mu.Lock()
+	m.keys = keys
+	m.mu.Unlock()
+	return nil
+}
+
+// rotate stores the key for the rotation period containing now unless
+// another instance already did. Keys are named after their period, so concurrent
+// rotations collide on _id instead of creating two keys.
+func (m *KeyManager) rotate(ctx context.Context, now time.Time) error {
+	period := now.UnixNano() / int64(m.rotation)
+	start := time.Unix(0, period*int64(m.rotation)).UTC()
+	id := "rot-" + strconv.FormatInt(period, 10)
+
+	count, err := KeyData.CountDocuments(ctx, bson.M{"_id": id})
+	if err != nil || count > 0 {
+		return err
+	}
+	key, encoded, err := generateKey(m.algorithm)
+	if err != nil {
+		return err
+	}
+	retire := start.Add(m.rotation)
+	_, err = KeyData.InsertOne(ctx, storedKey{
+		ID:         id,
+		Algorithm:  key.Algorithm,
+		PrivateKey: encoded,
+		CreatedAt:  start,
+		RetireAt:   retire,
+		ExpiresAt:  retire.Add(m.overlap),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	if err == nil {
+		slog.Info("generated signing key", "kid", id, "algorithm", key.Algorithm)
+	}
+	return err
+}
+
+// Run refreshes the key set every interval until ctx is done.
+func (m *KeyManager) Run(ctx context.Context, interval time.Duration) {
+	if m.rotation == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.Refresh(ctx); err != nil {
+				slog.Error("refreshing signing keys failed", "error", err)
+			}
+		}
+	}
+}
+
+// signingKey returns the newest key that may still sign.
+func (m *KeyManager) signingKey() (*SigningKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := time.Now()
+	var candidates []*SigningKey
+	for _, k := range m.keys {
+		if k.started(now) && !k.retired(now) && !k.expired(now) {
+			candidates = append(candidates, k)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, errors.New("no active signing key")
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].CreatedAt.After(candidates[j].CreatedAt)
+	})
+	return candidates[0], nil
+}
+
+// Sign signs claims with the active key and records its kid in the header.
+func (m *KeyManager) Sign(claims jwt.Claims) (string, error) {
+	key, err := m.signingKey()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.private)
+}
+
+// Keyfunc finds the verification key named by the token's kid. The
+// algorithm in the header must match the key's so an RSA public key can
+// never be used as an HMAC secret.
+func (m *KeyManager) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	m.mu.RLock()
+	key, ok := m.keys[kid]
+	if kid == "" && m.legacy != nil {
+		key, ok = m.legacy, true
+	}
+	m.mu.RUnlock()
+	if !ok || key.expired(time.Now()) {
+		return nil, errors.New("unknown signing key")
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, errors.New("unexpected signing method")
+	}
+	return key.public, nil
+}
+
+// JWK is a public key in RFC 7517 format.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS returns the public keys that may still verify tokens. HMAC keys are
+// secret and never published.
+func (m *KeyManager) JWKS() []JWK {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := time.Now()
+	keys := make([]JWK, 0, len(m.keys))
+	for _, k := range m.keys {
+		if k.expired(now) {
+			continue
+		}
+		b64 := base64.RawURLEncoding.EncodeToString
+		switch pub := k.public.(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, JWK{Kty: "RSA", Kid: k.ID, Use: "sig", Alg: k.Algorithm,
+				N: b64(pub.N.Bytes()), E: b64(big.NewInt(int64(pub.E)).Bytes())})
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			keys = append(keys, JWK{Kty: "EC", Kid: k.ID, Use: "sig", Alg: k.Algorithm, Crv: "P-256",
+				X: b64(pub.X.FillBytes(make([]byte, size))), Y: b64(pub.Y.FillBytes(make([]byte, size)))})
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Kid < keys[j].Kid })
+	return keys
+}
@@ -2,12 +2,11 @@ package tokens
 
 import (
 	"context"
-	"log"
-	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt"
 	"github.com/yashaswini7291/Inventory/database"
+	"github.com/yashaswini7291/Inventory/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -16,42 +15,106 @@ import (
 
 type SignedDetails struct {
 	UserName string
+	// OrgId is the organization the user belonged to when the token was
+	// issued. Authentication rejects the token once that changes.
+	OrgId string `json:"org_id,omitempty"`
+	// Purpose is empty for access tokens. Other tokens are rejected by
+	// ValidateToken so they cannot be used to call the API.
+	Purpose string `json:"purpose,omitempty"`
 	jwt.StandardClaims
 }
 
-var UserData *mongo.Collection = database.UserData(database.Client, "Users")
+const (
+	PurposeRefresh     = "refresh"
+	PurposeMFARequired = "mfa_required"
+)
+
+// Lifetimes of the tokens issued at login.
+const (
+	AccessTokenTTL  = 24 * time.Hour
+	RefreshTokenTTL = 168 * time.Hour
+)
 
-var SECRET_KEY = os.Getenv("SECRET_KEY")
+// ChallengeTTL is how long a user has to enter their second factor after
+// a successful password check.
+const ChallengeTTL = 5 * time.Minute
 
-func TokenGenerator(userName string) (signedToken string, signedRefreshToken string, err error) {
+var UserData *mongo.Collection = database.UserData(database.Client, "Users")
+
+// TokenGenerator signs an access token and a refresh token for the user.
+// The user ID is carried in the standard "jti" claim, which
+// middleware.Authentication exposes as "uid", and the tenant in "org_id".
+func TokenGenerator(userName string, uid string, orgID string) (signedToken string, signedRefreshToken string, err error) {
 	claims := &SignedDetails{
 		UserName: userName,
+		OrgId:    orgID,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(24)).Unix(),
+			Id:        uid,
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Local().Add(AccessTokenTTL).Unix(),
 		},
 	}
 	refreshclaims := &SignedDetails{
+		OrgId:   orgID,
+		Purpose: PurposeRefresh,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(168)).Unix(),
+			Id:        uid,
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Local().Add(RefreshTokenTTL).Unix(),
 		},
 	}
-	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SECRET_KEY))
+	token, err := Keys.Sign(claims)
 	if err != nil {
 		return "", "", err
 	}
 
-	refreshtoken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshclaims).SignedString([]byte(SECRET_KEY))
+	refreshtoken, err := Keys.Sign(refreshclaims)
 	if err != nil {
-		log.Panic(err)
-		return
+		return "", "", err
 	}
 	return token, refreshtoken, err
 }
 
+// ChallengeGenerator signs the short-lived token returned by Login when
+// the user has two-factor authentication enabled. It only proves that the
+// password was correct and is exchanged for real tokens by ValidateChallenge.
+func ChallengeGenerator(userName string, uid string) (string, error) {
+	claims := &SignedDetails{
+		UserName: userName,
+		Purpose:  PurposeMFARequired,
+		StandardClaims: jwt.StandardClaims{
+			Id:        uid,
+			ExpiresAt: time.Now().Local().Add(ChallengeTTL).Unix(),
+		},
+	}
+	return Keys.Sign(claims)
+}
+
 func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
-	token, err := jwt.ParseWithClaims(signedToken, &SignedDetails{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(SECRET_KEY), nil
-	})
+	claims, msg = parse(signedToken)
+	if msg != "" {
+		return nil, msg
+	}
+	if claims.Purpose != "" {
+		return nil, "the token is invalid"
+	}
+	return claims, msg
+}
+
+// ValidateChallenge accepts only tokens issued by ChallengeGenerator.
+func ValidateChallenge(signedToken string) (claims *SignedDetails, msg string) {
+	claims, msg = parse(signedToken)
+	if msg != "" {
+		return nil, msg
+	}
+	if claims.Purpose != PurposeMFARequired {
+		return nil, "the token is invalid"
+	}
+	return claims, msg
+}
+
+func parse(signedToken string) (claims *SignedDetails, msg string) {
+	token, err := jwt.ParseWithClaims(signedToken, &SignedDetails{}, Keys.Keyfunc)
 
 	if err != nil {
 		msg = err.Error()
@@ -68,31 +131,84 @@ func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
 	}
 	return claims, msg
 }
-func UpdateAllTokens(signedToken string, signedRefreshToken string, userID string) {
-	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+
+// UpdateAllTokens stores the latest token pair on the user document.
+func UpdateAllTokens(ctx context.Context, signedToken string, signedRefreshToken string, userID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 100*time.Second)
+	defer cancel()
 
 	var updateobj primitive.D
 
 	updateobj = append(updateobj, bson.E{Key: "access_token", Value: signedToken})
 	updateobj = append(updateobj, bson.E{Key: "refreshToken", Value: signedRefreshToken})
 	updateTime, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-	updateobj = append(updateobj, bson.E{Key: "updateTime", Value: updateTime})
+	updateobj = append(updateobj, bson.E{Key: "updatedTime", Value: updateTime})
 
-	upsert := true
-
-	filter := bson.M{"user_id": userID}
-	opt := options.UpdateOptions{
-		Upsert: &upsert,
-	}
+	filter := bson.M{"userId": userID}
 
 	_, err := UserData.UpdateOne(ctx, filter, bson.D{
 		{Key: "$set", Value: updateobj},
-	},
-		&opt)
-	defer cancel()
+	})
+	return err
+}
 
+// RemoveStrayTokenDocuments deletes the documents that UpdateAllTokens used
+// to upsert into Users. They were keyed by user_id, a field user documents
+// do not have, so every login created one instead of updating the user.
+func RemoveStrayTokenDocuments(ctx context.Context) (int64, error) {
+	result, err := UserData.DeleteMany(ctx, bson.M{
+		"user_id": bson.M{"$exists": true},
+		"userId":  bson.M{"$exists": false},
+	})
 	if err != nil {
-		log.Panic(err)
-		return
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// RevokeUserTokens invalidates every token issued to the user before now,
+// for example after a password reset.
+func RevokeUserTokens(ctx context.Context, userID string) error {
+	update := bson.M{
+		"$set":   bson.M{"tokens_revoked_at": time.Now().UTC().Truncate(time.Second)},
+		"$unset": bson.M{"access_token": "", "refreshToken": ""},
+	}
+	_, err := UserData.UpdateOne(ctx, bson.M{"userId": userID}, update)
+	return err
+}
+
+// Session is the part of the user document that Authentication checks on
+// every request, so that revocations, role changes and disabled accounts
+// apply immediately.
+type Session struct {
+	Role      string     `bson:"role"`
+	Status    string     `bson:"status"`
+	OrgId     string     `bson:"org_id"`
+	RevokedAt *time.Time `bson:"tokens_revoked_at"`
+}
+
+// LoadSession returns the session state of the user, or nil if that user
+// no longer exists.
+func LoadSession(ctx context.Context, userID string) (*Session, error) {
+	var session Session
+	opts := options.FindOne().SetProjection(bson.M{"role": 1, "status": 1, "org_id": 1, "tokens_revoked_at": 1})
+	err := UserData.FindOne(ctx, bson.M{"userId": userID}, opts).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
 	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Disabled reports whether an administrator has disabled the account.
+func (s *Session) Disabled() bool {
+	return s.Status == models.StatusDisabled
+}
+
+// Revokes reports whether claims were issued before the user's tokens were
+// last revoked.
+func (s *Session) Revokes(claims *SignedDetails) bool {
+	return s.RevokedAt != nil && claims.IssuedAt < s.RevokedAt.Unix()
 }
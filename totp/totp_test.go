@@ -0,0 +1,84 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of RFC 6238 Appendix B, "12345678901234567890",
+// base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// withClock points Now at t for the duration of the test.
+func withClock(t *testing.T, at time.Time) {
+	t.Helper()
+	previous := Now
+	Now = func() time.Time { return at }
+	t.Cleanup(func() { Now = previous })
+}
+
+func TestCodeRFC6238(t *testing.T) {
+	// The RFC lists 8 digit codes; a 6 digit code is their last 6 digits.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		withClock(t, time.Unix(tt.unix, 0))
+		got, err := Code(rfcSecret, Step(Now()))
+		if err != nil {
+			t.Fatalf("Code at %d: %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+		if _, ok := Validate(rfcSecret, tt.want, Now(), 0); !ok {
+			t.Errorf("Validate at %d rejected %s", tt.unix, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	withClock(t, time.Unix(1111111111, 0))
+	current := Step(Now())
+	code := func(step int64) string {
+		c, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", code(current), 0, current, true},
+		{"previous step within skew", code(current - 1), 0, current - 1, true},
+		{"next step within skew", code(current + 1), 0, current + 1, true},
+		{"outside skew", code(current - 2), 0, 0, false},
+		{"replayed step", code(current), current, 0, false},
+		{"step before the last one used", code(current - 1), current - 1, 0, false},
+		{"later step after a used one", code(current + 1), current, current + 1, true},
+		{"wrong length", "12345", 0, 0, false},
+		{"surrounding space", " " + code(current) + " ", 0, current, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tt.code, Now(), tt.lastStep)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("Validate = (%d, %v), want (%d, %v)", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}